@@ -0,0 +1,277 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "io"
+import "os"
+import "net"
+import "path"
+import "sync"
+import "time"
+import "strconv"
+import "strings"
+import "math/rand"
+import "path/filepath"
+import "encoding/json"
+import "fmt"
+
+import "github.com/pelletier/go-toml"
+import "github.com/Sirupsen/logrus"
+
+// Configuration of the access log, as it is decoded from the config
+// section app.access-log. The presence of the section enables the log.
+// Format is one of combined, json or logfmt; output is one of stdout,
+// stderr, journal or a file path relative to the app root. Sample is the
+// share of requests logged; slow is the threshold that raises the level.
+type accessConfig struct {
+    Format string `config:"format" default:"combined" validate:"enum=combined|json|logfmt"`
+    Output string `config:"output" default:"stdout"` // or a file
+    Sample float64 `config:"sample" default:"1.0" validate:"min=0,max=1"`
+    Slow time.Duration `config:"slow"` // zero disables it
+    Exclude []string `config:"exclude"` // URL path globs
+    Services []accessRule `config:"services"` // overrides
+}
+
+// Override of the sampling rate and the slow request threshold for the
+// requests handled by the service that is mounted under the prefix, as
+// it is decoded from the app.access-log.services array of tables. The
+// values that are absent fall back to those of app.access-log section.
+// This allows to log the chatty services sparingly, and others fully.
+type accessRule struct {
+    Prefix string `config:"prefix,required"` // service
+    Sample *float64 `config:"sample" validate:"min=0,max=1"`
+    Slow *time.Duration `config:"slow"` // threshold
+}
+
+// Access log of the HTTP requests served by the application, built out
+// of the app.access-log config section when the app is being booted.
+// It writes one entry per request, after it has been handled, either
+// into the writer, in the configured format; or into the app journal,
+// as the structured entry. The writer is guarded by the mutex.
+type accessLog struct {
+    accessConfig // as decoded from the config
+    writer io.Writer // nil if written to journal
+    file *os.File // output file, if it is the one
+    sync.Mutex // guards writer, one line at a time
+}
+
+// Single entry of the access log, describing the HTTP request that has
+// been served and the outcome of it. This is what gets formatted, as a
+// line in the access log, or written into the journal as the fields.
+// Level is warning for the slow requests, and info for the rest; the
+// combined format has no place for the level, so it is omitted there.
+type accessEntry struct {
+    Time time.Time `json:"time"` // request accepted
+    Level string `json:"level"` // info or warning
+    Reference string `json:"ref"` // of the context
+    Remote string `json:"ip"` // remote host only
+    Method string `json:"method"` // HTTP verb
+    URL string `json:"url"` // as requested
+    Protocol string `json:"proto"` // HTTP/1.1
+    Status int `json:"status"` // code written
+    Size int64 `json:"size"` // body bytes
+    Duration float64 `json:"duration"` // seconds
+    Referer string `json:"referer,omitempty"`
+    Agent string `json:"agent,omitempty"`
+    Service string `json:"service,omitempty"`
+}
+
+// Install the access log, if the app.access-log section is present in
+// the config. This is invoked by the Boot, once the config has been
+// loaded; the access log is then rebuilt by every reload of the config,
+// see the Reload method. Panics if the section is malformed or the file
+// could not be opened. See the buildAccessLog method for the details.
+func (app *App) installAccessLog() {
+    access := app.buildAccessLog(app.Config)
+    if access == nil { return } // not configured
+    app.access.Store(access) // record requests
+    log := app.Journal.WithField("format", access.Format)
+    log.WithField("output", access.Output).Info("access log enabled")
+}
+
+// Build the access log out of the app.access-log section of the config
+// tree; returns nil if the section is absent. The output file, if it is
+// configured, is opened for appending and created if it does not exist
+// yet; it stays open until the access log gets replaced by the reload.
+// Panics if the section is malformed or file could not be opened.
+func (app *App) buildAccessLog(tree *toml.TomlTree) *accessLog {
+    const eopen = "failed to open access log: %v"
+    if !tree.Has("app.access-log") { return nil }
+    var config accessConfig // app.access-log section
+    err := decodeConfig(tree, "app.access-log", &config)
+    if err != nil { panic(err) } // malformed config
+    access := &accessLog { accessConfig: config }
+    switch config.Output { // where to write entries
+        case "journal": access.writer = nil // fields
+        case "stdout": access.writer = os.Stdout
+        case "stderr": access.writer = os.Stderr
+        default: // a file path, relative to the root
+            full := config.Output // could be absolute
+            if !filepath.IsAbs(full) { full = filepath.Join(app.RootDirectory, full) }
+            const flags = os.O_WRONLY | os.O_APPEND | os.O_CREATE
+            file, err := os.OpenFile(full, flags, 0644)
+            if err != nil { panic(fmt.Errorf(eopen, err)) }
+            access.writer, access.file = file, file
+    } // output of the access log has been set up
+    return access // ready to record the requests
+}
+
+// Close the output file of the access log, if it writes into one. It
+// is invoked once the access log has been replaced by a config reload.
+// The entries of the requests that are still being recorded into the
+// replaced log, if any, are dropped; since its file is closed by then.
+// Nothing happens if the access log is written into any other output.
+func (al *accessLog) close() {
+    if al.file == nil { return } // not a file
+    al.Lock(); defer al.Unlock() // not mid-line
+    al.file.Close() // further writes will fail
+}
+
+// Record the HTTP request, represented by the context, into the access
+// log, once it has been handled. Excluded requests are skipped; others
+// are sampled at the rate configured for the service that handled them;
+// except the slow ones, that are always recorded, at the warning level.
+// Nothing happens if the access log has not been configured at all.
+func (app *App) recordAccess(context *Context, rw *responder) {
+    access := app.access.Load() // as of now
+    if access == nil { return } // not configured
+    r := context.Request // the served request
+    for _, pattern := range access.Exclude { // skip?
+        matched, _ := path.Match(pattern, r.URL.Path)
+        if matched { return } // like health checks
+    } // request is not excluded from the log
+    sample, slow := access.Sample, access.Slow // rates
+    var service string // if request has been routed
+    if context.Service != nil { // got to a service
+        service = context.Service.Prefix // identity
+        for _, rule := range access.Services { // find
+            if rule.Prefix != service { continue }
+            if rule.Sample != nil { sample = *rule.Sample }
+            if rule.Slow != nil { slow = *rule.Slow }
+        } // overrides of the service are applied
+    } // rate and threshold have been determined
+    elapsed := time.Since(context.Created) // duration
+    tardy := slow > 0 && elapsed >= slow // too slow?
+    if !tardy && rand.Float64() >= sample { return }
+    entry := accessEntry { Time: context.Created, Level: "info" }
+    if tardy { entry.Level = "warning" } // raise it
+    entry.Reference, entry.Service = context.Reference, service
+    entry.Remote, _, _ = net.SplitHostPort(r.RemoteAddr)
+    if entry.Remote == "" { entry.Remote = r.RemoteAddr }
+    entry.Method, entry.URL = r.Method, r.RequestURI
+    entry.Protocol, entry.Size = r.Proto, rw.Size()
+    entry.Status = rw.Status() // zero if not written
+    if entry.Status == 0 { entry.Status = 200 } // implied
+    entry.Duration = elapsed.Seconds() // in seconds
+    entry.Referer, entry.Agent = r.Referer(), r.UserAgent()
+    if access.writer == nil { app.journalAccess(entry); return }
+    var line string // formatted entry of the log
+    switch access.Format { // as configured
+        case "json": line = entry.json()
+        case "logfmt": line = entry.logfmt()
+        default: line = entry.combined()
+    } // the entry has been formatted as a line
+    access.Lock(); defer access.Unlock() // serial
+    io.WriteString(access.writer, line + "\n")
+}
+
+// Write the access log entry into the app journal, as the structured
+// entry with the fields, at the level of the entry. This is used when
+// the output of the access log is configured as journal; in that case
+// the format of the access log is ignored, since the formatting is up
+// to the journal itself. The message is the same for all the entries.
+func (app *App) journalAccess(entry accessEntry) {
+    log := app.Journal.WithFields(logrus.Fields {
+        "ref": entry.Reference, // a short UUID
+        "ip": entry.Remote, // remote host only
+        "method": entry.Method, // an HTTP verb
+        "url": entry.URL, // as requested
+        "status": entry.Status, // code written
+        "size": entry.Size, // body bytes written
+        "duration": entry.Duration, // in seconds
+        "service": entry.Service, // or empty
+    }) // the logger is compiled and ready for use
+    if entry.Level == "warning" { // too slow
+        log.Warn("served slow HTTP request")
+        return // the entry has been written
+    } // an ordinary request, not a slow one
+    log.Info("served HTTP request")
+}
+
+// Format the entry in the Apache combined log format; that is, the host,
+// identity, user, time, request line, status, size, referer and agent.
+// Identity and user are never known, so they are always a dash, same
+// as the size when no body has been written. Referer and agent, as well
+// as the request line, are quoted, escaping the embedded quotes.
+func (e accessEntry) combined() string {
+    const layout = "02/Jan/2006:15:04:05 -0700"
+    quote := func(s string) string { // escaped
+        if s == "" { s = "-" } // absent value
+        return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+    } // quoting closure for the textual fields
+    var size string = "-" // no body written
+    if e.Size > 0 { size = strconv.FormatInt(e.Size, 10) }
+    request := e.Method + " " + e.URL + " " + e.Protocol
+    return fmt.Sprintf("%s - - [%s] %s %d %s %s %s", e.Remote,
+        e.Time.Format(layout), quote(request), e.Status, size,
+        quote(e.Referer), quote(e.Agent))
+}
+
+// Format the entry as a single line JSON object, with the keys that
+// are declared by the JSON tags of the accessEntry structure. Time is
+// formatted as RFC 3339, with nanoseconds; duration is in seconds. This
+// format is suitable for the log shippers that parse JSON entries.
+func (e accessEntry) json() string {
+    encoded, err := json.Marshal(e) // flat object
+    if err != nil { return "{}" } // never happens
+    return string(encoded) // without newline
+}
+
+// Format the entry as the logfmt line, that is a sequence of key=value
+// pairs separated by spaces, with the same keys as the JSON format. The
+// values that contain spaces, quotes or equal signs are quoted, and the
+// empty values are omitted altogether. This format is human readable,
+// yet is easily parsed by the log shippers that support the logfmt.
+func (e accessEntry) logfmt() string {
+    var builder strings.Builder // output buffer
+    pair := func(key, value string) { // append one
+        if value == "" { return } // omit empty ones
+        if strings.ContainsAny(value, " \"=\\") {
+            value = strconv.Quote(value) // escaped
+        } // the value is safe to write as is
+        if builder.Len() > 0 { builder.WriteByte(' ') }
+        builder.WriteString(key + "=" + value)
+    } // closure to write a single key=value pair
+    pair("time", e.Time.Format(time.RFC3339Nano))
+    pair("level", e.Level); pair("ref", e.Reference)
+    pair("ip", e.Remote); pair("method", e.Method)
+    pair("url", e.URL); pair("proto", e.Protocol)
+    pair("status", strconv.Itoa(e.Status))
+    pair("size", strconv.FormatInt(e.Size, 10))
+    pair("duration", strconv.FormatFloat(e.Duration, 'f', -1, 64))
+    pair("referer", e.Referer); pair("agent", e.Agent)
+    pair("service", e.Service) // empty if not routed
+    return builder.String() // without newline
+}
@@ -26,12 +26,18 @@ package boot
 import "os"
 import "time"
 import "os/signal"
+import "net"
 import "net/http"
 import "path/filepath"
 import "strings"
 import "regexp"
+import "reflect"
 import "sync"
+import "sync/atomic"
 import "fmt"
+import "syscall"
+
+import stdctx "context"
 
 import "github.com/naoina/denco"
 import "github.com/pelletier/go-toml"
@@ -58,10 +64,24 @@ func New (name, version string) *App {
     application.Storage = Storage { Container: room }
     application.CronEngine = cron.New() // create CRON
     application.Servers = make(map[string]*http.Server)
+    application.listeners = make(map[string] net.Listener)
     application.Reference = shortuuid.New() // V4
     application.Providers = make([]*Provider, 0)
     application.Services = make([]*Service, 0)
     application.TimeLayout = time.RFC850
+    application.Supervisor = &Watchdog {} // default
+    application.hooks = &hookRegistry {} // journal
+    application.hooks.hooks = make(map[string] logrus.Hook)
+    application.GracePeriod = time.Second * 10
+    application.Encoders = defaultEncoders() // JSON etc
+    application.stopped = make(chan struct {})
+    application.stats = &statistics {} // empty
+    application.stats.meters = make(map[string] *meter)
+    application.stats.servers = make(map[string] *connMeter)
+    application.stats.cron = make(map[string] *histogram)
+    lifetime, terminate := stdctx.WithCancel(stdctx.Background())
+    application.lifetime = lifetime // app-wide context
+    application.terminate = terminate // cancel it
     application.Namespace = url // set
     return application // prepared app
 }
@@ -82,17 +102,48 @@ func (app *App) Boot(env, level, root string) {
     app.RootDirectory = filepath.Clean(root)
     app.Journal = app.makeJournal(parsedLevel)
     app.Env = strings.ToLower(strings.TrimSpace(env))
-    app.Config = app.loadConfig(app.Env, "config")
+    tree, sources := app.loadConfig(app.Env, "config")
+    app.current.Store(&configSnapshot { tree, sources })
+    app.Config = tree // as loaded during the boot
+    app.level = parsedLevel // as requested by caller
+    configured, err := app.configLevel(app.Config)
+    if err != nil { panic(err) } // malformed level
+    app.Journal.SetLevel(configured) // may override
+    app.configureJournal() // formatter, output, hooks
+    if gp, ok := app.Config.Get("app.grace-period").(string); ok {
+        parsed, err := time.ParseDuration(gp) // parse
+        if err != nil { panic("invalid app.grace-period") }
+        app.GracePeriod = parsed // override the default
+    } // grace period is either default or configured
+    app.validateConfig() // report all errors at once
+    app.binding.Store(app.loadBinding(app.Config))
+    app.limits.Store(app.loadLimits(app.Config))
+    if p, ok := app.Config.Get("app.openapi.prefix").(string); ok {
+        app.MountOpenAPI(p) // serve the OpenAPI document
+    } // OpenAPI is served only if it is configured
+    app.mountConfigAssets() // static asset files
+    app.installAccessLog() // log of HTTP requests
+    app.installDatabases() // SQL database providers
+    app.installMigrations() // SQL schema migrations
+    const edep = "provider %v depends on unavailable %v"
+    sorted, err := app.sortProviders() // by deps
+    if err != nil { panic(err) } // cannot order
+    app.Providers = sorted // in dependency order
     app.Booted = time.Now() // mark app as booted
+    invoked := make(map[string] bool) // by name
     for _, p := range app.Providers { // setups
-        if p.Available[env] { // env available?
-            p.Invoked = time.Now(); p.Setup(app)
-        } // setup provider only if availale
+        if !p.Available[app.Env] { continue } // N/A
+        for _, dep := range p.Depends { // check deps
+            if invoked[dep] { continue } // is set up
+            panic(fmt.Errorf(edep, p, dep)) // broken
+        } // all the dependencies have been set up
+        p.Invoked = time.Now(); p.Setup(app) // go
+        invoked[p.Name] = true // mark as invoked
     } // all the providers have been invoked
     for _, s := range app.Services { s.Up(app) }
     log := app.Journal.WithField("env", app.Env)
     log = log.WithField("root", app.RootDirectory)
-    log = log.WithField("level", parsedLevel)
+    log = log.WithField("level", configured)
     log.Info("application has been booted")
     app.CronEngine.Start() // launch CRON
     app.routers = app.assembleRouters()
@@ -110,52 +161,134 @@ func (app *App) Deploy(sv Supervisor) {
     log = log.WithField("ref", app.Reference) // UID
     log.Infof("deploying app with %v services", volume)
     cancelled := make(chan os.Signal, 1) // killed
-    signal.Notify(cancelled, os.Interrupt, os.Kill)
-    app.Supervisor = sv // install app-wide supervisor
+    signal.Notify(cancelled, os.Interrupt, syscall.SIGTERM)
+    if sv != nil { app.Supervisor = sv } // install
+    app.inheritListeners() // handed over by parent?
     app.unfoldHttpsServers() // spawn HTTPS and listen
     app.unfoldHttpServers() // spawn HTTP and listen
+    app.notifyParent() // report that we took over
+    app.monitorLimits() // watch over memory usage
+    app.watchConfig() // reload config on changes
+    app.watchRestart() // restart on the SIGUSR2
     go func() { // this runs in the background
-        _ = <- cancelled // waiting for signal
-        signal.Stop(cancelled) // stop monitoring
+        defer signal.Stop(cancelled) // stop monitoring
+        select { // either signal or manual shutdown
+            case <- app.stopped: return // done already
+            case <- cancelled: // waiting for signal
+        } // signal arrived, initiate the shutdown
         fmt.Fprintln(app.Journal.Out) // write ^C\n
-        moment := time.Now().Format(app.TimeLayout)
-        uptime := time.Now().Sub(app.Booted) // calc
-        for _, s := range app.Services { s.Down(app) }
-        for _, p := range app.Providers { // cleanups
-            if !p.Invoked.IsZero() { // was invoked?
-                p.Cleanup(app); // run the cleanup
-            } // only cleanup the setup-ed ones
-        } // all the provider have been cleaned up
-        log := app.Journal.WithField("time", moment)
-        log = log.WithField("uptime", uptime.String())
-        log.Warn("shutting the application down")
-        app.CronEngine.Stop() // stop CRON engine
-        os.Exit(2) // emulate Ctrl-C exit code
+        var grace time.Duration = app.GracePeriod
+        bg := stdctx.Background() // root context
+        ctx, cancel := stdctx.WithTimeout(bg, grace)
+        defer cancel() // release context resources
+        app.Shutdown(ctx) // drain & tear app down
     }() // run go-routine & wait to finish
     app.finish.Wait()
 }
 
-// Load config file that contains the configuration data for the app
-// instance. Config file should be a valid TOML file that has a bare
-// minimum data to make it a valid config. Method will panic in case if
-// there is an error loading the config or interpreting data inside.
-// Must have the app.name and app.version fields defined correctly.
-// Refer to implementation code for more details on the loading.
-func (app *App) loadConfig(name, base string) *toml.TomlTree {
-    const eload = "failed to load TOML config\n %v"
-    const estat = "could not open config file at %v"
+// Gracefully shut the application down. Stop accepting connections
+// on every app server and wait for the running pipelines to drain,
+// up to the deadline of the supplied context. Then take services
+// down and clean providers up, both in the reverse order. It is safe
+// to call this method multiple times; only the first call has effect.
+func (app *App) Shutdown(ctx stdctx.Context) error {
+    app.halting.Do(func() { app.halt(ctx) })
+    return app.halted // error of the first call
+}
+
+// Implementation of the graceful shutdown sequence; see Shutdown
+// method for the description. Every step is journaled, and the first
+// error that occurs while draining the servers or the pipelines will
+// be stored in the app, while the shutdown itself keeps going. Once
+// completed, application's stop signal will be released for Deploy.
+func (app *App) halt(ctx stdctx.Context) {
+    app.finish.Add(1) // Deploy must wait for us
+    defer app.finish.Done() // release the Deploy
+    defer close(app.stopped) // mark app as stopped
+    defer app.terminate() // cancel all leftovers
+    go func() { // cancel operations when overdue
+        select { // whatever comes first, wins
+            case <- ctx.Done(): app.terminate()
+            case <- app.stopped: return // done
+        } // grace period is over, cancel ops
+    }() // watch over the grace period deadline
+    moment := time.Now().Format(app.TimeLayout)
+    uptime := time.Now().Sub(app.Booted) // calc
+    log := app.Journal.WithField("time", moment)
+    log = log.WithField("uptime", uptime.String())
+    log.Warn("shutting the application down")
+    var group sync.WaitGroup // servers draining
+    var errs = make(chan error, len(app.Servers) + 1)
+    for intent, server := range app.Servers {
+        group.Add(1) // wait for one more server
+        go func(intent string, server *http.Server) {
+            defer group.Done() // server is drained
+            elog := log.WithField("intent", intent)
+            elog.Info("stop accepting new connections")
+            err := server.Shutdown(ctx) // drain
+            if err == nil { return } // drained fine
+            elog.WithError(err).Warn("failed to drain")
+            errs <- err // keep the error for later
+        }(intent, server) // use loop variables
+    } // all servers have been told to stop
+    group.Wait() // wait until servers drained
+    app.CronEngine.Stop() // stop CRON engine
+    drained := make(chan struct {}) // pipelines
+    go func() { app.running.Wait(); close(drained) }()
+    select { // wait for pipelines, up to deadline
+        case <- drained: log.Info("all pipelines drained")
+        case <- ctx.Done(): errs <- ctx.Err() // late
+    } // either drained or grace period is over
+    for i := len(app.Services) - 1; i >= 0; i-- {
+        app.Services[i].Down(app) // reversed
+    } // all the services have been taken down
+    for i := len(app.Providers) - 1; i >= 0; i-- {
+        var p *Provider = app.Providers[i]
+        if p.Invoked.IsZero() { continue } // skip
+        if p.Cleanup != nil { p.Cleanup(app) }
+    } // all the provider have been cleaned up
+    close(errs) // no more errors are expected
+    app.halted = <- errs // first error, if any
+    if app.halted != nil { // was shutdown clean?
+        log.WithError(app.halted).Warn("unclean stop")
+    } // otherwise, everything went smoothly
+    log.Warn("application has been shut down")
+}
+
+// Check whether the application is running in the debugging mode.
+// In this mode, the framework may expose sensitive internal details,
+// such as panic stack traces, to the HTTP clients. It is controlled by
+// the app.debug config key; if it is absent, then the debugging mode
+// is assumed for the environments with a name starting with "dev".
+func (app *App) Debugging() bool {
+    if tree := app.CurrentConfig(); tree != nil {
+        debug, ok := tree.Get("app.debug").(bool)
+        if ok { return debug } // explicitly set
+    } // fallback to guess based on environment
+    return strings.HasPrefix(app.Env, "dev")
+}
+
+// Load the configuration data for the app instance, layer by layer.
+// The layers are: base.toml, <env>.toml and local.toml files from the
+// config directory, then the BOOT_* environment variables, and then
+// the overrides from the command line; see the Override method. Only
+// the env file is mandatory. Method will panic in case if there is an
+// error loading the config or interpreting data inside. Returns tree
+// along with the sources of the keys; see the ConfigSource method to
+// find out which layer has supplied any given key of the config.
+func (app *App) loadConfig(name, base string) (*toml.TomlTree, map[string] string) {
     const ever = "app does not satifsy config version"
     const eforeign = "config is from different app"
-    var root string = app.RootDirectory // root dir
-    var fileName string = fmt.Sprintf("%s.toml", name)
-    resolved := filepath.Join(root, base, fileName)
-    var clean string = filepath.Clean(resolved)
-    log := app.Journal.WithField("file", clean)
-    log.Info("loading application config file")
-    _, err := os.Stat(clean) // check if file exists
-    if err != nil { panic(fmt.Errorf(estat, clean)) }
-    tree, err := toml.LoadFile(clean) // load config up!
-    if err != nil { panic(fmt.Errorf(eload, err.Error())) }
+    tree, _ := toml.Load("") // empty tree to merge to
+    sources := make(map[string] string) // provenance
+    for _, layer := range app.configLayers(name) {
+        app.loadLayer(tree, sources, base, layer, layer == name)
+    } // all the config files have been merged
+    app.environConfig(tree, sources) // BOOT_* vars
+    for _, o := range app.overrides { // the flags
+        path := strings.Split(o[0], ".") // dotted
+        app.overrideConfig(tree, sources, path, "", o[1], "flag")
+    } // command line overrides have been applied
     req, ok := tree.Get("app.require").(*toml.TomlTree)
     if ok && req != nil { // check app requirements
         var avr string = app.Version.String()
@@ -165,14 +298,27 @@ func (app *App) loadConfig(name, base string) *toml.TomlTree {
         if vr == nil || !vr(app.Version) { panic(ever) }
         if name != app.Name { panic(eforeign) }
     } // assume requirements are satisfied
-    return tree // config tree is ready
+    return tree, sources // config tree is ready
+}
+
+// Names of the config files that make up the layers of the config,
+// in the order they are merged; without the extension. The env file
+// goes in between the base and the local files, unless the env itself
+// is named after one of these, in which case the file is used once.
+// See the loadConfig method for the details on the config layers.
+func (app *App) configLayers(name string) []string {
+    layers := []string { "base", name, "local" }
+    if name == "base" { return layers[1:] } // dup
+    if name == "local" { return layers[:2] } // dup
+    return layers // all three of them, in order
 }
 
 // Build an adequate instance of the structured logger for this
 // application instance. The journal builder may draw data from the
 // app instance to configure the journal correctly. This method only
-// instantiates a very basic journal; anything more complicated than
-// that should be implementing using a boot.Provider to do it.
+// instantiates a very basic journal; which is then configured by the
+// app.journal config section, once the config is loaded; see the
+// configureJournal method. Hooks go through the app hook registry.
 func (app *App) makeJournal(level logrus.Level) *logrus.Logger {
     const m = "begin writing application journal"
     var journal *logrus.Logger = &logrus.Logger {}
@@ -180,6 +326,7 @@ func (app *App) makeJournal(level logrus.Level) *logrus.Logger {
     journal.Level = level // use requested level
     journal.Out = os.Stdout // all goes to stdout
     journal.Hooks = make(logrus.LevelHooks) // empty
+    journal.Hooks.Add(app.hooks) // dispatches to all
     journal.Formatter = formatter // set formatter
     formatter.ForceColors = false // act smart
     formatter.DisableColors = false // make pretty
@@ -288,8 +435,33 @@ type App struct {
     // will locate the necessary TOML configuration file, based on the
     // environment configured, load it and make it availale to the app.
     // Please refer to the corresponding method for more details.
+    // The tree is replaced, under the app lock, whenever the config is
+    // reloaded; code that reads it concurrently with the reloads, such
+    // as the endpoints, should use the CurrentConfig method instead.
     Config *toml.TomlTree
 
+    // Snapshot of the config that the application is running with,
+    // that is published atomically by the Boot and Reload, so it could
+    // be read without any locking. Please use the CurrentConfig method
+    // to get the current tree, and the ConfigSource for the sources of
+    // the keys; see the configSnapshot struct for more details on it.
+    current atomic.Pointer[configSnapshot]
+
+    // Command line overrides that are applied as the topmost layer of
+    // the config, as key and value pairs, in the order of recording.
+    // Please use the Override method or the ConfigFlags to record these;
+    // and the ConfigSource method to find out which key is overriden.
+    // See the loadConfig method for how the layers are being merged.
+    overrides [][2]string
+
+    // Journal level that has been requested when the app was booted,
+    // used when the app.journal.level key is absent from the config.
+    // Along with it, the lock that serializes the config reloads and
+    // the initial values of the config structs declared by providers
+    // and services. See the Reload method for the details on these.
+    level logrus.Level; reloading sync.Mutex
+    initial map[interface {}] reflect.Value
+
     // Instant in time when the application was booted. A nil value
     // should indicate that the application instance has not yet been
     // booted up. This value is used internally by the framework in a
@@ -311,6 +483,34 @@ type App struct {
     // or network interfaces at the same time, within one process.
     Servers map[string] *http.Server
 
+    // Listening sockets of the app servers, keyed by the intent of the
+    // server, along with the sockets inherited from the parent process
+    // that have not been taken by any server yet. These are used to do
+    // the zero downtime restarts, handing the sockets over to the child
+    // process; see the Restart method for the details on the handoff.
+    listeners map[string] net.Listener; inherited map[string] *os.File
+
+    // Migrations of the SQL schema that are declared in Go, by means of
+    // the Migration method, along with the config of the migrations that
+    // is decoded from the app.migrations section, once the app is booted.
+    // The config is nil if the migrations are not configured. Please see
+    // the MigrateUp, MigrateDown and MigrationStatus methods for usage.
+    migrations []*Migration; migrator *migrationsConfig
+
+    // Access log of the HTTP requests, if it has been configured by the
+    // app.access-log section of the config; otherwise nil. It is set up
+    // when the app is being booted, replaced on every config reload and
+    // is used by the ServeHTTP method, to record every request once it
+    // is handled. See the recordAccess method for sampling, exclusions.
+    access atomic.Pointer[accessLog]
+
+    // Registry of the hooks of the app journal, that is installed into
+    // the journal as its single hook, dispatching entries to the hooks
+    // registered within it. It is created along with the app, so the
+    // hooks could be registered even before the app is booted. Please
+    // see the Hook and Unhook methods for the details on the registry.
+    hooks *hookRegistry
+
     // Application wide stop signal, implement as a wait group. After
     // the app is being booted the caller should wait on this group to
     // be resumed once the application has been gracefully stopped. Do
@@ -318,6 +518,69 @@ type App struct {
     // using other, likely more destructive, ways of terminating it.
     finish sync.WaitGroup
 
+    // Amount of time that the application is allowed to spend on the
+    // graceful shutdown, when it has been triggered by a signal. Within
+    // this period the servers drain their connections and the running
+    // pipelines are given a chance to complete. Could be configured by
+    // the app.grace-period config key, using Go duration notation.
+    GracePeriod time.Duration
+
+    // Registry of the response body encoders, keyed by the MIME type
+    // that they produce. Encoders are picked by the content negotiation
+    // according to the Accept header of the request; see Context.Render.
+    // By default it has JSON, XML and MessagePack encoders; register any
+    // others before the app is deployed, as the map is not guarded.
+    Encoders map[string] Encoder
+
+    // Wait group that tracks every pipeline that is currently being
+    // run within the application, whether it is an endpoint or an aux
+    // operation. It is used by the graceful shutdown sequence to wait
+    // for the pipelines to drain, before taking the services down. Do
+    // not touch it directly; it is maintained by the Pipeline struct.
+    running sync.WaitGroup
+
+    // Internal bookkeeping of the graceful shutdown sequence. Ensures
+    // that the shutdown happens only once, stores its resulting error
+    // and provides a channel that will be closed once the sequence has
+    // been completed. See the Shutdown method for more details on it.
+    // These fields should never be manipulated directly by anyone.
+    halting sync.Once; halted error; stopped chan struct {}
+
+    // Application wide standard context that will be cancelled once
+    // the graceful shutdown sequence ran out of its grace period. Any
+    // context that is derived within the application is bound to this
+    // one, so that operations still running at that point will have a
+    // chance to stop early. Maintained by the framework exclusively.
+    lifetime stdctx.Context; terminate stdctx.CancelFunc
+
+    // Statistics subsystem of the application instance. It collects
+    // the counts and latencies of operations, the connection counts
+    // of the app servers and the durations of CRON job runs. Do not
+    // access it directly; use the Statistics method to get a snapshot
+    // of the data, or the MountStatistics method to expose it.
+    stats *statistics
+
+    // Current level of the memory pressure, as determined by memory
+    // limits monitor. It is stored as a plain integer, so it could be
+    // accessed atomically, from multiple go-routines. Please use the
+    // MemoryPressure method to read it; see the MemoryLevel type for
+    // more details on the levels of the memory pressure.
+    pressure int32
+
+    // Configuration of the memory limits monitor, as it was decoded
+    // from the app.limits config section during the boot or the last
+    // reload. It is nil if the section is absent; the monitor is off.
+    // The Watchdog consults it for the action to take at every level
+    // of the memory pressure; see the memoryLimits struct for details.
+    limits atomic.Pointer[memoryLimits]
+
+    // Configuration of the input parameters binding, as it has been
+    // loaded from the app.binding config section during the boot or
+    // the last reload. It determines the precedence of sources merged
+    // into Context.Data, as well as the limit on the request body size.
+    // See the loadBinding method for the details on the config.
+    binding atomic.Pointer[binding]
+
     // Slice of providers installed within this application. Provider
     // is an entity, with a piece of code attached, that provides some
     // kind of functionality for the application, such as: a database
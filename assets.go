@@ -0,0 +1,278 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "io"
+import "os"
+import "path"
+import "time"
+import "bytes"
+import "mime"
+import "sync"
+import "strings"
+import "strconv"
+import "io/fs"
+import "net/http"
+import "path/filepath"
+import "crypto/sha256"
+import "fmt"
+
+// Set of static asset files, such as scripts, styles and images, that
+// is served by an endpoint of a service. The files are taken either
+// from the directory relative to the App.RootDirectory, or from the
+// file system, such as the embed.FS that has the assets embedded into
+// the binary. See the Service.Assets and Service.AssetsFS methods.
+type Assets struct {
+
+    // Directory with the asset files, relative to the root directory
+    // of the application. It is resolved when the assets are served,
+    // so it could be declared before the application is booted. This
+    // is only used when Files is nil; otherwise it is merely a label.
+    // Directory listings are never served, only the files themselves.
+    Directory string
+
+    // File system that the asset files are served from, such as the
+    // embed.FS that has the assets embedded into the binary. If it is
+    // nil, then the files are served from the Directory, instead. The
+    // names of the files are the paths relative to the root of the
+    // file system, as requested under the prefix of the endpoint.
+    Files fs.FS
+
+    // Name of the file that is served when a directory is requested,
+    // such as index.html. If it is empty, or there is no such file in
+    // the requested directory, then the request is answered with the
+    // not found status code. Defaults to index.html for the assets
+    // that are declared using the Service.Assets method or config.
+    Index string
+
+    // Rules that determine the Cache-Control header for the assets,
+    // by the path of the requested file. Rules are tried in order, and
+    // the first matching rule wins; if none of them matches, then the
+    // header is not set, leaving it for the clients to decide. Please
+    // see the CacheRule struct for the details on path patterns.
+    Cache []CacheRule
+
+    // Endpoint that serves the asset files, mounted into the service
+    // under the prefix with a wildcard. It accepts the GET and the HEAD
+    // methods only. It is exposed, so it could be further configured,
+    // such as adjusting the timeout, which is five minutes by default,
+    // or adding middleware for access control to the assets.
+    Endpoint *Endpoint
+
+    // Cache of ETags of the files that have no modification time, as
+    // the ones in the embed.FS; such ETags are computed by hashing the
+    // content of a file, which is done only once per every file name.
+    // Files that have the modification time, get the ETag made of it.
+    etags sync.Map
+}
+
+// Rule that sets the Cache-Control header for the asset files, whose
+// path matches the pattern. Patterns use the path.Match syntax; if the
+// pattern contains a slash, then it is matched against the path of the
+// file, relative to the assets root; otherwise against its base name.
+// For example, *.js matches script files in all the directories.
+type CacheRule struct {
+    Pattern string `config:"pattern,required"` // glob
+    Control string `config:"control,required"` // value
+}
+
+// Create and mount a new endpoint into the current service, that will
+// serve the static asset files from the directory, relative to the root
+// directory of the application, under the specified prefix. Supports
+// conditional requests with ETag and Last-Modified, range requests
+// and pre-compressed .br and .gz sidecar files. See the Assets struct.
+func (srv *Service) Assets(prefix, directory string) *Assets {
+    return srv.mountAssets(prefix, &Assets { Directory: directory })
+}
+
+// Create and mount a new endpoint into the current service, that will
+// serve the static asset files from the file system, under the specified
+// prefix. Typically, the file system is the embed.FS, that has assets
+// embedded into the binary; use fs.Sub to strip the leading directory.
+// Otherwise, it behaves exactly the same as the Service.Assets method.
+func (srv *Service) AssetsFS(prefix string, files fs.FS) *Assets {
+    const efiles = "missing the assets file system"
+    if files == nil { panic(efiles) } // no files?
+    return srv.mountAssets(prefix, &Assets { Files: files })
+}
+
+// Mount the endpoint that serves the assets into the current service,
+// under the specified prefix, followed by the wildcard that captures
+// the path of the requested file. The endpoint is stored within the
+// assets, so it could be configured further. This is implementation
+// of the Service.Assets and Service.AssetsFS methods; use them.
+func (srv *Service) mountAssets(prefix string, assets *Assets) *Assets {
+    var pattern string = strings.Trim(prefix, "/")
+    if len(pattern) > 0 { pattern = pattern + "/" }
+    assets.Index = "index.html" // default index file
+    assets.Endpoint = srv.Endpoint(func(ep *Endpoint) {
+        ep.Pattern = pattern + "*path" // rest of path
+        ep.Methods["GET"], ep.Methods["HEAD"] = true, true
+        ep.Timeout = time.Minute * 5 // large files
+        ep.Description = "static assets"
+        ep.Business = assets.serve // serve files
+    }) // assets endpoint has been mounted
+    return assets // could be further configured
+}
+
+// Serve the asset file that has been requested, by the path captured
+// by the wildcard of the endpoint. The path is cleaned, so it could
+// never escape the root of the assets; dot files are never served. If
+// the client accepts it, the pre-compressed sidecar file is served. If
+// there is no such file, then the supervisor handles it as not found.
+func (a *Assets) serve(c *Context) {
+    files := a.Files // file system, or directory
+    if files == nil { files = os.DirFS(filepath.Join(
+        c.App.RootDirectory, a.Directory)) }
+    name := strings.TrimPrefix(path.Clean("/" + c.Data["path"]), "/")
+    if name == "" { name = "." } // root of the assets
+    for _, segment := range strings.Split(name, "/") {
+        if strings.HasPrefix(segment, ".") && segment != "." {
+            c.App.Supervisor.EndpointNotFound(c); return
+        } // hidden files are never to be served
+    } // the requested path looks legitimate
+    info, err := fs.Stat(files, name) // look it up
+    if err == nil && info.IsDir() && a.Index != "" {
+        name = path.Join(name, a.Index) // index file
+        info, err = fs.Stat(files, name) // look again
+    } // directory is substituted by its index file
+    if err != nil || info.IsDir() { // nothing to serve
+        c.App.Supervisor.EndpointNotFound(c); return
+    } // got the file, find the best representation
+    header := c.Header() // response headers
+    kind := mime.TypeByExtension(path.Ext(name))
+    if kind != "" { header.Set("Content-Type", kind) }
+    if control := a.cacheControl(name); control != "" {
+        header.Set("Cache-Control", control)
+    } // cache control is set, if any rule matches
+    header.Add("Vary", "Accept-Encoding") // sidecars
+    served := name // representation that is served
+    accept := c.Request.Header.Get("Accept-Encoding")
+    for _, e := range [][2]string { {"br", ".br"}, {"gzip", ".gz"} } {
+        if !acceptsEncoding(accept, e[0]) { continue }
+        sidecar, err := fs.Stat(files, name + e[1])
+        if err != nil || sidecar.IsDir() { continue }
+        header.Set("Content-Encoding", e[0]) // coded
+        if kind == "" { header.Set("Content-Type", // no sniff
+            "application/octet-stream") } // of coded data
+        served, info = name + e[1], sidecar; break
+    } // served the best pre-compressed sidecar file
+    file, err := files.Open(served) // open for reading
+    if err != nil { c.App.Supervisor.EndpointNotFound(c); return }
+    defer file.Close() // release the file handle
+    content, ok := file.(io.ReadSeeker) // seekable?
+    if !ok { // have to read the whole file in memory
+        data, err := io.ReadAll(file) // read it up
+        if err != nil { panic(err) } // broken file
+        content = bytes.NewReader(data) // seekable
+    } // content is seekable, as needed for ranges
+    header.Set("ETag", a.etag(served, info, content))
+    http.ServeContent(c, c.Request, name, info.ModTime(), content)
+}
+
+// Determine the Cache-Control header value for the asset file, by the
+// path of the file, relative to the assets root. Rules are tried in
+// order, and the first one that matches the path wins. Returns empty
+// string if no rule matches. See the CacheRule for the details on how
+// the patterns are matched against the path of the asset file.
+func (a *Assets) cacheControl(name string) string {
+    for _, rule := range a.Cache { // first wins
+        var subject string = path.Base(name) // name
+        if strings.Contains(rule.Pattern, "/") { subject = name }
+        ok, _ := path.Match(rule.Pattern, subject)
+        if ok { return rule.Control } // matched
+    } // none of the rules has matched the path
+    return "" // leave it for the clients
+}
+
+// Compute the strong ETag of the asset file. It is derived from the
+// size and the modification time of the file, if it has one; otherwise
+// the content of the file is hashed, which is done only once per file,
+// since such files are embedded and could not change. The content is
+// rewound back to the start, after it has been hashed for the ETag.
+func (a *Assets) etag(name string, info fs.FileInfo, content io.ReadSeeker) string {
+    if !info.ModTime().IsZero() { // got the time?
+        stamp := info.ModTime().UnixNano() // in ns
+        return fmt.Sprintf("\"%x-%x\"", info.Size(), stamp)
+    } // no modification time, hash the content
+    if etag, ok := a.etags.Load(name); ok { return etag.(string) }
+    hash := sha256.New() // hash the whole content
+    io.Copy(hash, content) // read all of it up
+    content.Seek(0, io.SeekStart) // rewind it
+    etag := fmt.Sprintf("\"%x\"", hash.Sum(nil)[:16])
+    a.etags.Store(name, etag); return etag // cached
+}
+
+// Check whether the client accepts the specified content encoding, as
+// it is stated in the Accept-Encoding header of the request. Encodings
+// that are listed with the zero quality are not accepted; neither are
+// the ones that are not listed, unless there is a wildcard. Please see
+// the Assets.serve method for the usage with the sidecar files.
+func acceptsEncoding(accept, encoding string) bool {
+    var wildcard bool = false // accepts any?
+    for _, part := range strings.Split(accept, ",") {
+        name, params, _ := strings.Cut(part, ";")
+        name = strings.TrimSpace(strings.ToLower(name))
+        var q float64 = 1.0 // default quality
+        if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+            parsed, err := strconv.ParseFloat(v, 64)
+            if err == nil { q = parsed } // got it
+        } // quality of the encoding has been parsed
+        if name == encoding { return q > 0 } // listed
+        if name == "*" { wildcard = q > 0 } // any
+    } // the encoding has not been listed explicitly
+    return wildcard // accepted only by the wildcard
+}
+
+// Declaration of the assets, as it is decoded from the app.assets
+// array of tables within the config. Every declaration is mounted as
+// a separate service under the prefix, serving the files from the
+// directory, relative to the app root. See the mountConfigAssets method
+// and the Assets struct for the details on how assets are served.
+type assetsConfig struct {
+    Prefix string `config:"prefix,required"` // mount
+    Directory string `config:"dir,required"` // files
+    Index string `config:"index" default:"index.html"`
+    Cache []CacheRule `config:"cache"` // headers
+}
+
+// Mount the assets that are declared in the app.assets array of tables
+// within the config, each one as a separate service under the declared
+// prefix. This is invoked by the Boot, before the services are brought
+// up. Panics if the declarations are malformed, the same way as other
+// config errors are reported. See the assetsConfig for the format.
+func (app *App) mountConfigAssets() {
+    var declared struct { // app.assets section
+        Assets []assetsConfig `config:"assets"`
+    } // declarations of the assets, in order
+    err := app.DecodeConfig("app", &declared)
+    if err != nil { panic(err) } // malformed
+    for _, d := range declared.Assets { // mount
+        app.Service(func(srv *Service) {
+            srv.Prefix = d.Prefix // mount point
+            assets := srv.Assets("", d.Directory)
+            assets.Index, assets.Cache = d.Index, d.Cache
+        }) // assets service has been mounted
+    } // all the declared assets are mounted
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "testing"
+
+func TestAcceptsEncoding(t *testing.T) {
+    var cases = []struct {
+        accept string // the Accept-Encoding header
+        encoding string // sidecar encoding to check
+        want bool // whether it is accepted
+    } {
+        { "", "gzip", false }, { "gzip", "gzip", true },
+        { "gzip, br", "br", true }, { "GZIP", "gzip", true },
+        { "br;q=1.0, gzip;q=0.8", "gzip", true },
+        { "gzip;q=0", "gzip", false }, { "deflate", "gzip", false },
+        { "*", "br", true }, { "*;q=0", "br", false },
+        { "gzip;q=0, *", "gzip", false }, { "br;q=0, *", "gzip", true },
+        { "gzip;q=oops", "gzip", true },
+    } // headers and the encodings to check
+    for _, c := range cases { // check every one
+        if got := acceptsEncoding(c.accept, c.encoding); got != c.want {
+            t.Errorf("acceptsEncoding(%q, %q) is %v, want %v", c.accept, c.encoding, got, c.want)
+        } // the outcome is as expected
+    }
+}
@@ -24,7 +24,6 @@
 package boot
 
 import "time"
-import "fmt"
 
 // Implementation of the Operation interface; execute business logic
 // that is stored within an aux op, in regards to supplied context
@@ -33,28 +32,14 @@ import "fmt"
 // asynchronous behavior intended - the caller must ensure that this
 // method syncrhonizes on the asynchronous code to return onces done.
 func (aux *Aux) Apply(context *Context) error {
-    timer := time.After(aux.Timeout) // ticker
-    value := make(chan interface {}, 1) // panic
-    const einv = "undetermined endpoint panic %v"
     if e := aux.Satisfied(context); e != nil {
         elog := context.Journal.WithError(e)
         elog = elog.WithField("operation", aux)
+        elog = elog.WithField("source", aux.Definition())
         elog.Warn("auxiliary is not available")
         return OperationUnavailable // is N/A
     } // operation assured to be available
-    go func() { // wrap as asynchronous code
-        defer func() { value <- recover() }()
-        aux.Business(context) // run the BL!
-    }() // spin off go-routine to execute it
-    select { // wait for either of 2 channels
-        case <- timer: return OperationTimeout
-        case x := <- value: switch e := x.(type) {
-            case error: return e // regular panic
-            case nil: return nil // executed OK
-            // operation paniced with non-error
-            default: return fmt.Errorf(einv, e)
-        }
-    }
+    return context.execute(aux, aux.Timeout, aux.Business)
 }
 
 // Check whether the operation is satisfied with supplied context.
@@ -138,7 +123,7 @@ type Aux struct {
     // considered timed out. If the operation application times out, a
     // caller will be notified of this by returning the special value to
     // it and of course unblocking the call stack. The go-routine that
-    // was used to invoke the operation will continue to spin though.
+    // runs the operation will have its standard context cancelled.
     Timeout time.Duration
 
     // Embedded pipeline instance for this auxiliary operation. By the
@@ -155,6 +140,20 @@ type Aux struct {
     // largely by the caller, so do not make any assumptions on it.
     Business BiasedLogic
 
+    // Human readable description of what this aux op does. It is not
+    // used by the framework to do anything, except exposing it in the
+    // inventory and documentation of the application. It is advised
+    // to keep it short, yet descriptive enough for an API consumer to
+    // understand the purpose of it without looking into the code.
+    Description string
+
+    // Optional description of the input that this aux op expects. It
+    // could be anything that is serializable as JSON, such as the JSON
+    // Schema document. It is not interpreted by the framework in any
+    // way, and merely exposed in the inventory & docs of the app. See
+    // the App.Inventory method for more details on the inventory.
+    Schema interface {}
+
     // Store source location of where the definition of this auxiliary
     // is implemented. This information may not always be available. It
     // will be accordingly reflected in the return struct in this case.
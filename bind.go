@@ -0,0 +1,344 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "io"
+import "mime"
+import "time"
+import "bytes"
+import "reflect"
+import "strings"
+import "strconv"
+import "net/http"
+import "encoding/json"
+import "errors"
+import "fmt"
+
+import "github.com/pelletier/go-toml"
+import "github.com/naoina/denco"
+
+// Sources of the input parameters that get merged into Context.Data
+// when the context represents an HTTP request. Route is the set of the
+// placeholders matched by the router; query is the URL query string;
+// form is the urlencoded or multipart form body; json is the top-level
+// fields of a JSON object body. See the BindingPrecedence variable.
+const (
+    SourceRoute = "route" // URL placeholders
+    SourceQuery = "query" // URL query string
+    SourceForm = "form" // urlencoded or multipart
+    SourceJSON = "json" // top-level JSON fields
+)
+
+// Default precedence of the input parameter sources, from the highest
+// to the lowest. When the same parameter is supplied by many sources,
+// the value from the source with the highest precedence wins. This can
+// be overriden by the app.binding.precedence config key, that should
+// be an array with some or all of the source names, in the same order.
+var BindingPrecedence = []string {
+    SourceRoute, SourceJSON, SourceForm, SourceQuery,
+}
+
+// Configuration of the input parameters binding, as loaded from the
+// app.binding config section. Precedence is the ordered list of the
+// sources, from the highest to the lowest; sources not listed are not
+// merged into the Context.Data at all. Body limit is the max number
+// of bytes of the request body that will be read for the binding.
+type binding struct {
+    precedence []string // highest first
+    limit int64 // maximum body size, bytes
+}
+
+// Load the configuration of input parameters binding from the config
+// section app.binding of the tree, if any. Falls back to the defaults:
+// BindingPrecedence and a body limit of 10MB, if the section or some of
+// the keys are absent. Panics if the section is malformed, in line with
+// the other config loading routines. See the binding struct for more.
+func (app *App) loadBinding(tree *toml.TomlTree) *binding {
+    const esource = "invalid app.binding source %v"
+    const elimit = "invalid app.binding.body-limit"
+    config := &binding { limit: 10 << 20 } // 10MB
+    config.precedence = BindingPrecedence // default
+    section, _ := tree.Get("app.binding").(*toml.TomlTree)
+    if section == nil { return config } // default
+    if v, ok := section.Get("precedence").([]interface {}); ok {
+        config.precedence = make([]string, 0, len(v))
+        for _, source := range v { switch source {
+            case SourceRoute, SourceQuery, SourceForm, SourceJSON:
+                s := source.(string) // known source
+                config.precedence = append(config.precedence, s)
+            default: panic(fmt.Errorf(esource, source))
+        }} // the precedence has been overriden
+    } // precedence is either default or configured
+    switch v := section.Get("body-limit").(type) {
+        case nil: break // keep default limit
+        case int64: config.limit = v // bytes
+        case string: // size with an optional suffix
+            size, err := parseSize(v) // parse it
+            if err != nil { panic(elimit) } // bad
+            config.limit = int64(size) // override
+        default: panic(elimit) // malformed value
+    } // body limit is either default or configured
+    if config.limit <= 0 { panic(elimit) }
+    return config // binding configuration
+}
+
+// Merge the input parameters of the HTTP request into Context.Data,
+// according to the configured precedence of the sources. Route params
+// are the ones matched by the router. Request body is only consumed if
+// a form or JSON source is enabled; the JSON body is restored, so the
+// application could read it once again. Returns an error if the body
+// is over the limit (see http.MaxBytesError) or is malformed; then the
+// request must be rejected, without invoking the matched endpoint.
+func (app *App) collectData(context *Context, ps denco.Params) error {
+    config := app.binding.Load() // as of now
+    r := context.Request // the HTTP request
+    sources := make(map[string] map[string] string)
+    sources[SourceRoute] = make(map[string] string)
+    for _, p := range ps { sources[SourceRoute][p.Name] = p.Value }
+    sources[SourceQuery] = flatten(r.URL.Query())
+    enabled := make(map[string] bool) // by name
+    for _, s := range config.precedence { enabled[s] = true }
+    kind, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    body := http.MaxBytesReader(context, r.Body, config.limit)
+    if r.Body == nil || r.Body == http.NoBody { kind = "" }
+    switch { // only consume body if enabled for it
+        case kind == "application/json" && enabled[SourceJSON]:
+            fields, err := context.readJSON(body) // parse
+            if err != nil { return err } // bad JSON body
+            sources[SourceJSON] = fields // may be empty
+        case strings.HasPrefix(kind, "multipart/") && enabled[SourceForm]:
+            r.Body = body // parse within the limit
+            err := r.ParseMultipartForm(config.limit)
+            if err != nil { return err } // broken form
+            sources[SourceForm] = flatten(r.PostForm)
+        case kind == "application/x-www-form-urlencoded" && enabled[SourceForm]:
+            r.Body = body // parse within the limit
+            err := r.ParseForm() // urlencoded body
+            if err != nil { return err } // broken form
+            sources[SourceForm] = flatten(r.PostForm)
+    } // the body has been consumed, if necessary
+    for i := len(config.precedence) - 1; i >= 0; i-- {
+        source := sources[config.precedence[i]] // map
+        for k, v := range source { context.Data[k] = v }
+    } // higher precedence sources overwrite lower
+    return nil // all the parameters are merged
+}
+
+// Read the JSON body of the request and decode its top-level fields
+// into a flat map of strings. Strings are stored verbatim, whereas all
+// other values are stored as their JSON text; so numbers and booleans
+// are stored as is and the nested objects could be decoded later on.
+// The raw body is put back into the request, so it could be re-read.
+// Valid JSON that is not an object, such as an array, has no fields.
+func (c *Context) readJSON(body io.Reader) (map[string] string, error) {
+    fields := make(map[string] string) // flat
+    data, err := io.ReadAll(body) // within limit
+    if err != nil { return fields, err } // failed
+    c.Request.Body = io.NopCloser(bytes.NewReader(data))
+    var object map[string] json.RawMessage // top
+    var mismatch *json.UnmarshalTypeError // not object
+    err = json.Unmarshal(data, &object) // decode it
+    if errors.As(err, &mismatch) { return fields, nil }
+    if err != nil { return fields, err } // not JSON
+    for key, raw := range object { // walk fields
+        if string(raw) == "null" { continue } // none
+        var text string // is the value a string?
+        if json.Unmarshal(raw, &text) == nil {
+            fields[key] = text; continue
+        } // not a string, keep the JSON text
+        fields[key] = string(raw) // as is
+    } // all top-level fields are flattened
+    return fields, nil // fields are ready
+}
+
+// Flatten the multi-valued parameters, such as the query string or the
+// form values, into a map with a single value per key. The first value
+// is taken, as it is customary with the standard library Get methods.
+// The Context.Request could be used to access all the values, if the
+// application expects some parameters to have multiple values.
+func flatten(values map[string] []string) map[string] string {
+    flat := make(map[string] string, len(values))
+    for k, vs := range values { // walk all keys
+        if len(vs) > 0 { flat[k] = vs[0] } // first
+    } // all the parameters have been flattened
+    return flat // single value per key
+}
+
+// Error that is returned by the Context.Bind method when some of the
+// parameters could not be bound into the destination struct, either
+// because they are malformed or because they are required but absent.
+// It carries the errors for all the offending fields, not just first,
+// so they all could be reported back to the client at once.
+type BindingError struct {
+    Fields []FieldError `json:"fields"` // all of them
+}
+
+// Error that relates to one specific parameter (field) of the input.
+// Field is the name of the parameter, as it is supplied by the client,
+// not the name of the Go struct field. Message is a human readable
+// explanation of what is wrong with the parameter. These are returned
+// as a part of BindingError; serializable into JSON as they are.
+type FieldError struct {
+    Field string `json:"field"` // parameter name
+    Message string `json:"message"` // what is wrong
+}
+
+// Error message of the binding error; contains the messages of all
+// the offending fields, joined together. This makes binding error the
+// implementation of the standard error interface. Please use Fields to
+// access the errors of the individual fields in a structured way,
+// for example to report them back to the client as a JSON response.
+func (be *BindingError) Error() string {
+    messages := make([]string, 0, len(be.Fields))
+    for _, f := range be.Fields { // every field
+        m := fmt.Sprintf("%v: %v", f.Field, f.Message)
+        messages = append(messages, m) // collect
+    } // all the messages have been collected
+    return "invalid input: " + strings.Join(messages, "; ")
+}
+
+// Bind the input parameters that have been collected in the Data of
+// this context into the destination, which must be a pointer to a Go
+// struct. Fields are matched by the bind tag, such as `bind:"name"`,
+// or the name in the json tag, if there is no bind tag. Fields tagged
+// `bind:"name,required"` must be present, or else it is an error.
+// Strings, numbers, booleans and durations are parsed from the text,
+// while all other types are decoded as JSON from the parameter value.
+// Values are then checked against the rules from the validate tag.
+// Returns a BindingError listing all the offending fields, if any.
+func (c *Context) Bind(destination interface {}) error {
+    const enotptr = "bind destination must be a pointer to struct"
+    value := reflect.ValueOf(destination) // reflect
+    if value.Kind() != reflect.Ptr || value.IsNil() ||
+        value.Elem().Kind() != reflect.Struct {
+        panic(enotptr) // programming error
+    } // destination is a pointer to a struct
+    failure := &BindingError { Fields: make([]FieldError, 0) }
+    c.bindStruct(value.Elem(), failure) // walk fields
+    if len(failure.Fields) > 0 { return failure }
+    return nil // all fields have been bound
+}
+
+// Walk the fields of the struct value and bind the input parameters
+// into them, recursing into the embedded structs. Errors are collected
+// into the supplied binding error, rather than returned, so that all
+// the offending fields are reported at once. Unexported fields and the
+// fields without bind or json tags are skipped. See the Bind method.
+func (c *Context) bindStruct(value reflect.Value, failure *BindingError) {
+    kind := value.Type() // type of the struct
+    for i := 0; i < kind.NumField(); i++ {
+        field := kind.Field(i) // definition
+        if field.PkgPath != "" && !field.Anonymous { continue }
+        if field.Anonymous && field.Type.Kind() == reflect.Struct {
+            c.bindStruct(value.Field(i), failure)
+            continue // embedded struct walked
+        } // it is a regular, exported struct field
+        name, required := bindTag(field) // param
+        if name == "" { continue } // not bindable
+        text, present := c.Data[name] // the param
+        if !present && required { // it is mandatory
+            failure.Fields = append(failure.Fields,
+                FieldError { name, "is required" })
+        } // required parameter is missing
+        if !present { continue } // leave as is
+        err := assign(value.Field(i), text) // convert
+        if err == nil { err = checkRules(field, value.Field(i)) }
+        if err != nil { // malformed or violates rules
+            failure.Fields = append(failure.Fields,
+                FieldError { name, err.Error() })
+        } // parameter is malformed for the field
+    } // all the fields have been walked through
+}
+
+// Obtain the name of the parameter that the struct field should be
+// bound to, and whether that parameter is required. The name is taken
+// from the bind tag, or from the json tag if there is no bind tag. An
+// empty name is returned for the fields that should not be bound; so
+// either having no tags at all, or being explicitly skipped with "-".
+func bindTag(field reflect.StructField) (string, bool) {
+    tag, ok := field.Tag.Lookup("bind") // own tag
+    if !ok { tag = field.Tag.Get("json") } // alt
+    parts := strings.Split(tag, ",") // name & opts
+    name := strings.TrimSpace(parts[0]) // param
+    if name == "-" { return "", false } // skip
+    required := false // is the field mandatory?
+    for _, o := range parts[1:] { required = required ||
+        strings.TrimSpace(o) == "required" }
+    return name, required // as declared
+}
+
+// Assign the textual value of the parameter to the field, converting
+// it to the type of the field. Strings are assigned as is; numbers and
+// booleans are parsed; durations are parsed with time.ParseDuration;
+// pointers are allocated; anything else is decoded as JSON text. An
+// error explains why the text could not be converted, if it fails.
+func assign(field reflect.Value, text string) error {
+    const eint = "must be an integer number"
+    const euint = "must be a non-negative integer"
+    const efloat = "must be a number"
+    const ebool = "must be a boolean"
+    const eduration = "must be a duration"
+    if field.Kind() == reflect.Ptr { // allocate it
+        target := reflect.New(field.Type().Elem())
+        if err := assign(target.Elem(), text); err != nil {
+            return err // do not touch the field
+        } // pointee has been assigned, set it
+        field.Set(target); return nil // done
+    } // the field is not a pointer, assign it
+    if field.Type() == reflect.TypeOf(time.Duration(0)) {
+        d, err := time.ParseDuration(text) // parse
+        if err != nil { return errors.New(eduration) }
+        field.SetInt(int64(d)); return nil // done
+    } // the field is not a duration, go by kind
+    switch field.Kind() { // convert to the kind
+        case reflect.String: field.SetString(text)
+        case reflect.Bool: // true, false, 1, 0, etc
+            b, err := strconv.ParseBool(text)
+            if err != nil { return errors.New(ebool) }
+            field.SetBool(b) // assign the boolean
+        case reflect.Int, reflect.Int8, reflect.Int16,
+            reflect.Int32, reflect.Int64: // signed
+            bits := field.Type().Bits() // of the int
+            n, err := strconv.ParseInt(text, 10, bits)
+            if err != nil { return errors.New(eint) }
+            field.SetInt(n) // assign the integer
+        case reflect.Uint, reflect.Uint8, reflect.Uint16,
+            reflect.Uint32, reflect.Uint64: // unsigned
+            bits := field.Type().Bits() // of the uint
+            n, err := strconv.ParseUint(text, 10, bits)
+            if err != nil { return errors.New(euint) }
+            field.SetUint(n) // assign the integer
+        case reflect.Float32, reflect.Float64: // real
+            bits := field.Type().Bits() // of the float
+            f, err := strconv.ParseFloat(text, bits)
+            if err != nil { return errors.New(efloat) }
+            field.SetFloat(f) // assign the number
+        default: // slices, maps, structs and the like
+            target := reflect.New(field.Type()) // fresh
+            err := json.Unmarshal([]byte(text), target.Interface())
+            if err != nil { return fmt.Errorf("malformed JSON: %v", err) }
+            field.Set(target.Elem()) // assign decoded
+    } // value has been converted and assigned
+    return nil // field has been assigned OK
+}
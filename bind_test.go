@@ -0,0 +1,53 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "strings"
+import "testing"
+import "reflect"
+import "net/http/httptest"
+
+func TestReadJSON(t *testing.T) {
+    var cases = []struct {
+        body string // the JSON body of the request
+        want map[string] string // nil if it must fail
+    } {
+        { `{"a": 1, "b": "x", "c": {"d": true}, "e": null}`,
+            map[string] string { "a": "1", "b": "x", "c": `{"d": true}` } },
+        { `{}`, map[string] string {} }, { `null`, map[string] string {} },
+        { `[1, 2]`, map[string] string {} }, { `"x"`, map[string] string {} },
+        { `42`, map[string] string {} }, { `true`, map[string] string {} },
+        { `{"a": 1`, nil }, { `[1, 2`, nil }, { `nope`, nil }, { ``, nil },
+    } // bodies and the fields they are flattened into
+    for _, c := range cases { // read every body
+        r := httptest.NewRequest("POST", "/", strings.NewReader(c.body))
+        context := &Context { Request: r } // bare context
+        fields, err := context.readJSON(r.Body) // flatten
+        if c.want == nil && err == nil { t.Errorf("body %q did not fail", c.body) }
+        if c.want != nil && (err != nil || !reflect.DeepEqual(fields, c.want)) {
+            t.Errorf("body %q is %v, %v; want %v", c.body, fields, err, c.want)
+        } // the outcome is as expected
+    }
+}
@@ -24,6 +24,11 @@
 package boot
 
 import "errors"
+import "reflect"
+import "runtime"
+import "fmt"
+
+import "github.com/pelletier/go-toml"
 
 // Function that encapsulates a unit of application's business logic.
 // It is a function of a context struct instance; function is used for
@@ -39,6 +44,13 @@ type BiasedLogic func (*Context)
 // common usage is a setup function that only needs an app to work.
 type UnbiasedLogic func (*App)
 
+// Function that reacts to the configuration of the application being
+// reloaded. It is a function of an application instance, the previous
+// config tree and the current one, that the app has just switched to.
+// Providers and services use it to apply the changes that they are
+// interested in, such as adjusting the limits, without a restart.
+type ReloadLogic func (*App, *toml.TomlTree, *toml.TomlTree)
+
 // A middleware is a function that takes in a context and the next
 // function to call. Middleware is a simple concept that allows for an
 // elegent pre and post processing during invoking an operation. Every
@@ -60,12 +72,59 @@ var OperationTimeout = errors.New("operation timed out")
 // this value by the framework or app code for more information.
 var OperationUnavailable = errors.New("operation is not available")
 
+// Error value to represent a situation when operation application
+// has been cancelled before it could complete. This happens when the
+// HTTP client disconnects or when the application is shutting down;
+// it is distinct from the timeout. Please see the usage of this value
+// by the framework or app code for more information on the matter.
+var OperationCancelled = errors.New("operation has been cancelled")
+
 // Structure that points to where the definition of some application
 // code or entity was made, in terms of source code file and line number.
 // This info may not always be available; see the struct for details on
 // the available data. The structure can also be used to point to some
 // places within the application source code, such as panics, etc.
-type SourceLocation struct { File string; Line int; Ok bool }
+type SourceLocation struct {
+    File string `json:"file"` // path to the source file
+    Line int `json:"line"` // line number within the file
+    Function string `json:"function,omitempty"` // logic
+    Ok bool `json:"ok"` // whether location is available
+}
+
+// Capture the source location of the caller of the function that
+// invokes this one; that is, skipping the specified number of extra
+// stack frames. If the location cannot be determined, the Ok field of
+// the resulting structure will be false. Typically, it is used by the
+// framework builders to record where an entity has been defined.
+func locate(skip int) SourceLocation {
+    _, file, line, ok := runtime.Caller(skip + 2)
+    return SourceLocation { File: file, Line: line, Ok: ok }
+}
+
+// Resolve the fully qualified name of the function that implements
+// the specified business logic and record it within this location. If
+// the logic is nil or its name could not be resolved, the function
+// name will remain empty. This is used by the framework builders, to
+// point exactly to the business logic of the endpoints and aux ops.
+func (sl *SourceLocation) implement(logic BiasedLogic) {
+    if logic == nil { return } // nothing to resolve
+    pc := reflect.ValueOf(logic).Pointer() // entry
+    if fn := runtime.FuncForPC(pc); fn != nil {
+        sl.Function = fn.Name() // qualified name
+    } // name of the function has been resolved
+}
+
+// String represenation of the source location, which is used mainly
+// for pointing a developer right to the place of a definition within
+// the source code, in a form of file:line, followed by function name,
+// when it is available. If location is not available, then a question
+// mark will be returned instead, to indicate unknown location.
+func (sl SourceLocation) String() string {
+    if !sl.Ok { return "?" } // location unknown
+    var s string = fmt.Sprintf("%s:%d", sl.File, sl.Line)
+    if len(sl.Function) > 0 { s += " " + sl.Function }
+    return s // formatted source location
+}
 
 // Something that contains a piece of application's business logic and
 // knows how to invoke it. Any operation within the framework can only
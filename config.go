@@ -0,0 +1,271 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "os"
+import "sort"
+import "flag"
+import "reflect"
+import "strings"
+import "strconv"
+import "path/filepath"
+import "fmt"
+
+import "github.com/pelletier/go-toml"
+
+// Prefix of the environment variables that override the config keys.
+// The rest of the variable name is the path to the key, in upper case,
+// with the underscores separating the path segments; and the numbers
+// indexing the arrays of tables. For example, the variable named as
+// BOOT_APP_SERVERS_HTTP_0_PORT_NUMBER overrides the port-number key.
+const EnvironPrefix = "BOOT_"
+
+// Override the config key with the specified value, on top of all the
+// other config layers, including the environment variables. The key is
+// a dotted path, where the numbers index the arrays of tables, such as
+// app.servers.http.0.port-number. Value is parsed as a TOML value, or
+// taken as a string, if it does not parse. Must be called before Boot.
+func (app *App) Override(key, value string) {
+    if !app.Booted.IsZero() { // app is booted?
+        panic("refusing to modify the booted app")
+    } // app is not booted yet, we are good to go
+    app.Lock(); defer app.Unlock() // guard it
+    app.overrides = append(app.overrides, [2]string { key, value })
+}
+
+// Register the -set flag within the supplied flag set, that overrides
+// the config keys from the command line. The flag is repeatable, and
+// takes the key=value pairs; see the Override method for the format.
+// The flags must be parsed before the application is booted, since it
+// is the Boot method that does load the configuration of the app.
+func (app *App) ConfigFlags(fs *flag.FlagSet) {
+    const usage = "override config key, as key=value"
+    fs.Func("set", usage, func(pair string) error {
+        key, value, ok := strings.Cut(pair, "=")
+        if !ok || len(strings.TrimSpace(key)) == 0 {
+            return fmt.Errorf("expected key=value, got %q", pair)
+        } // pair is good, record it as the override
+        app.Override(strings.TrimSpace(key), value)
+        return nil // override has been recorded
+    }) // flag is registered within the flag set
+}
+
+// Find out which layer of the configuration has supplied the key with
+// the specified dotted path, such as app.servers.http.0.port-number. A
+// layer is either a config file path, relative to the app root, or the
+// name of the environment variable, or the "flag" for the overrides.
+// Returns an empty string, if the key is not set by any layer.
+func (app *App) ConfigSource(key string) string {
+    snapshot := app.current.Load() // as published
+    if snapshot == nil { return "" } // not booted
+    return snapshot.sources[key] // empty if none
+}
+
+// Get the copy of the map of all the config keys, as dotted paths, to
+// the layers that have supplied them. Only the leaf keys are included;
+// the tables and arrays of tables are represented by the keys of their
+// contents. See the ConfigSource method for the format of the layers.
+// This is useful for dumping the effective config for troubleshooting.
+func (app *App) ConfigSources() map[string] string {
+    sources := make(map[string] string) // copy
+    snapshot := app.current.Load() // as published
+    if snapshot == nil { return sources } // empty
+    for k, v := range snapshot.sources { sources[k] = v }
+    return sources // a copy, safe to modify
+}
+
+// Get the config tree that the application is currently running with.
+// It is the same tree as App.Config; but it is loaded atomically, so it
+// is safe to call concurrently with the Reload, that replaces both. The
+// tree that is returned is never modified, so keep the pointer to read
+// the config consistently, rather than calling this method repeatedly.
+func (app *App) CurrentConfig() *toml.TomlTree {
+    snapshot := app.current.Load() // as published
+    if snapshot == nil { return app.Config } // boot
+    return snapshot.tree // the most recent one
+}
+
+// Snapshot of the config that the application is currently running
+// with: the config tree along with the sources of all its leaf keys.
+// It is published atomically, as a whole, by the Boot and the Reload
+// methods; so that the readers never observe a tree that mismatches
+// its sources, nor do they have to take any locks to read the config.
+type configSnapshot struct {
+    tree *toml.TomlTree // the config tree itself
+    sources map[string] string // layers, by key
+}
+
+// Load one config file, named after the layer, from the config base
+// directory, and merge it into the tree. The file must be a valid TOML
+// file. If the file is not required and it does not exist, the layer
+// is skipped. Method will panic in case if there is an error loading
+// the file, or if the required file does not exist at all. Sources of
+// the merged keys are recorded into the supplied map of sources.
+func (app *App) loadLayer(tree *toml.TomlTree, sources map[string] string, base, layer string, required bool) {
+    const eload = "failed to load TOML config\n %v"
+    const estat = "could not open config file at %v"
+    var fileName string = fmt.Sprintf("%s.toml", layer)
+    var relative string = filepath.Join(base, fileName)
+    resolved := filepath.Join(app.RootDirectory, relative)
+    var clean string = filepath.Clean(resolved)
+    log := app.Journal.WithField("file", clean)
+    _, err := os.Stat(clean) // check if file exists
+    if err != nil && !required { return } // optional
+    if err != nil { panic(fmt.Errorf(estat, clean)) }
+    log.Info("loading application config file")
+    loaded, err := toml.LoadFile(clean) // load it up!
+    if err != nil { panic(fmt.Errorf(eload, err.Error())) }
+    mergeConfig(tree, loaded, nil, relative, sources)
+}
+
+// Merge the source tree into the destination tree, recording the layer
+// as the source of every leaf key being merged. Tables are merged key
+// by key, recursively; all other values, including arrays of tables,
+// replace the ones in the destination entirely. The path is the path
+// of the trees being merged, relative to the root of the config.
+func mergeConfig(dst, src *toml.TomlTree, path []string, layer string, sources map[string] string) {
+    for _, key := range src.Keys() { // walk all keys
+        value := src.GetPath([]string { key }) // raw
+        full := append(append([]string {}, path...), key)
+        sub, isTree := value.(*toml.TomlTree) // table?
+        existing := dst.GetPath([]string { key }) // old
+        if old, ok := existing.(*toml.TomlTree); ok && isTree {
+            mergeConfig(old, sub, full, layer, sources)
+            continue // table has been merged
+        } // value replaces the destination entirely
+        dst.SetPath([]string { key }, value) // replace
+        markSources(sources, full, value, layer)
+    } // all the keys have been merged
+}
+
+// Record the layer as the source of the value at the path, replacing
+// any records for the previous value at the same path. Tables and the
+// arrays of tables are walked recursively, so that only the leaf keys
+// are recorded, with the numbers indexing the arrays of tables. Please
+// see the ConfigSource method for the usage of these records.
+func markSources(sources map[string] string, path []string, value interface {}, layer string) {
+    var key string = strings.Join(path, ".")
+    for k := range sources { // drop old records
+        if k == key || strings.HasPrefix(k, key + ".") {
+            delete(sources, k) // value is replaced
+        } // record is for the replaced value
+    } // previous records have been removed
+    var walk func(path []string, value interface {})
+    walk = func(path []string, value interface {}) {
+        switch v := value.(type) { // by kind
+            case *toml.TomlTree: // walk the table
+                for _, k := range v.Keys() { walk(append(path, k),
+                    v.GetPath([]string { k })) }
+            case []*toml.TomlTree: // walk the array
+                for i, t := range v { walk(append(path,
+                    strconv.Itoa(i)), t) }
+            default: // leaf key, record the source
+                sources[strings.Join(path, ".")] = layer
+        } // the value has been walked through
+    } // closure that walks the value recursively
+    walk(append([]string {}, path...), value)
+}
+
+// Apply the overrides from the environment variables that start with
+// the EnvironPrefix. The variable name is split into path segments by
+// the underscores; consecutive segments are joined with dashes, when
+// it is needed to match an existing key; see the overrideConfig. The
+// variables are applied in the lexicographical order of their names.
+func (app *App) environConfig(tree *toml.TomlTree, sources map[string] string) {
+    var environ []string = os.Environ() // all vars
+    sort.Strings(environ) // have a stable order
+    for _, pair := range environ { // walk all vars
+        name, value, _ := strings.Cut(pair, "=")
+        if !strings.HasPrefix(name, EnvironPrefix) { continue }
+        rest := strings.TrimPrefix(name, EnvironPrefix)
+        if len(rest) == 0 { continue } // just a prefix
+        segments := strings.Split(strings.ToLower(rest), "_")
+        app.overrideConfig(tree, sources, segments, "-", value, name)
+    } // all the environment overrides are applied
+}
+
+// Override a single config key, identified by the path segments, with
+// the textual value. Existing tables and arrays of tables are descended
+// into, matching the longest run of segments joined with the joiner;
+// numbers index the arrays of tables. Remaining segments are joined
+// into a new key; or nested into new tables, if the joiner is empty.
+// The text is coerced to the type of the value it overrides, if any.
+// Panics if the value could not be coerced, failing the boot early.
+func (app *App) overrideConfig(tree *toml.TomlTree, sources map[string] string, segments []string, joiner, text, layer string) {
+    const eoverride = "invalid config override %v: %v"
+    var node *toml.TomlTree = tree // current table
+    var path []string = nil // full path to the node
+    var i int = 0 // segments consumed so far
+    for i < len(segments) - 1 { // leave the leaf
+        matched := false // did any table match?
+        for j := len(segments) - 1; j > i && !matched; j-- {
+            if joiner == "" && j != i + 1 { continue }
+            key := strings.Join(segments[i:j], joiner)
+            switch v := node.GetPath([]string { key }).(type) {
+                case *toml.TomlTree: // descend into table
+                    node, path, i = v, append(path, key), j
+                    matched = true // go on matching
+                case []*toml.TomlTree: // index the array
+                    n, err := strconv.Atoi(segments[j])
+                    if err != nil || n < 0 || n >= len(v) { break }
+                    if j + 1 >= len(segments) { break } // leaf
+                    node, i = v[n], j + 1 // descend into
+                    path = append(path, key, segments[j])
+                    matched = true // go on matching
+            } // this run of segments is not a table
+        } // tried all the runs of the segments
+        if !matched { break } // the rest is the key
+    } // descended as deep as the tables allow
+    var keys = []string { strings.Join(segments[i:], joiner) }
+    if joiner == "" { keys = segments[i:] } // nested
+    value, err := coerceConfig(node.GetPath(keys), text)
+    if err != nil { panic(fmt.Errorf(eoverride, layer, err)) }
+    node.SetPath(keys, value) // override the value
+    markSources(sources, append(path, keys...), value, layer)
+    log := app.Journal.WithField("key", strings.Join(append(path, keys...), "."))
+    log.WithField("layer", layer).Info("config key is overriden")
+}
+
+// Coerce the textual value of an override into the type of the value
+// it overrides. The text is parsed as a TOML value, such as a number,
+// boolean, date or array; if it does not parse, it is taken as string.
+// Strings are always taken verbatim, without parsing. Tables could not
+// be overriden with a single value; that is reported as an error.
+func coerceConfig(existing interface {}, text string) (interface {}, error) {
+    const etype = "expected %T, got %q"
+    var parsed interface {} = text // fallback to string
+    if t, err := toml.Load("v = " + text); err == nil {
+        if t.Has("v") && len(t.Keys()) == 1 { parsed = t.Get("v") }
+    } // parsed it as a TOML value, if possible
+    switch existing.(type) { // what is overriden?
+        case nil: return parsed, nil // new key, as is
+        case string: return text, nil // verbatim
+        case *toml.TomlTree, []*toml.TomlTree: // tables
+            return nil, fmt.Errorf("cannot override a table")
+    } // the value should be of the existing type
+    if reflect.TypeOf(parsed) != reflect.TypeOf(existing) {
+        return nil, fmt.Errorf(etype, existing, text)
+    } // parsed value is of the same type, use it
+    return parsed, nil // coerced value
+}
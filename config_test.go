@@ -0,0 +1,135 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "io"
+import "strings"
+import "testing"
+import "reflect"
+
+import "github.com/pelletier/go-toml"
+import "github.com/Sirupsen/logrus"
+
+func TestCoerceConfig(t *testing.T) {
+    table, _ := toml.Load("a = 1") // could not be overriden
+    var cases = []struct {
+        existing interface {} // value that is overriden
+        text string // the override, as it is given
+        want interface {} // nil if an error is expected
+    } {
+        { nil, "42", int64(42) }, { nil, "true", true },
+        { nil, "hello", "hello" }, { nil, `"quoted"`, "quoted" },
+        { nil, "[1, 2]", []interface {} { int64(1), int64(2) } },
+        { "text", "42", "42" }, { "text", `"quoted"`, `"quoted"` },
+        { int64(1), "42", int64(42) }, { int64(1), "forty", nil },
+        { 1.5, "2.5", 2.5 }, { 1.5, "2", nil },
+        { false, "true", true }, { false, "yes", nil },
+        { table, "1", nil }, { []*toml.TomlTree { table }, "1", nil },
+    } // overrides of the values of various types
+    for _, c := range cases { // coerce every one
+        got, err := coerceConfig(c.existing, c.text)
+        if c.want == nil && err == nil { // must fail
+            t.Errorf("coerceConfig(%#v, %q) is %#v, want error", c.existing, c.text, got)
+        } else if c.want != nil && !reflect.DeepEqual(got, c.want) {
+            t.Errorf("coerceConfig(%#v, %q) is %#v, %v; want %#v", c.existing, c.text, got, err, c.want)
+        } // the outcome is as expected
+    }
+}
+
+func TestOverrideConfig(t *testing.T) {
+    const config = `
+        [app]
+        grace-period = "10s"
+        [app.servers.http-api]
+        port = 8080
+        [[app.databases.sql]]
+        intent = "main"
+        max-open = 4
+    `
+    var cases = []struct {
+        segments []string // path of the override
+        joiner string // as the environment or flags
+        text string // value of the override
+        path string // where the value must end up
+        want interface {} // the expected value there
+    } {
+        { []string { "app", "grace-period" }, "", "5s", "app.grace-period", "5s" },
+        { []string { "app", "servers", "http", "api", "port" }, "-", "9090", "app.servers.http-api.port", int64(9090) },
+        { []string { "app", "databases", "sql", "0", "max", "open" }, "-", "8", "app.databases.sql.0.max-open", int64(8) },
+        { []string { "app", "grace", "period" }, "-", "5s", "app.grace-period", "5s" },
+        { []string { "app", "new", "key" }, "", "true", "app.new.key", true },
+        { []string { "app", "new", "key" }, "-", "true", "app.new-key", true },
+    } // overrides as given by the environment or flags
+    for _, c := range cases { // override every one
+        tree, err := toml.Load(config) // fresh each time
+        if err != nil { t.Fatalf("cannot load config: %v", err) }
+        sources := make(map[string] string) // provenance
+        quietApp().overrideConfig(tree, sources, c.segments, c.joiner, c.text, "test")
+        var got interface {} = configAt(tree, c.path)
+        if !reflect.DeepEqual(got, c.want) { // as expected?
+            t.Errorf("override %v is %#v at %v, want %#v", c.segments, got, c.path, c.want)
+        } // the value has been overriden in place
+        if sources[c.path] != "test" { t.Errorf("source of %v is %q", c.path, sources[c.path]) }
+    }
+}
+
+func TestOverrideConfigMismatch(t *testing.T) {
+    tree, _ := toml.Load("[app]\nport = 8080")
+    app := quietApp() // journal is discarded
+    defer func() { // overriding must panic
+        r := recover(); if r == nil { t.Fatal("mismatched override did not panic") }
+        if !strings.Contains(r.(error).Error(), "invalid config override test") {
+            t.Errorf("unexpected panic: %v", r)
+        } // panic names the layer of the override
+    }() // check the outcome of the panic
+    app.overrideConfig(tree, map[string] string {}, []string { "app", "port" }, "", "http", "test")
+}
+
+// Make the bare application, as if it has been booted; but without any
+// config, providers or services. Its journal is discarded, in order to
+// keep the output of the tests clean; only the warnings are journaled.
+func quietApp() *App {
+    app := New("quiet", "1.0.0") // bare application
+    app.Journal = app.makeJournal(logrus.WarnLevel)
+    app.Journal.Out = io.Discard // keep the output clean
+    return app
+}
+
+// Obtain the value at the dotted path within the config tree, where
+// the numeric segments index the arrays of tables; nil if not found.
+func configAt(tree *toml.TomlTree, path string) interface {} {
+    var value interface {} = tree // walk from the root
+    for _, segment := range strings.Split(path, ".") {
+        switch v := value.(type) { // descend into it
+            case *toml.TomlTree: value = v.GetPath([]string { segment })
+            case []*toml.TomlTree: // index the array
+                var n int = int(segment[0] - '0') // single digit
+                if n < 0 || n >= len(v) { return nil }
+                value = v[n] // the table at the index
+            default: return nil // not a container
+        } // descended one level deeper
+    } // got to the value at the path
+    return value
+}
@@ -26,9 +26,130 @@ package boot
 import "time"
 import "net/http"
 import "sync"
+import "fmt"
+import "runtime/debug"
 
+import stdctx "context"
+
+import "github.com/renstrom/shortuuid"
 import "github.com/Sirupsen/logrus"
 
+// Run the business logic of the operation within this context, giving
+// it no more than the specified amount of time to complete. The std
+// context embedded into this one is replaced with a derived one, that
+// gets cancelled once the timeout fires or the parent is cancelled;
+// and the parent is restored as soon as the logic returns, so that the
+// middleware epilogues, the supervisor and the aux invocations made
+// afterwards are not affected. If the logic is abandoned, because it
+// has timed out or has been cancelled, it keeps the cancelled context,
+// so it could notice that and wind down. Panics are returned as the
+// PanicError values, along with the stack trace of the panic.
+func (c *Context) execute(op Operation, timeout time.Duration, logic BiasedLogic) error {
+    var parent stdctx.Context = c.Context // parent
+    if parent == nil { parent = stdctx.Background() }
+    scope, cancel := stdctx.WithTimeout(parent, timeout)
+    defer cancel() // logic is done or abandoned
+    value := make(chan error, 1) // panic, if any
+    var abandoned bool = false // guarded by lock
+    c.Lock(); c.Context = scope; c.Unlock() // swap
+    go func() { // wrap as asynchronous code
+        defer func() { // intercept the panics
+            x := recover() // panic value, if any
+            c.Lock() // restore the parent, unless
+            if !abandoned { c.Context = parent }
+            c.Unlock() // nobody waits for it anymore
+            if x == nil { value <- nil; return } // OK
+            stack := debug.Stack() // capture the trace
+            value <- newPanicError(c, op, x, stack)
+        }() // panic has been intercepted, if any
+        logic(c) // run the business logic
+    }() // spin off go-routine to execute it
+    select { // wait for either of 2 channels
+        case err := <- value: return err // done
+        case <- scope.Done(): // timed out or killed
+            c.Lock(); abandoned = true; c.Unlock()
+            select { // logic may have just returned
+                case err := <- value: return err
+                default: // logic is still running
+            } // logic is abandoned, report why
+            switch scope.Err() { // reason of it
+                case stdctx.DeadlineExceeded: return OperationTimeout
+                default: return OperationCancelled // killed
+            }
+    }
+}
+
+// Lookup the value stored under the specified key, falling back from
+// the context storage to the service storage (if context is bound to
+// a service) and then to the application storage. The first storage
+// that has the key wins. Second value that is returned indicates if
+// the key was found in any of the storages. See Resolve function.
+func (c *Context) Lookup(key string) (interface {}, bool) {
+    if value, ok := c.Storage.Get(key); ok {
+        return value, true // found in the context
+    } // not found in context, try the service
+    if c.Service != nil { // bound to service?
+        if value, ok := c.Service.Get(key); ok {
+            return value, true // found in service
+        } // not found in service, try the app
+    } // finally, look into the application
+    return c.App.Get(key) // the last resort
+}
+
+// Invoke an aux operation that is mounted within the service having
+// the specified prefix; empty prefix means the service of this context.
+// The aux will be run through its compiled pipeline, within a child
+// context derived from this one. Child context is returned, so that
+// the caller could read the results of the aux operation off of it.
+func (c *Context) Invoke(prefix, handle string) (*Context, error) {
+    const eservice = "no service with prefix %v"
+    const eaux = "no aux %v within service %v"
+    var target *Service = c.Service // own service
+    if len(prefix) > 0 { // lookup service by prefix
+        target = nil // forget about the own service
+        for _, srv := range c.App.Services { // walk
+            if srv.Prefix == prefix { target = srv; break }
+        } // service is either found or absent
+    } // either own or foreign service is a target
+    if target == nil { // no such service exists?
+        return nil, fmt.Errorf(eservice, prefix)
+    } // ok, service exists; go find the aux
+    aux := target.Auxes[handle] // fetch aux op
+    if aux == nil { // no such aux op in service?
+        return nil, fmt.Errorf(eaux, handle, target)
+    } // ok, aux op exists and could be invoked
+    child := c.derive(target) // aux context
+    child.Journal = child.Journal.WithField("aux", aux)
+    if aux.Compiled.IsZero() { // service is down?
+        child.Journal.Warn("aux pipeline is not compiled")
+        return child, OperationUnavailable // is N/A
+    } // pipeline is compiled and ready to run
+    aux.Run(child) // run aux within child context
+    return child, child.issue // the aux error
+}
+
+// Derive a new context from this one, bound to the supplied service.
+// Derived context shares the application, the HTTP request and the
+// responder with its parent; but has a separate reference, storage
+// and a copy of the data. Its standard context is a child of parent
+// context, so cancelling the parent will cancel the derived as well.
+func (c *Context) derive(srv *Service) *Context {
+    var room = make(map[string] interface {})
+    child := &Context { App: c.App, Service: srv }
+    child.Created = time.Now() // mark an instant
+    child.Reference = shortuuid.New() // V4
+    child.Storage = Storage { Container: room }
+    child.Context = c.Context // inherit cancelation
+    child.Request = c.Request // same HTTP request
+    child.ResponseWriter = c.ResponseWriter // same
+    child.Data = make(map[string] string) // copy
+    for k, v := range c.Data { child.Data[k] = v }
+    log := c.Journal.WithField("sub", child.Reference)
+    if srv != nil { log = log.WithField("service", srv) }
+    child.Journal = log // structured logger
+    return child // derived context is ready
+}
+
 // Unique object that captures the details needed to invoke the Logic
 // typed function. Usually, context will include an HTTP request object,
 // a means of responding to the HTTP request, as well as references to
@@ -72,10 +193,10 @@ type Context struct {
     Journal *logrus.Entry
 
     // Aggregated storage of input parameters, collected of multiple
-    // source. When context represents an HTTP request, field typically
-    // contains URL parameters, query parameters and sometimes body
-    // parameters aggregated together. Contents of this field could
-    // be access and manipulates pretty much at at point of app.
+    // source. When context represents an HTTP request, field contains
+    // the route placeholders, query parameters, form fields and JSON
+    // body fields, merged according to the BindingPrecedence. Use the
+    // Bind method to decode these into a tagged Go struct instead.
     Data map[string] string
 
     // General purpose storage for keeping key/value records per the
@@ -105,4 +226,18 @@ type Context struct {
     // rare occasions, it is possible that the pointer will have nil
     // value, indicating that there was no Service to attach.
     Service *Service
+
+    // Standard context that carries the cancellation signal for any
+    // work done within this context. It is derived from the HTTP request
+    // context, if any, and will be cancelled when an operation times out,
+    // the client disconnects or the application is shutting down. Pass
+    // the boot.Context itself wherever a standard context is expected.
+    stdctx.Context
+
+    // Error value that the operation application has ended with, if
+    // any. It is recorded by the pipeline once the operation has been
+    // applied within this context, so that the caller that has run the
+    // pipeline could learn about the outcome. See the Invoke method
+    // that uses this field to report the aux operation outcome back.
+    issue error
 }
@@ -0,0 +1,195 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "os"
+import "time"
+import "strings"
+import "net/http"
+import "path/filepath"
+import "encoding/json"
+import "fmt"
+
+// Error that represents a panic that has occured while applying an
+// operation. Besides the recovered panic value, it carries the stack
+// trace of the go-routine that has paniced, the definition of the op,
+// the reference of the context and the request metadata, if any. This
+// is the error that is passed to the Supervisor.OperationPaniced.
+type PanicError struct {
+
+    // Value that has been recovered from the panic, exactly as it has
+    // been passed to the panic call. It is not serialized into JSON,
+    // since it could be of any type; its string represenation and the
+    // type name are serialized instead. If the value is an error, then
+    // it could be reached by unwrapping the panic error.
+    Value interface {} `json:"-"`
+
+    // String represenation of the recovered panic value, as well as
+    // the name of its type. These are derived from the value when the
+    // panic error is created and are used for serialization, as well
+    // as for building up the human readable error message.
+    Message string `json:"message"`; Kind string `json:"type"`
+
+    // Full stack trace of the go-routine that has paniced, as it has
+    // been captured at the moment of recovering from the panic. Please
+    // refer to the runtime/debug package and its Stack function for
+    // the details on the format of the stack trace itself.
+    Stack string `json:"stack"`
+
+    // Identity and the definition of the operation that has paniced.
+    // Operation identity is its string represenation, as returned by
+    // the String method; definition is the source location of where
+    // the operation is defined. See Operation interface for details.
+    Operation string `json:"operation"`; Source SourceLocation `json:"source"`
+
+    // Reference of the context that operation has paniced within, and
+    // the instant in time when it did panic. Reference could be used to
+    // find all the journal entries related to the context; since every
+    // journal entry of the context carries the same reference.
+    Reference string `json:"reference"`; Moment time.Time `json:"time"`
+
+    // Metadata of the HTTP request that the context represents, if
+    // there is any. Headers that may carry the credentials, such as the
+    // Authorization, Cookie or X-Api-Key, are redacted. This may be nil
+    // in case if the operation has paniced while not serving a request.
+    Request *RequestInfo `json:"request,omitempty"`
+}
+
+// Metadata of an HTTP request, as it is captured for the purpose of
+// the crash reports. It contains the method, URL, remote address and
+// the headers of the request, with the credentials being redacted. The
+// request body is not captured, since it may be already consumed and
+// may also be too large. See PanicError for the usage of this struct.
+type RequestInfo struct {
+    Method string `json:"method"` // HTTP verb
+    URL string `json:"url"` // as requested
+    RemoteAddr string `json:"remote"` // host:port
+    Header http.Header `json:"header"` // redacted
+}
+
+// Create a new panic error from the value that has been recovered
+// from a panic and the captured stack trace. The error will be filled
+// in with the details of the operation and the context, including the
+// metadata of the HTTP request, if the context represents any. This is
+// used by the framework when it recovers from operation panics.
+func newPanicError(c *Context, op Operation, value interface {}, stack []byte) *PanicError {
+    pe := &PanicError { Value: value, Stack: string(stack) }
+    pe.Message = fmt.Sprint(value) // human readable
+    pe.Kind = fmt.Sprintf("%T", value) // type name
+    pe.Operation = op.String() // operation identity
+    pe.Source = op.Definition() // where it is defined
+    pe.Reference = c.Reference // context identity
+    pe.Moment = time.Now() // when it has paniced
+    if r := c.Request; r != nil { // got request?
+        pe.Request = &RequestInfo { Method: r.Method }
+        pe.Request.URL = r.RequestURI // as requested
+        pe.Request.RemoteAddr = r.RemoteAddr // remote
+        pe.Request.Header = r.Header.Clone() // copy
+        for name := range pe.Request.Header { // walk
+            if !sensitiveHeader(name) { continue } // safe
+            pe.Request.Header[name] = []string { "[redacted]" }
+        } // credentials have been redacted out
+    } // request metadata has been captured
+    return pe // panic error is ready
+}
+
+// Fragments of the names of the request headers that may carry the
+// credentials, such as Authorization, Proxy-Authorization, Cookie,
+// X-Api-Key or X-Auth-Token. Headers with any of these in their names
+// are redacted out of the crash reports, since these are written to
+// disk; it is better to lose some of the details than to leak secrets.
+var sensitiveFragments = []string {
+    "auth", "cookie", "token", "key", "secret",
+    "session", "password", "credential", "signature",
+}
+
+// Whether the request header with the specified name may carry any
+// credentials, and so it should be redacted out of the crash reports.
+// The name is matched case insensitively against sensitiveFragments.
+func sensitiveHeader(name string) bool {
+    var lower string = strings.ToLower(name)
+    for _, fragment := range sensitiveFragments {
+        if strings.Contains(lower, fragment) { return true }
+    } // none of the fragments are in the name
+    return false
+}
+
+// Error message of the panic error; contains the operation identity,
+// the string represenation of the recovered value and the location of
+// the operation definition. Stack trace is not included in the message
+// as it would be too verbose; use the Stack field to access it. This
+// makes the panic error implement the standard error interface.
+func (pe *PanicError) Error() string {
+    const format = "operation %v paniced: %v (defined at %v)"
+    return fmt.Sprintf(format, pe.Operation, pe.Message, pe.Source)
+}
+
+// Unwrap the panic error, to get to the recovered value, if that value
+// is an error itself. This allows to use errors.Is and errors.As with
+// the panic errors, to check for the errors that have been passed to
+// panic calls by the application code. If the recovered value is not
+// an error, then nil is returned, according to the convention.
+func (pe *PanicError) Unwrap() error {
+    err, _ := pe.Value.(error)
+    return err // nil if not an error
+}
+
+// Crash report, as it is written by the crash report sink into a file.
+// It contains the identity of the application instance, along with the
+// panic error that has caused the report. Reports are written as JSON
+// files, so they could be triaged later, either manually or with the
+// help of some tools. See the App.reportCrash method for the details.
+type CrashReport struct {
+    Name string `json:"name"` // application name
+    Version string `json:"version"` // semver
+    Instance string `json:"instance"` // app reference
+    Env string `json:"env"` // current environment
+    Panic *PanicError `json:"panic"` // what happened
+}
+
+// Write the crash report for the specified panic error, if the crash
+// report sink is enabled by the app.crash-reports config key. The key
+// should point to a directory relative to the application root, where
+// reports will be written as JSON files, named by the time and context
+// reference. Failure to write a report is journaled, but not fatal.
+func (app *App) reportCrash(pe *PanicError) {
+    tree := app.CurrentConfig() // as it is now
+    if tree == nil { return } // app is not booted
+    base, ok := tree.Get("app.crash-reports").(string)
+    if !ok || len(base) == 0 { return } // disabled
+    directory := filepath.Join(app.RootDirectory, base)
+    stamp := pe.Moment.UTC().Format("20060102T150405.000")
+    name := fmt.Sprintf("%s-%s.json", stamp, pe.Reference)
+    path := filepath.Join(filepath.Clean(directory), name)
+    report := &CrashReport { Name: app.Name, Env: app.Env }
+    report.Version = app.Version.String() // semver
+    report.Instance = app.Reference // app instance
+    report.Panic = pe // the panic error itself
+    log := app.Journal.WithField("file", path) // log
+    data, err := json.MarshalIndent(report, "", "  ")
+    if err == nil { err = os.MkdirAll(directory, 0750) }
+    if err == nil { err = os.WriteFile(path, data, 0640) }
+    if err != nil { log.WithError(err).Error("failed to write crash report") }
+    if err == nil { log.Warn("crash report has been written") }
+}
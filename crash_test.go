@@ -0,0 +1,50 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "testing"
+import "net/http/httptest"
+
+func TestPanicErrorRedaction(t *testing.T) {
+    r := httptest.NewRequest("GET", "/crash", nil)
+    var headers = map[string] bool { // redacted?
+        "Authorization": true, "Proxy-Authorization": true,
+        "Cookie": true, "X-Api-Key": true, "X-Auth-Token": true,
+        "X-Session-Id": true, "X-Client-Secret": true,
+        "Accept": false, "User-Agent": false, "X-Request-Id": false,
+    } // headers of the request and whether to redact
+    for name := range headers { r.Header.Add(name, "value"); r.Header.Add(name, "other") }
+    context := &Context { Request: r, Reference: "crash" }
+    ep := &Endpoint { Pattern: "/crash" } // paniced
+    pe := newPanicError(context, ep, "boom", nil) // capture
+    for name, redacted := range headers { // check all
+        values := pe.Request.Header.Values(name) // captured
+        if redacted && (len(values) != 1 || values[0] != "[redacted]") {
+            t.Errorf("header %v is not redacted: %v", name, values)
+        } // credentials must not reach the disk
+        if !redacted && len(values) != 2 { t.Errorf("header %v is mangled: %v", name, values) }
+    }
+    if r.Header.Get("Authorization") != "value" { t.Error("request headers have been modified") }
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "time"
+import "fmt"
+import "database/sql"
+
+import stdctx "context"
+
+// Prefix of the storage keys, under which the SQL databases that are
+// opened by the built-in providers are stored in the app storage. The
+// rest of the key is the intent of the database, as declared in the
+// config. Please use the Context.DB method to obtain the databases,
+// rather than fetching them from the storage by these keys directly.
+const DatabasePrefix = "boot.sql."
+
+// Declaration of the SQL database, as it is decoded from the array of
+// tables app.databases.sql within the config. The driver must be one
+// that is registered with the database/sql package, by importing it.
+// Pool settings are applied as is; zero values leave the defaults of
+// the database/sql package. See the installDatabases method for usage.
+type sqlConfig struct {
+    Intent string `config:"intent,required" validate:"pattern=^[a-zA-Z0-9-_]+$"`
+    Driver string `config:"driver,required"` // registered
+    DSN string `config:"dsn,required"` // data source name
+    MaxOpen int `config:"max-open" validate:"min=0"`
+    MaxIdle int `config:"max-idle" validate:"min=0"`
+    MaxLifetime time.Duration `config:"max-lifetime"`
+    MaxIdleTime time.Duration `config:"max-idle-time"`
+    PingTimeout time.Duration `config:"ping-timeout" default:"5s"`
+}
+
+// Install the built-in providers of the SQL databases that are declared
+// in the app.databases.sql array of tables within the config; one per
+// every declared database. The provider is named sql-<intent>, so that
+// other providers could depend on it. This is invoked by the Boot, once
+// the config is loaded. Panics if the declarations are malformed.
+func (app *App) installDatabases() {
+    const edup = "duplicate SQL database intent %v"
+    var declared struct { // app.databases section
+        SQL []sqlConfig `config:"sql"` // in order
+    } // declarations of the SQL databases
+    err := app.DecodeConfig("app.databases", &declared)
+    if err != nil { panic(err) } // malformed config
+    seen := make(map[string] bool) // intents used
+    for _, d := range declared.SQL { // install all
+        if seen[d.Intent] { panic(fmt.Errorf(edup, d.Intent)) }
+        seen[d.Intent] = true; config := d // per provider
+        app.Provider(func(p *Provider) {
+            p.Name = "sql-" + config.Intent // dependable
+            p.About = fmt.Sprintf("SQL database %v", config.Intent)
+            p.Available[app.Env] = true // configured here
+            p.Setup = func(app *App) { app.openDatabase(config) }
+            p.Cleanup = func(app *App) { app.closeDatabase(config) }
+        }) // provider of the database is installed
+    } // all the declared databases are installed
+}
+
+// Open the declared SQL database, configure its connection pool and
+// ping it, to make sure the database is reachable; then store it in the
+// app storage, under the DatabasePrefix followed by the intent. Panics
+// if the database could not be opened or does not respond to the ping
+// within the timeout, failing the boot early, rather than on requests.
+func (app *App) openDatabase(config sqlConfig) {
+    const eopen = "failed to open SQL database %v: %v"
+    log := app.Journal.WithField("intent", config.Intent)
+    log = log.WithField("driver", config.Driver) // kind
+    db, err := sql.Open(config.Driver, config.DSN)
+    if err != nil { panic(fmt.Errorf(eopen, config.Intent, err)) }
+    db.SetMaxOpenConns(config.MaxOpen) // zero is unlimited
+    if config.MaxIdle > 0 { db.SetMaxIdleConns(config.MaxIdle) }
+    db.SetConnMaxLifetime(config.MaxLifetime) // reuse
+    db.SetConnMaxIdleTime(config.MaxIdleTime) // idle
+    bg := stdctx.Background() // root context
+    ctx, cancel := stdctx.WithTimeout(bg, config.PingTimeout)
+    defer cancel() // release context resources
+    if err := db.PingContext(ctx); err != nil {
+        db.Close() // release whatever has been opened
+        panic(fmt.Errorf(eopen, config.Intent, err))
+    } // database is reachable, make it available
+    app.Storage.Set(DatabasePrefix + config.Intent, db)
+    log.Info("opened connection to SQL database")
+}
+
+// Close the declared SQL database, that has been opened by its provider
+// and remove it from the app storage. This waits for all the queries
+// that have been started to finish, as documented by the database/sql
+// package. Errors of closing are journaled, but are not propagated, as
+// the application is shutting down anyway by the time this is invoked.
+func (app *App) closeDatabase(config sqlConfig) {
+    var key string = DatabasePrefix + config.Intent
+    log := app.Journal.WithField("intent", config.Intent)
+    db, err := Load[*sql.DB](&app.Storage, key) // opened?
+    if err != nil { return } // it has never been opened
+    app.Storage.Delete(key) // no longer available
+    if err := db.Close(); err != nil { // flush
+        log.WithError(err).Warn("failed to close SQL database")
+        return // nothing else could be done here
+    } // database has been closed successfully
+    log.Info("closed connection to SQL database")
+}
+
+// Obtain the SQL database with the specified intent, as declared in the
+// app.databases.sql section of the config and opened by the built-in
+// provider. The database is looked up the same way as Resolve does,
+// so it could be overriden in the context or the service storage; for
+// example, to point the service to some other database in the tests.
+// Panics if it could not be found, as it is a configuration error.
+func (c *Context) DB(intent string) *sql.DB {
+    const emissing = "no SQL database with intent %v: %v"
+    db, err := Resolve[*sql.DB](c, DatabasePrefix + intent)
+    if err != nil { panic(fmt.Errorf(emissing, intent, err)) }
+    return db // opened and pinged database
+}
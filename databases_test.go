@@ -0,0 +1,415 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "io"
+import "time"
+import "sync"
+import "errors"
+import "regexp"
+import "strings"
+import "strconv"
+import "testing"
+import "database/sql"
+import "database/sql/driver"
+import "fmt"
+
+import stdctx "context"
+
+// Name of the fake SQL driver, registered with the database/sql package
+// for the tests. The data source name picks one of the in-memory fake
+// databases, so that many sql.DB handles could share the same data, as
+// the app instances share a real database. The "unreachable" DSN fails
+// the ping; see the fakeDatabase for how to inject other failures.
+const fakeDriverName = "boot-fake"
+
+var fakeSQL *fakeDriver = &fakeDriver {} // registered
+
+func init() { sql.Register(fakeDriverName, fakeSQL) }
+
+// Driver of the fake in-memory SQL databases, keyed by the data source
+// name. It understands the handful of statements that the framework is
+// issuing against the databases: CREATE TABLE, DROP TABLE, INSERT, the
+// SELECT, UPDATE and DELETE with the equality conditions joined by AND.
+// Values are inlined into statements; placeholders are not supported.
+type fakeDriver struct {
+    databases map[string] *fakeDatabase // by DSN
+    sync.Mutex // guards the map of databases
+}
+
+// Fake in-memory SQL database; tables hold their rows as maps of the
+// column names to the values. Statements that match the fail pattern
+// are failing with the I/O error, to imitate a broken database. The
+// snapshot is taken on the transaction begin and restored on rollback;
+// transactions are not isolated, which is fine for sequential tests.
+type fakeDatabase struct {
+    tables map[string] *fakeTable // by name
+    snapshot map[string] *fakeTable // of tx
+    fail *regexp.Regexp // statements to fail
+    statements []string // all, as executed
+    sync.Mutex // guards all of the above
+}
+
+// Table of the fake SQL database, with the columns in the order they
+// have been declared, the primary key column, if any, and the rows.
+type fakeTable struct {
+    columns []string // in declared order
+    primary string // primary key column
+    rows []map[string] driver.Value
+}
+
+// Obtain the fake database with the specified data source name, that
+// is shared between all the connections opened with the same name. It
+// is created empty upon the first request; see fakeDriver.Open too.
+func fakeDB(dsn string) *fakeDatabase {
+    d := fakeSQL // the one that has been registered
+    d.Lock(); defer d.Unlock() // guard the map
+    if d.databases == nil { d.databases = make(map[string] *fakeDatabase) }
+    if db, ok := d.databases[dsn]; ok { return db }
+    db := &fakeDatabase { tables: make(map[string] *fakeTable) }
+    d.databases[dsn] = db; return db
+}
+
+func (d *fakeDriver) Open(dsn string) (driver.Conn, error) {
+    return &fakeConn { dsn: dsn, db: fakeDB(dsn) }, nil
+}
+
+// Fail the statements that match the pattern with the I/O error; the
+// empty pattern stops failing any statements at all.
+func (db *fakeDatabase) failing(pattern string) {
+    db.Lock(); defer db.Unlock() // guard it
+    db.fail = nil // do not fail anything
+    if pattern != "" { db.fail = regexp.MustCompile(pattern) }
+}
+
+// Rows of the table with the specified name; nil if there is no table.
+func (db *fakeDatabase) rows(table string) []map[string] driver.Value {
+    db.Lock(); defer db.Unlock() // guard it
+    t, ok := db.tables[table] // look it up
+    if !ok { return nil } // there is no table
+    return append([]map[string] driver.Value(nil), t.rows...)
+}
+
+// Deep copy of the tables, to be used as the snapshot for the rollback.
+func (db *fakeDatabase) copyTables() map[string] *fakeTable {
+    copied := make(map[string] *fakeTable, len(db.tables))
+    for name, t := range db.tables { // copy each
+        c := &fakeTable { columns: t.columns, primary: t.primary }
+        for _, row := range t.rows { // copy rows
+            r := make(map[string] driver.Value, len(row))
+            for k, v := range row { r[k] = v }
+            c.rows = append(c.rows, r)
+        } // rows have been copied
+        copied[name] = c
+    } // all tables have been copied
+    return copied
+}
+
+// Connection to the fake database; it supports the transactions and
+// the ping, which fails for the "unreachable" data source name.
+type fakeConn struct { dsn string; db *fakeDatabase }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+    return &fakeStmt { conn: c, query: query }, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+    c.db.Lock(); defer c.db.Unlock() // guard it
+    c.db.snapshot = c.db.copyTables() // to restore
+    return &fakeTx { c.db }, nil
+}
+
+func (c *fakeConn) Ping(ctx stdctx.Context) error {
+    if c.dsn == "unreachable" { return errors.New("connection refused") }
+    return nil
+}
+
+// Transaction of the fake database; rollback restores the snapshot.
+type fakeTx struct { db *fakeDatabase }
+
+func (tx *fakeTx) Commit() error {
+    tx.db.Lock(); defer tx.db.Unlock()
+    tx.db.snapshot = nil; return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+    tx.db.Lock(); defer tx.db.Unlock()
+    if tx.db.snapshot != nil { tx.db.tables = tx.db.snapshot }
+    tx.db.snapshot = nil; return nil
+}
+
+// Statement of the fake database; executed as soon as it is invoked.
+type fakeStmt struct { conn *fakeConn; query string }
+
+func (s *fakeStmt) Close() error { return nil }
+func (s *fakeStmt) NumInput() int { return 0 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+    _, affected, err := s.conn.db.execute(s.query)
+    return driver.RowsAffected(affected), err
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+    rows, _, err := s.conn.db.execute(s.query)
+    if err != nil { return nil, err } // failed
+    if rows == nil { rows = &fakeRows {} } // none
+    return rows, nil
+}
+
+// Result set of the SELECT statement against the fake database.
+type fakeRows struct { columns []string; values [][]driver.Value }
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+    if len(r.values) == 0 { return io.EOF }
+    copy(dest, r.values[0]); r.values = r.values[1:]
+    return nil
+}
+
+// Patterns of the statements that the fake database understands.
+var (
+    fakeCreate = regexp.MustCompile(`(?is)^CREATE TABLE (IF NOT EXISTS )?(\w+) \((.*)\)$`)
+    fakeDrop = regexp.MustCompile(`(?is)^DROP TABLE (IF EXISTS )?(\w+)$`)
+    fakeInsert = regexp.MustCompile(`(?is)^INSERT INTO (\w+) \(([^)]*)\) VALUES \((.*)\)$`)
+    fakeSelect = regexp.MustCompile(`(?is)^SELECT (.+?) FROM (\w+)(?: WHERE (.*))?$`)
+    fakeDelete = regexp.MustCompile(`(?is)^DELETE FROM (\w+)(?: WHERE (.*))?$`)
+    fakeUpdate = regexp.MustCompile(`(?is)^UPDATE (\w+) SET (.+?)(?: WHERE (.*))?$`)
+)
+
+// Execute the statement against the fake database; returns the rows
+// for the SELECT statements, and the number of the affected rows for
+// the others. Statements that are not understood fail with an error.
+func (db *fakeDatabase) execute(query string) (*fakeRows, int64, error) {
+    db.Lock(); defer db.Unlock() // one at a time
+    query = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+    db.statements = append(db.statements, query)
+    if db.fail != nil && db.fail.MatchString(query) {
+        return nil, 0, errors.New("disk I/O error")
+    } // statement is not failing, run it
+    if m := fakeCreate.FindStringSubmatch(query); m != nil {
+        if _, ok := db.tables[m[2]]; ok && m[1] != "" { return nil, 0, nil }
+        if _, ok := db.tables[m[2]]; ok { return nil, 0, fmt.Errorf("table %v already exists", m[2]) }
+        t := &fakeTable {} // declare the columns
+        for _, column := range strings.Split(m[3], ",") {
+            fields := strings.Fields(column) // name type
+            if len(fields) == 0 { continue } // empty
+            t.columns = append(t.columns, fields[0])
+            if strings.Contains(strings.ToUpper(column), "PRIMARY KEY") { t.primary = fields[0] }
+        } // all the columns have been declared
+        db.tables[m[2]] = t; return nil, 0, nil
+    } // not a CREATE TABLE statement
+    if m := fakeDrop.FindStringSubmatch(query); m != nil {
+        if _, ok := db.tables[m[2]]; !ok && m[1] == "" { return nil, 0, fmt.Errorf("no such table: %v", m[2]) }
+        delete(db.tables, m[2]); return nil, 0, nil
+    } // not a DROP TABLE statement
+    if m := fakeInsert.FindStringSubmatch(query); m != nil {
+        t, err := db.table(m[1]) // must exist
+        if err != nil { return nil, 0, err }
+        values, err := fakeValues(m[3], ",")
+        if err != nil { return nil, 0, err }
+        columns := strings.Split(m[2], ",")
+        if len(columns) != len(values) { return nil, 0, errors.New("values do not match columns") }
+        row := make(map[string] driver.Value) // new row
+        for i, c := range columns { row[strings.TrimSpace(c)] = values[i] }
+        for _, existing := range t.rows { // constraint
+            if t.primary == "" || existing[t.primary] != row[t.primary] { continue }
+            return nil, 0, fmt.Errorf("UNIQUE constraint failed: %v.%v", m[1], t.primary)
+        } // primary key is unique, insert it
+        t.rows = append(t.rows, row); return nil, 1, nil
+    } // not an INSERT statement
+    if m := fakeSelect.FindStringSubmatch(query); m != nil {
+        t, err := db.table(m[2]) // must exist
+        if err != nil { return nil, 0, err }
+        where, err := fakeWhere(m[3]) // conditions
+        if err != nil { return nil, 0, err }
+        rows := &fakeRows {} // the result set
+        for _, c := range strings.Split(m[1], ",") { rows.columns = append(rows.columns, strings.TrimSpace(c)) }
+        if m[1] == "*" { rows.columns = t.columns } // all
+        for _, row := range t.rows { // filter and project
+            if !fakeMatch(row, where) { continue }
+            values := make([]driver.Value, len(rows.columns))
+            for i, c := range rows.columns { values[i] = row[c] }
+            rows.values = append(rows.values, values)
+        } // all the matching rows are in the result
+        return rows, 0, nil
+    } // not a SELECT statement
+    if m := fakeDelete.FindStringSubmatch(query); m != nil {
+        t, err := db.table(m[1]) // must exist
+        if err != nil { return nil, 0, err }
+        where, err := fakeWhere(m[2]) // conditions
+        if err != nil { return nil, 0, err }
+        var kept []map[string] driver.Value // survivors
+        for _, row := range t.rows { if !fakeMatch(row, where) { kept = append(kept, row) } }
+        affected := int64(len(t.rows) - len(kept))
+        t.rows = kept; return nil, affected, nil
+    } // not a DELETE statement
+    if m := fakeUpdate.FindStringSubmatch(query); m != nil {
+        t, err := db.table(m[1]) // must exist
+        if err != nil { return nil, 0, err }
+        set, err := fakeWhere(m[2]) // assignments
+        if err != nil { return nil, 0, err }
+        where, err := fakeWhere(m[3]) // conditions
+        if err != nil { return nil, 0, err }
+        var affected int64 = 0 // number of updated
+        for _, row := range t.rows { // update matching
+            if !fakeMatch(row, where) { continue }
+            for k, v := range set { row[k] = v }
+            affected++ // one more has been updated
+        } // all the matching rows are updated
+        return nil, affected, nil
+    } // not an UPDATE statement either
+    return nil, 0, fmt.Errorf("unsupported statement: %v", query)
+}
+
+// Find the table by its name; fails if there is no such table.
+func (db *fakeDatabase) table(name string) (*fakeTable, error) {
+    t, ok := db.tables[name] // look it up
+    if !ok { return nil, fmt.Errorf("no such table: %v", name) }
+    return t, nil
+}
+
+// Whether the row satisfies all the equality conditions.
+func fakeMatch(row map[string] driver.Value, where map[string] driver.Value) bool {
+    for k, v := range where { if row[k] != v { return false } }
+    return true
+}
+
+// Parse the conditions or the assignments, such as a = 1 AND b = 'c',
+// into the map of the column names to the values; empty text is fine.
+func fakeWhere(text string) (map[string] driver.Value, error) {
+    pairs := make(map[string] driver.Value) // parsed
+    if strings.TrimSpace(text) == "" { return pairs, nil }
+    separator := regexp.MustCompile(`(?i)\s+AND\s+|\s*,\s*`)
+    for _, part := range fakeSplit(text, separator) {
+        column, literal, ok := strings.Cut(part, "=")
+        if !ok { return nil, fmt.Errorf("malformed condition: %v", part) }
+        values, err := fakeValues(literal, ",") // single
+        if err != nil || len(values) != 1 { return nil, fmt.Errorf("malformed value: %v", literal) }
+        pairs[strings.TrimSpace(column)] = values[0]
+    } // all the pairs have been parsed
+    return pairs, nil
+}
+
+// Split the text by the separator, except within the quoted literals.
+func fakeSplit(text string, separator *regexp.Regexp) []string {
+    var parts []string; var start int = 0
+    var quoted bool = false // within the literal?
+    for i := 0; i < len(text); i++ { // scan it
+        if text[i] == '\'' { quoted = !quoted; continue }
+        if quoted { continue } // skip the literal
+        loc := separator.FindStringIndex(text[i:])
+        if loc == nil || loc[0] != 0 || loc[1] == 0 { continue }
+        parts = append(parts, strings.TrimSpace(text[start:i]))
+        i += loc[1] - 1; start = i + 1 // skip it
+    } // the last part goes till the end
+    return append(parts, strings.TrimSpace(text[start:]))
+}
+
+// Parse the comma separated literals: quoted strings with the quotes
+// doubled within, integers and NULL; into the driver values.
+func fakeValues(text, separator string) ([]driver.Value, error) {
+    var values []driver.Value // parsed literals
+    comma := regexp.MustCompile(regexp.QuoteMeta(separator))
+    for _, literal := range fakeSplit(text, comma) { // each
+        switch { // by the kind of the literal
+            case strings.EqualFold(literal, "NULL"): values = append(values, nil)
+            case len(literal) >= 2 && literal[0] == '\'' && literal[len(literal) - 1] == '\'':
+                inner := literal[1:len(literal) - 1] // unquote
+                values = append(values, strings.ReplaceAll(inner, "''", "'"))
+            default: // must be an integer number
+                n, err := strconv.ParseInt(literal, 10, 64)
+                if err != nil { return nil, fmt.Errorf("malformed literal: %v", literal) }
+                values = append(values, n)
+        } // the literal has been parsed
+    } // all the literals have been parsed
+    return values, nil
+}
+
+// Declaration of the fake SQL database with the specified intent and
+// data source name, as it would be decoded from the config, defaults.
+func fakeConfig(intent, dsn string) sqlConfig {
+    config := sqlConfig { Intent: intent, Driver: fakeDriverName }
+    config.DSN, config.PingTimeout = dsn, time.Second
+    return config // ready to be opened
+}
+
+func TestOpenDatabase(t *testing.T) {
+    app := quietApp() // application to open into
+    config := fakeConfig("main", t.Name()) // shared
+    config.MaxOpen, config.MaxIdle = 4, 2 // pool
+    app.openDatabase(config) // must succeed
+    db, err := Load[*sql.DB](&app.Storage, DatabasePrefix + "main")
+    if err != nil { t.Fatalf("database is not stored: %v", err) }
+    if n := db.Stats().MaxOpenConnections; n != 4 { t.Errorf("max open is %v, want 4", n) }
+    if err := db.Ping(); err != nil { t.Errorf("database is not usable: %v", err) }
+}
+
+func TestOpenDatabaseUnreachable(t *testing.T) {
+    app := quietApp() // application to open into
+    config := fakeConfig("main", "unreachable")
+    defer func() { // opening must panic
+        r := recover(); if r == nil { t.Fatal("unreachable database did not panic") }
+        if !strings.Contains(fmt.Sprint(r), "connection refused") { t.Errorf("unexpected panic: %v", r) }
+        if _, ok := app.Storage.Get(DatabasePrefix + "main"); ok { t.Error("unreachable database is stored") }
+    }() // check the outcome of the panic
+    app.openDatabase(config) // must panic
+}
+
+func TestOpenDatabaseUnknownDriver(t *testing.T) {
+    app := quietApp() // application to open into
+    config := fakeConfig("main", t.Name()) // shared
+    config.Driver = "no-such-driver" // not registered
+    defer func() { if recover() == nil { t.Fatal("unknown driver did not panic") } }()
+    app.openDatabase(config) // must panic
+}
+
+func TestCloseDatabase(t *testing.T) {
+    app := quietApp() // application to open into
+    config := fakeConfig("main", t.Name()) // shared
+    app.openDatabase(config) // opened and stored
+    db, _ := Load[*sql.DB](&app.Storage, DatabasePrefix + "main")
+    app.closeDatabase(config) // closed and removed
+    if _, ok := app.Storage.Get(DatabasePrefix + "main"); ok { t.Error("closed database is still stored") }
+    if err := db.Ping(); err == nil { t.Error("database has not been closed") }
+    app.closeDatabase(config) // closing twice is fine
+}
+
+func TestContextDB(t *testing.T) {
+    app := quietApp() // application to open into
+    config := fakeConfig("main", t.Name()) // shared
+    app.openDatabase(config) // opened and stored
+    opened, _ := Load[*sql.DB](&app.Storage, DatabasePrefix + "main")
+    context := &Context { App: app } // bare context
+    if db := context.DB("main"); db != opened { t.Error("context does not resolve the app database") }
+    other, _ := sql.Open(fakeDriverName, t.Name() + "-other")
+    defer other.Close() // overrides the app one
+    context.Storage.Set(DatabasePrefix + "main", other)
+    if db := context.DB("main"); db != other { t.Error("context does not prefer its own database") }
+    defer func() { if recover() == nil { t.Error("missing database did not panic") } }()
+    context.DB("missing") // must panic
+}
@@ -0,0 +1,411 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "time"
+import "errors"
+import "reflect"
+import "strings"
+import "strconv"
+import "unicode"
+import "sync/atomic"
+import "fmt"
+
+import "github.com/pelletier/go-toml"
+
+// Error that is returned by the App.DecodeConfig method when some of
+// the config keys could not be decoded into the destination struct,
+// either because they are malformed, violate the declared rules or are
+// required but absent. It carries the errors for all offending keys,
+// not just the first one, so they all could be reported at once.
+type ConfigError struct {
+    Section string // dotted path of section
+    Fields []FieldError // keys, as dotted paths
+}
+
+// Error message of the config error; contains the section and the
+// messages of all the offending keys, joined together. This makes the
+// config error the implementation of the standard error interface. Use
+// Fields to access the errors of the individual keys in a structured
+// way; the keys are the full dotted paths within the app config.
+func (ce *ConfigError) Error() string {
+    messages := make([]string, 0, len(ce.Fields))
+    for _, f := range ce.Fields { // every key
+        m := fmt.Sprintf("%v: %v", f.Field, f.Message)
+        messages = append(messages, m) // collect
+    } // all the messages have been collected
+    section := ce.Section // could be the root
+    if section == "" { section = "<root>" }
+    const format = "invalid config section %v: %v"
+    return fmt.Sprintf(format, section, strings.Join(messages, "; "))
+}
+
+// Decode the config section at the specified dotted path into the
+// destination, which must be a pointer to a Go struct. Fields match
+// keys by the config tag, such as `config:"port-number,required"`, or
+// by the kebab-cased field name otherwise. Tag default supplies the
+// value for the absent keys, as TOML text; such as `default:"5s"`.
+// Durations are parsed from strings, and integers could be given as
+// sizes, such as "512MB"; tables decode into structs and maps, and the
+// arrays of tables decode into slices of structs. Values are checked
+// against the validate tag rules; see the Endpoint.Input for these.
+// Decodes from the current config; see the App.CurrentConfig method.
+// Returns a ConfigError listing all the offending keys, if any.
+func (app *App) DecodeConfig(section string, destination interface {}) error {
+    return decodeConfig(app.CurrentConfig(), section, destination)
+}
+
+// Decode the config section at the specified dotted path of the tree
+// into the destination. This is the implementation of DecodeConfig,
+// that could be used against any config tree, not just the one that
+// the application currently has; for example, to validate the config
+// that is being reloaded, before the application is switched to it.
+func decodeConfig(tree *toml.TomlTree, section string, destination interface {}) error {
+    const enotptr = "config destination must be a pointer to struct"
+    value := reflect.ValueOf(destination) // reflect
+    if value.Kind() != reflect.Ptr || value.IsNil() ||
+        value.Elem().Kind() != reflect.Struct {
+        panic(enotptr) // programming error
+    } // destination is a pointer to a struct
+    failure := &ConfigError { Section: section }
+    if section != "" && tree != nil { // sub
+        switch node := tree.Get(section).(type) {
+            case nil: tree = nil // absent, use defaults
+            case *toml.TomlTree: tree = node // table
+            default: failure.Fields = append(failure.Fields,
+                FieldError { section, "must be a table" })
+                return failure // cannot decode further
+        } // located the section to decode
+    } // decode the located section, if any
+    decodeTable(tree, value.Elem(), section, failure)
+    if len(failure.Fields) > 0 { return failure }
+    return nil // all keys have been decoded
+}
+
+// Walk the fields of the struct value and decode the config keys into
+// them, recursing into the embedded structs. Errors are collected into
+// the supplied config error, rather than returned, so that all of the
+// offending keys are reported at once. The tree could be nil, meaning
+// that the table is absent; in which case only defaults are applied.
+func decodeTable(tree *toml.TomlTree, value reflect.Value, path string, failure *ConfigError) {
+    kind := value.Type() // type of the struct
+    for i := 0; i < kind.NumField(); i++ {
+        field := kind.Field(i) // definition
+        if field.PkgPath != "" && !field.Anonymous { continue }
+        if field.Anonymous && field.Type.Kind() == reflect.Struct {
+            decodeTable(tree, value.Field(i), path, failure)
+            continue // embedded struct walked
+        } // it is a regular, exported struct field
+        name, required := configTag(field) // key
+        if name == "" { continue } // not decodable
+        full := strings.TrimPrefix(path + "." + name, ".")
+        var raw interface {} = nil // absent by default
+        if tree != nil { raw = tree.GetPath([]string { name }) }
+        if text, ok := field.Tag.Lookup("default"); ok && raw == nil {
+            raw, _ = coerceConfig(nil, text) // as TOML
+        } // default value is used, if key is absent
+        if raw == nil && required { // it is mandatory
+            failure.Fields = append(failure.Fields,
+                FieldError { full, "is required" })
+        } // required config key is missing
+        if raw == nil { continue } // leave as is
+        err := decodeValue(raw, value.Field(i), full, failure)
+        if err == nil { err = checkRules(field, value.Field(i)) }
+        if err != nil { // malformed or violates rules
+            failure.Fields = append(failure.Fields,
+                FieldError { full, err.Error() })
+        } // config key is malformed for the field
+    } // all the fields have been walked through
+}
+
+// Obtain the config key that the struct field should be decoded from,
+// and whether that key is required. The key is taken from the config
+// tag, or derived from the field name by kebab-casing it; for example
+// PortNumber becomes port-number. An empty key is returned for fields
+// that should not be decoded; that is, explicitly skipped with "-".
+func configTag(field reflect.StructField) (string, bool) {
+    parts := strings.Split(field.Tag.Get("config"), ",")
+    name := strings.TrimSpace(parts[0]) // the key
+    if name == "-" { return "", false } // skip
+    if name == "" { name = kebab(field.Name) }
+    required := false // is the key mandatory?
+    for _, o := range parts[1:] { required = required ||
+        strings.TrimSpace(o) == "required" }
+    return name, required // as declared
+}
+
+// Convert the Go identifier in camel case into the kebab case, which
+// is the conventional style of the config keys; for example, HTTPPort
+// becomes http-port and PortNumber becomes port-number. Acronyms are
+// kept together, as long as they are followed by the next word that
+// starts with an upper case letter followed by a lower case one.
+func kebab(identifier string) string {
+    runes := []rune(identifier) // by characters
+    var builder strings.Builder // output buffer
+    for i, r := range runes { // walk characters
+        if i > 0 && unicode.IsUpper(r) { // boundary?
+            previous := runes[i - 1] // before this one
+            lower := !unicode.IsUpper(previous) // aB
+            next := i + 1 < len(runes) && unicode.IsLower(runes[i + 1])
+            if lower || next { builder.WriteRune('-') }
+        } // word boundary has been marked, if any
+        builder.WriteRune(unicode.ToLower(r))
+    } // all the characters have been converted
+    return builder.String()
+}
+
+// Decode the raw config value into the field, converting it to the
+// type of the field. Pointers are allocated; tables are decoded into
+// structs and maps; arrays into slices. Integers accept size strings,
+// such as "512MB"; durations accept strings, such as "5s". Errors of
+// the nested values are recorded into the failure with their paths.
+func decodeValue(raw interface {}, field reflect.Value, path string, failure *ConfigError) error {
+    if field.Kind() == reflect.Ptr { // allocate it
+        target := reflect.New(field.Type().Elem())
+        err := decodeValue(raw, target.Elem(), path, failure)
+        if err == nil { field.Set(target) } // got it
+        return err // pointee has been decoded
+    } // the field is not a pointer, decode it
+    if field.Type() == reflect.TypeOf(time.Duration(0)) {
+        text, ok := raw.(string) // as in "5s"
+        if !ok { return errors.New("must be a duration string") }
+        d, err := time.ParseDuration(text) // parse
+        if err != nil { return errors.New("must be a duration") }
+        field.SetInt(int64(d)); return nil // done
+    } // the field is not a duration, go by kind
+    switch field.Kind() { // convert to the kind
+        case reflect.String: // strings only
+            text, ok := raw.(string) // verbatim
+            if !ok { return errors.New("must be a string") }
+            field.SetString(text) // assign the string
+        case reflect.Bool: // booleans only
+            b, ok := raw.(bool) // true or false
+            if !ok { return errors.New("must be a boolean") }
+            field.SetBool(b) // assign the boolean
+        case reflect.Int, reflect.Int8, reflect.Int16,
+            reflect.Int32, reflect.Int64: // signed
+            n, err := configInteger(raw) // or size
+            if err != nil { return err } // malformed
+            if field.OverflowInt(n) { return errors.New("is out of range") }
+            field.SetInt(n) // assign the integer
+        case reflect.Uint, reflect.Uint8, reflect.Uint16,
+            reflect.Uint32, reflect.Uint64: // unsigned
+            n, err := configInteger(raw) // or size
+            if err != nil { return err } // malformed
+            if n < 0 || field.OverflowUint(uint64(n)) {
+                return errors.New("is out of range")
+            } // the number fits into the field type
+            field.SetUint(uint64(n)) // assign it
+        case reflect.Float32, reflect.Float64: // real
+            switch n := raw.(type) { // int or float
+                case float64: field.SetFloat(n)
+                case int64: field.SetFloat(float64(n))
+                default: return errors.New("must be a number")
+            } // the number has been assigned
+        case reflect.Struct: // decode the table
+            table, ok := raw.(*toml.TomlTree) // table
+            if !ok { return errors.New("must be a table") }
+            decodeTable(table, field, path, failure)
+        case reflect.Map: // table with arbitrary keys
+            table, ok := raw.(*toml.TomlTree) // table
+            if !ok { return errors.New("must be a table") }
+            if field.Type().Key().Kind() != reflect.String {
+                return errors.New("must have string keys")
+            } // map is keyed by strings, decode values
+            result := reflect.MakeMap(field.Type()) // new
+            for _, key := range table.Keys() { // walk all
+                item := reflect.New(field.Type().Elem()).Elem()
+                full := path + "." + key // nested path
+                v := table.GetPath([]string { key }) // raw
+                if err := decodeValue(v, item, full, failure); err != nil {
+                    failure.Fields = append(failure.Fields,
+                        FieldError { full, err.Error() })
+                } // item is malformed, recorded it
+                result.SetMapIndex(reflect.ValueOf(key), item)
+            } // all the items have been decoded
+            field.Set(result) // assign the map
+        case reflect.Slice: // array or array of tables
+            items := reflect.ValueOf(raw) // of any type
+            if k := items.Kind(); k != reflect.Slice {
+                return errors.New("must be an array")
+            } // the raw value is some sort of array
+            result := reflect.MakeSlice(field.Type(), items.Len(), items.Len())
+            for i := 0; i < items.Len(); i++ { // walk all
+                full := path + "." + strconv.Itoa(i) // path
+                v := items.Index(i).Interface() // raw item
+                if err := decodeValue(v, result.Index(i), full, failure); err != nil {
+                    failure.Fields = append(failure.Fields,
+                        FieldError { full, err.Error() })
+                } // item is malformed, recorded it
+            } // all the items have been decoded
+            field.Set(result) // assign the slice
+        default: return fmt.Errorf("unsupported type %v", field.Type())
+    } // value has been converted and assigned
+    return nil // field has been decoded OK
+}
+
+// Convert the raw config value into an integer number. It could be an
+// integer, as is; or a string with the size, such as "512MB" or "1GiB",
+// which is parsed with parseSize function. This allows to express the
+// sizes in config in a human readable way, while still decoding them
+// into the plain integer fields. Returns an error if malformed.
+func configInteger(raw interface {}) (int64, error) {
+    const einteger = "must be an integer or a size"
+    switch n := raw.(type) { // int or size
+        case int64: return n, nil // plain integer
+        case string: // size with optional suffix
+            size, err := parseSize(n) // parse it
+            if err != nil || size > 1 << 63 - 1 {
+                return 0, errors.New(einteger)
+            } // size fits into the signed integer
+            return int64(size), nil // parsed size
+        default: return 0, errors.New(einteger)
+    }
+}
+
+// Check the config declared by a provider or a service, when it is
+// being defined. The config is optional; but if it is declared, then it
+// must be a pointer to the struct and the section must be specified.
+// Panics otherwise, since this is a programming error that should be
+// caught early on, rather than when the application is being booted.
+func checkConfigTarget(section string, config interface {}) {
+    const esection = "missing section of the declared config"
+    const enotptr = "config must be a pointer to struct"
+    if config == nil { return } // not declared
+    value := reflect.ValueOf(config) // reflect
+    if value.Kind() != reflect.Ptr || value.IsNil() ||
+        value.Elem().Kind() != reflect.Struct {
+        panic(enotptr) // programming error
+    } // config is a pointer to a struct indeed
+    if len(section) == 0 { panic(esection) }
+}
+
+// Decode the config sections declared by the providers and services
+// that are available in the current env, as well as the app servers,
+// from the supplied config tree; and collect all the errors at once.
+// Sections are decoded into fresh copies of the initial values of the
+// declared structs, so nothing is modified until the returned commit
+// function is invoked. Used by Boot and Reload to validate the config.
+// Commit decodes the structs in place at boot, when nothing is reading
+// them yet; and only publishes the fresh copies atomically on reload.
+func (app *App) checkConfig(tree *toml.TomlTree) (func(bool), []error) {
+    var failures []error = nil // all the errors
+    var decoded []func(bool) = nil // the copies
+    declared := func(section string, config interface {}, published *atomic.Value) {
+        if config == nil { return } // not declared
+        target := reflect.ValueOf(config).Elem()
+        fresh := reflect.New(target.Type()) // copy
+        fresh.Elem().Set(app.initialConfig(config))
+        err := decodeConfig(tree, section, fresh.Interface())
+        if err != nil { failures = append(failures, err); return }
+        decoded = append(decoded, func(boot bool) {
+            if !boot { published.Store(fresh.Interface()); return }
+            target.Set(fresh.Elem()); published.Store(config)
+        }) // in place at boot, or publish the copy
+    } // closure that decodes the declared config
+    for _, p := range app.Providers { // providers
+        if !p.Available[app.Env] { continue } // N/A
+        declared(p.ConfigSection, p.Config, &p.published)
+    } // all the provider sections are decoded
+    for _, s := range app.Services { // services
+        if !s.Available[app.Env] { continue } // N/A
+        declared(s.ConfigSection, s.Config, &s.published)
+    } // all the service sections are decoded
+    if tree.Has("app.servers") { // declared?
+        _, err := serverConfigs(tree) // decode
+        if err != nil { failures = append(failures, err) }
+    } // app servers section is decoded as well
+    return func(boot bool) { // assign decoded copies
+        for _, assign := range decoded { assign(boot) }
+    }, failures // commit function and the errors
+}
+
+// Obtain the initial value of the declared config struct, as it was
+// before the config has been decoded into it for the first time. Every
+// decoding starts from this value, so that keys removed from the config
+// on reload get back to their initial values, rather than keeping the
+// stale ones. The value is captured the first time it is requested.
+func (app *App) initialConfig(config interface {}) reflect.Value {
+    app.Lock(); defer app.Unlock() // guard it
+    if app.initial == nil { // allocate it lazily
+        app.initial = make(map[interface {}] reflect.Value)
+    } // map of the initial values is available
+    if v, ok := app.initial[config]; ok { return v }
+    current := reflect.ValueOf(config).Elem()
+    v := reflect.New(current.Type()).Elem()
+    v.Set(current); app.initial[config] = v
+    return v // captured the initial value
+}
+
+// Decode the config sections declared by the providers and services
+// that are available in the current env, as well as the app servers;
+// and report all the errors at once. This is invoked by the Boot, to
+// validate the config up front, before anything is set up or deployed.
+// Panics with the joined errors, if any of the sections are invalid.
+func (app *App) validateConfig() {
+    commit, failures := app.checkConfig(app.Config)
+    if len(failures) == 0 { commit(true); return }
+    for _, err := range failures { app.Journal.Error(err) }
+    panic(errors.Join(failures...)) // one report
+}
+
+// Declaration of the application server, as it is decoded from the
+// app.servers.http and app.servers.https arrays of tables within the
+// config. The certificate and the key are only used by HTTPS servers,
+// for which they are required. See the unfoldHttpServers and also the
+// unfoldHttpsServers methods for how these are used to spawn servers.
+type serverConfig struct {
+    Intent string `config:"intent,required"`
+    Hostname string `config:"hostname,required"`
+    PortNumber int64 `config:"port-number,required" validate:"min=0,max=65535"`
+    Cert string `config:"cert"` // HTTPS only
+    Key string `config:"key"` // HTTPS only
+}
+
+// Decode the declarations of all the application servers, both HTTP
+// and HTTPS, from the app.servers config section. Certificate and the
+// key are checked to be present for every HTTPS server. Returns the
+// ConfigError listing all the offending keys, if any of them are off.
+// Please see the serverConfig struct for details on the fields.
+func serverConfigs(tree *toml.TomlTree) (map[string] []serverConfig, error) {
+    var servers struct { // both kinds of servers
+        HTTP []serverConfig `config:"http"` // plain
+        HTTPS []serverConfig `config:"https"` // TLS
+    } // declarations of the app servers, by kind
+    err := decodeConfig(tree, "app.servers", &servers)
+    failure, _ := err.(*ConfigError) // or nil
+    if err != nil && failure == nil { return nil, err }
+    if failure == nil { failure = &ConfigError { Section: "app.servers" } }
+    for i, s := range servers.HTTPS { // TLS material
+        path := fmt.Sprintf("app.servers.https.%d.", i)
+        if s.Cert == "" { failure.Fields = append(failure.Fields,
+            FieldError { path + "cert", "is required" }) }
+        if s.Key == "" { failure.Fields = append(failure.Fields,
+            FieldError { path + "key", "is required" }) }
+    } // certificate and key are checked for all
+    if len(failure.Fields) > 0 { return nil, failure }
+    return map[string] []serverConfig { // by kind
+        "http": servers.HTTP, "https": servers.HTTPS,
+    }, nil // declarations are decoded
+}
@@ -24,6 +24,10 @@
 package boot
 
 import "time"
+import "regexp"
+import "fmt"
+
+import "github.com/robfig/cron"
 
 // Create and mount a new endpoint into the current service. Method
 // takes the origin function that will take the endpoint instance and
@@ -40,19 +44,92 @@ func (srv *Service) Endpoint(origin func(*Endpoint)) *Endpoint {
     var endpoint *Endpoint = &Endpoint {} // allocate
     endpoint.Methods = make(map[string] bool) // HTTP
     endpoint.Timeout = time.Second * 3 // default!
+    endpoint.SourceLocation = locate(0) // caller
     origin(endpoint) // endpoint is made right here
+    endpoint.implement(endpoint.Business) // name
     if len(endpoint.Methods) == 0 { // no methods?
         endpoint.Methods["GET"] = true
     } // ensure at least one env is in the map
     if len(endpoint.Pattern) == 0 { // empty URL
         panic("missing URL pattern for endpoint")
     } // looks like endpoint was properly assembled
+    endpoint.inputFields() // panics if malformed
     srv.Lock() // accquire mutex lock on the app
     srv.Endpoints = append(srv.Endpoints, endpoint)
     srv.Unlock() // release the accquired mutex
     return endpoint // is ready for usage
 }
 
+// Create and mount a new aux operation into the current service. It
+// takes the origin function that will take the aux instance and then
+// properly set it up. An aux instance itself will be allocated by this
+// method and automatically mounted within the service, by its handle.
+// The handle, timeout and an optional CRON expression are validated.
+func (srv *Service) Aux(origin func(*Aux)) *Aux {
+    const ehandle = "aux handle is not of correct format"
+    const etimeout = "aux timeout must be a positive duration"
+    const eexists = "aux with the same handle already exists"
+    const ecron = "invalid aux CRON expression: %v"
+    pattern := regexp.MustCompile("^[a-zA-Z0-9-_]+$")
+    if !srv.Erected.IsZero() { // service is up?
+        panic("refusing to modify erected service")
+    } // service is not yet up; we are good to go
+    if origin == nil { // origin points to nowhere?
+        panic("missing the aux origin function")
+    } // origin is intact, we shall invoke it later
+    var aux *Aux = &Aux {} // allocate aux operation
+    aux.Timeout = time.Second * 3 // default!
+    aux.SourceLocation = locate(0) // the caller
+    origin(aux) // aux op is made right here
+    aux.implement(aux.Business) // resolve name
+    if !pattern.MatchString(aux.Handle) { panic(ehandle) }
+    if aux.Timeout <= 0 { panic(etimeout) } // sanity
+    if aux.Business == nil { // no business logic?
+        panic("missing business logic for aux")
+    } // looks like aux was properly assembled
+    if ce := aux.CronExpression; len(ce) > 0 {
+        _, err := cron.Parse(ce) // try parsing it
+        if err != nil { panic(fmt.Errorf(ecron, err)) }
+    } // CRON expression, if any, is valid
+    srv.Lock() // accquire mutex lock on the app
+    defer srv.Unlock() // release the accquired mutex
+    if srv.Auxes[aux.Handle] != nil { panic(eexists) }
+    srv.Auxes[aux.Handle] = aux // mount the aux op
+    return aux // is ready for usage
+}
+
+// Create and install a new provider into the current app. Method
+// takes the origin function that will take the provider instance and
+// properly set it up. The provider will be allocated by this method
+// and automatically installed within the application. The provider
+// must have a description, at least one environment and setup logic.
+func (app *App) Provider(origin func(*Provider)) *Provider {
+    const ename = "provider name is not of correct format"
+    const eabout = "missing description of the provider"
+    const eavail = "provider is not available in any env"
+    const esetup = "missing setup function of the provider"
+    pattern := regexp.MustCompile("^[a-zA-Z0-9-_]+$")
+    if !app.Booted.IsZero() { // app is booted?
+        panic("refusing to modify the booted app")
+    } // app is not yet booted; we are good to go
+    if origin == nil { // origin points to nowhere?
+        panic("missing the provider origin function")
+    } // origin is intact, we shall invoke it later
+    var provider *Provider = &Provider {} // alloc
+    provider.Available = make(map[string] bool)
+    provider.Depends = make([]string, 0) // none
+    origin(provider) // provider is made right here
+    if !pattern.MatchString(provider.Name) { panic(ename) }
+    if len(provider.About) == 0 { panic(eabout) }
+    if len(provider.Available) == 0 { panic(eavail) }
+    if provider.Setup == nil { panic(esetup) }
+    checkConfigTarget(provider.ConfigSection, provider.Config)
+    app.Lock() // accquire mutex lock on the app
+    app.Providers = append(app.Providers, provider)
+    app.Unlock() // release the accquired mutex
+    return provider // is ready for usage
+}
+
 // Create and install a new service into the current app. Method
 // takes the origin function that will take the service instance and
 // properly set it up. The service instance itself will be allocated by
@@ -70,6 +147,7 @@ func (app *App) Service(origin func(*Service)) *Service {
     service.Available = make(map[string] bool)
     service.Storage = Storage { Container: room }
     service.Auxes = make(map[string] *Aux)
+    service.SourceLocation = locate(0) // caller
     origin(service) // service is made right here
     if len(service.Available) == 0 { // no envs?
         service.Available[app.Env] = true
@@ -77,6 +155,7 @@ func (app *App) Service(origin func(*Service)) *Service {
     if len(service.Prefix) == 0 { // empty prefix
         panic("missing mandatory service prefix")
     } // looks like service was properly assembled
+    checkConfigTarget(service.ConfigSection, service.Config)
     app.Lock() // accquire mutex lock on the app
     app.Services = append(app.Services, service)
     app.Unlock() // release the accquired mutex
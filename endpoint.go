@@ -24,7 +24,7 @@
 package boot
 
 import "time"
-import "fmt"
+import "errors"
 
 // Implementation of the Operation interface; execute business logic
 // that is stored within an endpoint, in regards to supplied context
@@ -33,36 +33,37 @@ import "fmt"
 // asynchronous behavior intended - the caller must ensure that this
 // method syncrhonizes on the asynchronous code to return onces done.
 func (ep *Endpoint) Apply(context *Context) error {
-    timer := time.After(ep.Timeout) // ticker
-    value := make(chan interface {}, 1) // panic
-    const einv = "undetermined endpoint panic %v"
     if e := ep.Satisfied(context); e != nil {
         elog := context.Journal.WithError(e)
         elog = elog.WithField("operation", ep)
+        elog = elog.WithField("source", ep.Definition())
         elog.Warn("endpoint is not available")
         return OperationUnavailable // is N/A
     } // operation assured to be available
-    go func() { // wrap as asynchronous code
-        defer func() { value <- recover() }()
-        ep.Business(context) // run the BL!
-    }() // spin off go-routine to execute it
-    select { // wait for either of 2 channels
-        case <- timer: return OperationTimeout
-        case x := <- value: switch e := x.(type) {
-            case error: return e // regular panic
-            case nil: return nil // executed OK
-            // operation paniced with non-error
-            default: return fmt.Errorf(einv, e)
-        }
-    }
+    if e := ep.validate(context); e != nil {
+        return e // input is rejected, see Input
+    } // input is valid, bound into the storage
+    return context.execute(ep, ep.Timeout, ep.Business)
 }
 
 // Check whether the operation is satisfied with supplied context.
 // If not - then it is safe to assume that the operation will not
 // be available, and its application with yield the corresponding
-// error. The exact logic behind this check is determined by the
-// implementation. Must return some error value is not satisfied.
-func (ep *Endpoint) Satisfied(*Context) error { return nil }
+// error. Endpoint requires the token claims to grant all its scopes
+// and at least one of its roles, if any; see Authenticate middleware.
+func (ep *Endpoint) Satisfied(context *Context) error {
+    const eanonymous = "endpoint requires an authenticated user"
+    if len(ep.Scopes) == 0 && len(ep.Roles) == 0 {
+        return nil // no access control for endpoint
+    } // endpoint is access controlled; check claims
+    claims := context.Claims() // verified claims
+    if claims == nil { // no token or not verified?
+        issue, _ := Load[error](&context.Storage, ClaimsIssueKey)
+        if issue != nil { return issue } // the reason
+        return errors.New(eanonymous) // no token
+    } // got the claims, check the requirements
+    return claims.satisfy(ep.Scopes, ep.Roles)
+}
 
 // Fetch prologue & epilogue code (middleware): these are required
 // to be run within context prior to running the operation itself.
@@ -124,9 +125,23 @@ type Endpoint struct {
     // considered timed out. If the operation application times out, a
     // caller will be notified of this by returning the special value to
     // it and of course unblocking the call stack. The go-routine that
-    // was used to invoke the operation will continue to spin though.
+    // runs the operation will have its standard context cancelled.
     Timeout time.Duration
 
+    // Scopes that must all be granted by the JSON Web Token that the
+    // request carries, in order to access this endpoint. When set, the
+    // endpoint should have the Authenticate middleware in front of it,
+    // either directly or inherited from the service. If the scopes are
+    // not granted, the endpoint will be reported as unavailable.
+    Scopes []string
+
+    // Roles, any one of which must be granted by the JSON Web Token
+    // that the request carries, in order to access this endpoint. When
+    // set, the endpoint should have the Authenticate middleware in front
+    // of it, either directly or inherited from the service. If no role
+    // is granted, the endpoint will be reported as unavailable.
+    Roles []string
+
     // Implementation of the endpoint. Should be BiasedLogic typed
     // function that implements the business logic this endpoint is
     // representing. It is invoked to handle an HTTP request matched
@@ -134,6 +149,27 @@ type Endpoint struct {
     // passed to the function. See BiasedLogic type info for info.
     Business BiasedLogic
 
+    // Human readable description of what this endpoint does. It is not
+    // used by the framework to do anything, except exposing it in the
+    // inventory and documentation of the application. It is advised
+    // to keep it short, yet descriptive enough for an API consumer to
+    // understand the purpose of it without looking into the code.
+    Description string
+
+    // Optional description of the input that this endpoint expects. It
+    // could be anything that is serializable as JSON, such as the JSON
+    // Schema document. It is not interpreted by the framework in any
+    // way, and merely exposed in the inventory & docs of the app. See
+    // the App.Inventory method for more details on the inventory.
+    Schema interface {}
+
+    // Optional declaration of the input that this endpoint expects, as
+    // a Go struct (or a pointer to one) with bind and validate tags on
+    // its fields. Before the business logic runs, the request params
+    // are bound into a fresh instance, which is then stored under the
+    // InputKey; invalid input is rejected with the field errors.
+    Input interface {}
+
     // Store source location of where the definition of this endpoint
     // is implemented. This information may not always be available. It
     // will be accordingly reflected in the return struct in this case.
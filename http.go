@@ -24,14 +24,15 @@
 package boot
 
 import "strings"
+import "sort"
 import "net/http"
 import "time"
 import "fmt"
 
 import stdlog "log"
+import stdctx "context"
 
 import "github.com/renstrom/shortuuid"
-import "github.com/pelletier/go-toml"
 import "github.com/Sirupsen/logrus"
 import "github.com/naoina/denco"
 
@@ -44,8 +45,13 @@ import "github.com/naoina/denco"
 func (app *App) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
     context := &Context { App: app, Request: r }
     context.Created = time.Now() // mark an instant
-    context.ResponseWriter = rw // embed responder
+    writer := &responder { ResponseWriter: rw }
+    context.ResponseWriter = writer // tracks status
     context.Reference = shortuuid.New() // V4
+    scope, cancel := stdctx.WithCancel(r.Context())
+    defer cancel() // request has been handled
+    defer stdctx.AfterFunc(app.lifetime, cancel)()
+    context.Context = scope // client or app gone
     log := app.Journal.WithFields(logrus.Fields {
         "ref": context.Reference, // a short UUID
         "url": r.RequestURI, // the URL requested
@@ -53,26 +59,55 @@ func (app *App) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
         "ip": r.RemoteAddr, // remote host & port
     }) // the logger is compiled and ready for use
     log.Info("accepted an incoming HTTP request")
-    if app.routers[r.Method] == nil { // allowed?
+    context.Journal = log // structured logger
+    defer func() { // whichever way it has ended
+        log.WithFields(logrus.Fields {
+            "status": writer.Status(), // or zero
+            "size": writer.Size(), // body bytes
+            "elapsed": time.Since(context.Created),
+        }).Info("finish accepted HTTP request")
+        app.recordAccess(context, writer) // if any
+    }() // outcome of the request gets journaled
+    context.Data = make(map[string] string)
+    var rec interface {}; var ps denco.Params
+    var hit bool = false // did request match?
+    if router := app.routers[r.Method]; router != nil {
+        rec, ps, hit = router.Lookup(r.URL.Path)
+    } // looked up the route for the HTTP method
+    if !hit && len(app.allowedMethods(r.URL.Path)) > 0 {
         log.Warn("request method is not allowed")
         app.Supervisor.MethodNotAllowed(context)
         return // we are done with this request
     } // ok, looks like request method fits in
-    router := app.routers[r.Method] // accquire
-    rec, ps, hit := router.Lookup(r.RequestURI)
     if !hit { // request did not match any endpoint
         log.Warn("request did not match any route")
         app.Supervisor.EndpointNotFound(context)
         return // we are done with this request
     } // ok, looks like request match an endpoint
     var pipe *Pipeline = rec.(*Pipeline) // cast
-    context.Data = make(map[string] string)
     context.Service = pipe.Service // restore
-    context.Journal = log // structured logger
-    d := context.Data // for convenient access
-    for _,p := range ps { d[p.Name] = p.Value }
+    if err := app.collectData(context, ps); err != nil {
+        bs, ok := app.Supervisor.(BodySupervisor)
+        if !ok { bs = &Watchdog {} } // fallback
+        bs.BodyRejected(context, err) // 413 or 400
+        return // we are done with this request
+    } // input parameters have been merged in
     pipe.Run(context) // fire up the pipeline
-    log.Info("finish accepted HTTP request")
+}
+
+// Find all the HTTP methods that the specified path could be routed
+// with, to any of the endpoints mounted within the application. This
+// is used to determine whether a request that did not match has been
+// made with a wrong method, and to advertise the allowed methods to
+// the client. Methods are returned sorted in lexicographical order.
+func (app *App) allowedMethods(path string) []string {
+    var methods = make([]string, 0) // allowed
+    for method, router := range app.routers {
+        _, _, hit := router.Lookup(path) // match?
+        if hit { methods = append(methods, method) }
+    } // all the routers have been checked out
+    sort.Strings(methods) // have a stable order
+    return methods // could be an empty slice
 }
 
 // Given the map of HTTP methods to a vector of routables that may
@@ -83,8 +118,7 @@ func (app *App) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 func (app *App) collectRecords(records map[string] []denco.Record) {
     for _, srv := range app.Services {
         for _, ep := range srv.Endpoints {
-            epp := strings.TrimPrefix(ep.Pattern, "/")
-            mask := fmt.Sprintf("%v/%v", srv.Prefix, epp)
+            var mask string = srv.mountPoint(ep)
             pipe := &Pipeline {Operation: ep, Service: srv}
             pipe.Compile(app) // seal up pipeline instance
             log := app.Journal.WithField("url", mask)
@@ -98,6 +132,16 @@ func (app *App) collectRecords(records map[string] []denco.Record) {
     } // finish up with collecting the records
 }
 
+// Compute the URL pattern, under which the specified endpoint is to
+// be mounted into the HTTP request routers. It is a combination of the
+// service prefix and the endpoint pattern, joined with a slash. This
+// is exactly the pattern that is matched against the requests URLs.
+// Please refer to the collectRecords method for usage details.
+func (srv *Service) mountPoint(ep *Endpoint) string {
+    epp := strings.TrimPrefix(ep.Pattern, "/")
+    return fmt.Sprintf("%v/%v", srv.Prefix, epp)
+}
+
 // Create and configure an implementation of the HTTP request routers.
 // Will be used by the application to match incoming requests against
 // the endpoints that are meant to handle those requests. Current way
@@ -132,20 +176,24 @@ func (app *App) unfoldHttpsServers() {
     writer := app.Journal.Writer() // log writer
     log := app.Journal.WithField("proto", "HTTPS")
     const eempty = "no HTTPS app servers in a config"
-    sections := app.Config.Get("app.servers.https")
-    servers, ok := sections.([]*toml.TomlTree)
-    if !ok { panic("invalid app.servers.https") }
+    tree := app.CurrentConfig() // as it is now
+    declared, err := serverConfigs(tree) // decode
+    if err != nil { panic(err) } // malformed config
+    if !tree.Has("app.servers.https") {
+        panic("invalid app.servers.https") // absent
+    } // section is there, check if it has servers
+    servers := declared["https"] // of this kind
     if len(servers) == 0 { panic(eempty) }
     for _, config := range servers {
-        key := config.Get("key").(string)
-        cert := config.Get("cert").(string)
-        intent := config.Get("intent").(string)
-        host := config.Get("hostname").(string)
-        port := config.Get("port-number").(int64)
+        key, cert := config.Key, config.Cert // TLS
+        intent := config.Intent // server identity
+        host, port := config.Hostname, config.PortNumber
         server := &http.Server { Handler: app }
         server.Addr = fmt.Sprintf("%v:%d", host, port)
         server.ErrorLog = stdlog.New(writer, "", 0)
+        server.ConnState = app.stats.connState(intent)
         app.Servers[intent] = server // store server
+        listener := app.listen(intent, server.Addr)
         app.finish.Add(1) // wait for one server
         go func() { // do not block on listening
             log = log.WithField("bind", server.Addr)
@@ -153,7 +201,9 @@ func (app *App) unfoldHttpsServers() {
             log.Info("spawn application server")
             defer app.finish.Done() // clean up
             defer writer.Close() // close writer
-            panic(server.ListenAndServeTLS(cert, key))
+            err := server.ServeTLS(listener, cert, key) // blocks
+            if err != http.ErrServerClosed { panic(err) }
+            log.Info("application server has stopped")
         }()
     }
 }
@@ -167,18 +217,23 @@ func (app *App) unfoldHttpServers() {
     writer := app.Journal.Writer() // log writer
     log := app.Journal.WithField("proto", "HTTP")
     const eempty = "no HTTP app servers in a config"
-    sections := app.Config.Get("app.servers.http")
-    servers, ok := sections.([]*toml.TomlTree)
-    if !ok { panic("invalid app.servers.http") }
+    tree := app.CurrentConfig() // as it is now
+    declared, err := serverConfigs(tree) // decode
+    if err != nil { panic(err) } // malformed config
+    if !tree.Has("app.servers.http") {
+        panic("invalid app.servers.http") // absent
+    } // section is there, check if it has servers
+    servers := declared["http"] // of this kind
     if len(servers) == 0 { panic(eempty) }
     for _, config := range servers {
-        intent := config.Get("intent").(string)
-        host := config.Get("hostname").(string)
-        port := config.Get("port-number").(int64)
+        intent := config.Intent // server identity
+        host, port := config.Hostname, config.PortNumber
         server := &http.Server { Handler: app }
         server.Addr = fmt.Sprintf("%v:%d", host, port)
         server.ErrorLog = stdlog.New(writer, "", 0)
+        server.ConnState = app.stats.connState(intent)
         app.Servers[intent] = server // store server
+        listener := app.listen(intent, server.Addr)
         app.finish.Add(1) // wait for one server
         go func() { // do not block on listening
             log = log.WithField("bind", server.Addr)
@@ -186,7 +241,9 @@ func (app *App) unfoldHttpServers() {
             log.Info("spawn application server")
             defer app.finish.Done() // clean up
             defer writer.Close() // close writer
-            panic(server.ListenAndServe())
+            err := server.Serve(listener) // blocks
+            if err != http.ErrServerClosed { panic(err) }
+            log.Info("application server has stopped")
         }()
     }
 }
@@ -0,0 +1,270 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "sort"
+import "encoding/json"
+import "html/template"
+
+// Build up the inventory of the application: a description of every
+// service installed within the app, along with its endpoints and aux
+// operations. Inventory is a plain data structure, that is suitable
+// for serializing as JSON, so it could be exposed to API consumers.
+// See the MountInventory method to expose the inventory over HTTP.
+func (app *App) Inventory() *Inventory {
+    inventory := &Inventory { Name: app.Name }
+    inventory.Version = app.Version.String()
+    inventory.Env = app.Env // current environment
+    inventory.Services = make([]ServiceRecord, 0)
+    for _, srv := range app.Services { // walk all
+        record := ServiceRecord { Prefix: srv.Prefix }
+        record.Source = srv.SourceLocation // code
+        record.Available = srv.Available[app.Env]
+        record.Environments = make([]string, 0)
+        for env, ok := range srv.Available { // envs
+            if ok { record.Environments = append(record.Environments, env) }
+        } // collected all the environments
+        sort.Strings(record.Environments) // stable
+        record.Middleware = len(srv.Middleware) // own
+        record.Endpoints = make([]EndpointRecord, 0)
+        record.Auxes = make([]AuxRecord, 0) // empty
+        for _, ep := range srv.Endpoints { // walk
+            record.Endpoints = append(record.Endpoints,
+                srv.endpointRecord(ep)) // describe
+        } // all the endpoints are described
+        handles := make([]string, 0) // aux handles
+        for handle := range srv.Auxes { handles = append(handles, handle) }
+        sort.Strings(handles) // have a stable order
+        for _, handle := range handles { // walk auxes
+            record.Auxes = append(record.Auxes,
+                srv.auxRecord(srv.Auxes[handle]))
+        } // all the aux operations are described
+        inventory.Services = append(inventory.Services, record)
+    } // all the services have been described
+    return inventory // inventory is ready to use
+}
+
+// Describe the endpoint that belongs to this service, as a record of
+// the application inventory. Middleware count includes the middleware
+// that is inherited from this service. The path is the exact pattern
+// that the endpoint is mounted under, within the HTTP request routers.
+// Please refer to the EndpointRecord for details on the fields.
+func (srv *Service) endpointRecord(ep *Endpoint) EndpointRecord {
+    record := EndpointRecord { Pattern: ep.Pattern }
+    record.Path = srv.mountPoint(ep) // as mounted
+    record.Methods = make([]string, 0) // HTTP verbs
+    for m, ok := range ep.Methods { // walk verbs
+        if ok { record.Methods = append(record.Methods, m) }
+    } // all the HTTP methods have been collected
+    sort.Strings(record.Methods) // stable order
+    record.Timeout = ep.Timeout.String() // human
+    inherited := len(srv.Middleware) // of service
+    record.Middleware = inherited + len(ep.Middleware)
+    record.Source = ep.Definition() // where is it
+    record.Description = ep.Description // as is
+    record.Schema = ep.Schema // user-supplied
+    record.Input = ep.inputFields() // declared
+    record.Scopes = ep.Scopes // access control
+    record.Roles = ep.Roles // access control
+    return record // endpoint is described
+}
+
+// Describe the aux operation that belongs to this service, as record
+// of the application inventory. Middleware count includes middleware
+// that is inherited from this service. Flags indicate whether the aux
+// is run on service up or down and the CRON expression, if periodic.
+// Please refer to the AuxRecord for details on the fields.
+func (srv *Service) auxRecord(aux *Aux) AuxRecord {
+    record := AuxRecord { Handle: aux.Handle }
+    record.Timeout = aux.Timeout.String() // human
+    inherited := len(srv.Middleware) // of service
+    record.Middleware = inherited + len(aux.Middleware)
+    record.CronExpression = aux.CronExpression // job
+    record.WhenUp = aux.WhenUp // run on service up
+    record.WhenDown = aux.WhenDown // and on down
+    record.Source = aux.Definition() // where is it
+    record.Description = aux.Description // as is
+    record.Schema = aux.Schema // user-supplied
+    return record // aux op is described
+}
+
+// Create and install a built-in service that exposes the inventory
+// of the application over HTTP, under the specified prefix. Service
+// has two endpoints: "json" serves the inventory as a JSON document
+// and "html" serves the same inventory rendered as an HTML page. The
+// service is returned, so it could be further configured if needed.
+func (app *App) MountInventory(prefix string) *Service {
+    return app.Service(func(srv *Service) {
+        srv.Prefix = prefix // mount point
+        srv.Endpoint(func(ep *Endpoint) {
+            ep.Pattern = "json" // JSON document
+            ep.Description = "inventory as JSON"
+            ep.Business = func(c *Context) {
+                const mime = "application/json"
+                c.Header().Set("Content-Type", mime)
+                encoder := json.NewEncoder(c) // writer
+                encoder.SetIndent("", "  ") // pretty
+                encoder.Encode(c.App.Inventory())
+            } // inventory has been served as JSON
+        }) // JSON endpoint has been mounted
+        srv.Endpoint(func(ep *Endpoint) {
+            ep.Pattern = "html" // rendered page
+            ep.Description = "inventory as HTML"
+            ep.Business = func(c *Context) {
+                const mime = "text/html; charset=utf-8"
+                c.Header().Set("Content-Type", mime)
+                inventoryPage.Execute(c, c.App.Inventory())
+            } // inventory has been served as HTML
+        }) // HTML endpoint has been mounted
+    })
+}
+
+// Inventory of the application; a description of all services that
+// are installed within the app, along with their endpoints and aux
+// operations. This is a plain data structure that is serializable to
+// JSON. It is built by the App.Inventory method, from the state of
+// the application at the moment of invocation. See fields for info.
+type Inventory struct {
+    Name string `json:"name"` // application name
+    Version string `json:"version"` // semver
+    Env string `json:"env"` // current environment
+    Services []ServiceRecord `json:"services"`
+}
+
+// Description of a service, as a record of application inventory.
+// Contains the prefix of the service, whether it is available in the
+// current environment and the environments it is available in, as
+// well as the descriptions of all its endpoints and aux operations.
+// Please refer to the App.Inventory method for more information.
+type ServiceRecord struct {
+    Prefix string `json:"prefix"` // mount point
+    Available bool `json:"available"` // in this env
+    Environments []string `json:"environments"`
+    Middleware int `json:"middleware"` // own count
+    Source SourceLocation `json:"source"` // code
+    Endpoints []EndpointRecord `json:"endpoints"`
+    Auxes []AuxRecord `json:"auxes"` // by handle
+}
+
+// Description of an endpoint, as a record of application inventory.
+// Contains the pattern and the path it is mounted under, the methods,
+// the timeout, the middleware count, the access control requirements,
+// the source location, the user-supplied description and schema, and
+// the declared input. Please see the App.Inventory method for info.
+type EndpointRecord struct {
+    Pattern string `json:"pattern"` // as declared
+    Path string `json:"path"` // as mounted in router
+    Methods []string `json:"methods"` // HTTP verbs
+    Timeout string `json:"timeout"` // human readable
+    Middleware int `json:"middleware"` // all layers
+    Scopes []string `json:"scopes,omitempty"` // JWT
+    Roles []string `json:"roles,omitempty"` // JWT
+    Source SourceLocation `json:"source"` // code
+    Description string `json:"description,omitempty"`
+    Schema interface {} `json:"schema,omitempty"`
+    Input []InputField `json:"input,omitempty"`
+}
+
+// Description of an aux op, as a record of application inventory.
+// Contains the handle, the timeout, the middleware count, the flags
+// that indicate when the aux is run, the CRON expression (if any), as
+// well as the source location and user-supplied description & schema.
+// Please refer to the App.Inventory method for more information.
+type AuxRecord struct {
+    Handle string `json:"handle"` // identification
+    Timeout string `json:"timeout"` // human readable
+    Middleware int `json:"middleware"` // all layers
+    CronExpression string `json:"cron,omitempty"`
+    WhenUp bool `json:"whenUp"` // run on service up
+    WhenDown bool `json:"whenDown"` // run on down
+    Source SourceLocation `json:"source"` // code
+    Description string `json:"description,omitempty"`
+    Schema interface {} `json:"schema,omitempty"`
+}
+
+// Template of the HTML page that renders the application inventory.
+// It is deliberately kept simple and self-contained, with no external
+// resources referenced, so it could be served from any environment.
+// The template is executed with the Inventory structure as its data.
+// See the MountInventory method for the usage of this template.
+var inventoryPage = template.Must(template.New("inventory").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} {{.Version}} inventory</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #f0f0f0; }
+code { font-size: 90%; }
+.na { color: #999; }
+</style>
+</head>
+<body>
+<h1>{{.Name}} <small>{{.Version}} ({{.Env}})</small></h1>
+{{range .Services}}
+<h2{{if not .Available}} class="na"{{end}}>{{.Prefix}}
+<small>{{range .Environments}}{{.}} {{end}}</small></h2>
+{{if .Source.Ok}}<p><code>{{.Source}}</code></p>{{end}}
+{{if .Endpoints}}
+<table>
+<tr><th>Methods</th><th>Path</th><th>Timeout</th><th>Middleware</th>
+<th>Access</th><th>Description</th><th>Input</th><th>Source</th></tr>
+{{range .Endpoints}}
+<tr>
+<td>{{range .Methods}}{{.}} {{end}}</td>
+<td><code>{{.Path}}</code></td>
+<td>{{.Timeout}}</td>
+<td>{{.Middleware}}</td>
+<td>{{range .Scopes}}{{.}} {{end}}{{range .Roles}}@{{.}} {{end}}</td>
+<td>{{.Description}}</td>
+<td>{{range .Input}}<code>{{.Name}}</code> {{.Type}} in {{.In}}{{if .Required}} required{{end}}{{range $k, $v := .Rules}} {{$k}}={{$v}}{{end}}<br>{{end}}
+{{if .Schema}}<code>{{printf "%v" .Schema}}</code>{{end}}</td>
+<td>{{if .Source.Ok}}<code>{{.Source}}</code>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+{{if .Auxes}}
+<table>
+<tr><th>Handle</th><th>Timeout</th><th>Middleware</th><th>Schedule</th>
+<th>Description</th><th>Schema</th><th>Source</th></tr>
+{{range .Auxes}}
+<tr>
+<td><code>{{.Handle}}</code></td>
+<td>{{.Timeout}}</td>
+<td>{{.Middleware}}</td>
+<td>{{.CronExpression}}{{if .WhenUp}} up{{end}}{{if .WhenDown}} down{{end}}</td>
+<td>{{.Description}}</td>
+<td>{{if .Schema}}<code>{{printf "%v" .Schema}}</code>{{end}}</td>
+<td>{{if .Source.Ok}}<code>{{.Source}}</code>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
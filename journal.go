@@ -0,0 +1,328 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "io"
+import "os"
+import "sort"
+import "sync"
+import "time"
+import "errors"
+import "strings"
+import "path/filepath"
+import "fmt"
+
+import "github.com/Sirupsen/logrus"
+
+// Configuration of the app journal, as it is decoded from the config
+// section app.journal; the level key of it is handled separately, see
+// the configLevel method. Output is stdout, stderr or a file path that
+// is relative to the app root; the file could be rotated by size or by
+// time. Entries of the levels listed in stderr are written to stderr.
+type journalConfig struct {
+    Format string `config:"format" default:"text" validate:"enum=text|json"`
+    Output string `config:"output" default:"stdout"` // or a file
+    Stderr []string `config:"stderr"` // levels routed to stderr
+    Priority bool `config:"priority"` // <N> prefix for journald
+    RotateSize int64 `config:"rotate-size" validate:"min=0"`
+    RotateEvery time.Duration `config:"rotate-every"` // by time
+    Keep int `config:"keep" validate:"min=0"` // rotated files
+    MaxAge time.Duration `config:"max-age"` // of rotated files
+    Syslog *syslogConfig `config:"syslog"` // absent if nil
+}
+
+// Configuration of the syslog writer, as it is decoded from the config
+// section app.journal.syslog. Empty network and address connect to the
+// local syslog daemon; otherwise, the network is udp or tcp and address
+// is the host and port of the remote daemon. Tag defaults to the name
+// of the application. Facility is one of the standard syslog ones. It
+// is rejected on the platforms without syslog, i.e. Windows and Plan 9.
+type syslogConfig struct {
+    Network string `config:"network" validate:"enum=|udp|tcp"`
+    Address string `config:"address"` // host:port
+    Tag string `config:"tag"` // the app name if empty
+    Facility string `config:"facility" default:"local0" validate:"enum=user|daemon|local0|local1|local2|local3|local4|local5|local6|local7"`
+}
+
+// Configure the output of the app journal, as declared by app.journal
+// config section: the formatter, the output file along with rotation,
+// the levels routed to stderr and the syslog writer. The journal is
+// modified in place, so the entries that refer to it remain valid. It
+// is invoked by Boot, after config is loaded; panics if it malformed.
+func (app *App) configureJournal() {
+    const elevel = "invalid app.journal.stderr level %v"
+    var config journalConfig // app.journal section
+    err := app.DecodeConfig("app.journal", &config)
+    if err != nil { panic(err) } // malformed config
+    var formatter logrus.Formatter = app.Journal.Formatter
+    if config.Format == "json" { formatter = &logrus.JSONFormatter {
+        TimestampFormat: time.RFC3339Nano } } // for shippers
+    route := &routeFormatter { Formatter: formatter }
+    route.priority, route.stderr = config.Priority, os.Stderr
+    route.levels = make(map[logrus.Level] bool) // routed
+    for _, name := range config.Stderr { // parse levels
+        level, err := logrus.ParseLevel(name) // by name
+        if err != nil { panic(fmt.Errorf(elevel, name)) }
+        route.levels[level] = true // goes to stderr
+    } // levels routed to stderr have been parsed
+    var hook logrus.Hook // syslog, if configured
+    if config.Syslog != nil { // connect to syslog
+        hook, err = app.syslogHook(*config.Syslog, formatter)
+        if err != nil { panic(err) } // or unsupported
+    } // connected to syslog, if it is configured
+    var output io.Writer = os.Stdout // by default
+    switch config.Output { // where entries go to
+        case "stdout": output = os.Stdout // default
+        case "stderr": output = os.Stderr // all of it
+        default: // a file path, relative to the root
+            full := config.Output // could be absolute
+            if !filepath.IsAbs(full) { full = filepath.Join(app.RootDirectory, full) }
+            file := &rotatingFile { path: full, size: config.RotateSize }
+            file.every, file.keep = config.RotateEvery, config.Keep
+            file.maxAge = config.MaxAge // prune older ones
+            if err := file.open(); err != nil { panic(err) }
+            output = file // rotated if configured
+    } // output of the journal has been set up
+    if hook != nil { app.Hook("syslog", hook) } // all
+    app.Journal.Formatter = route // may route some
+    app.Journal.Out = output // stdout, stderr or file
+    log := app.Journal.WithField("format", config.Format)
+    log.WithField("output", config.Output).Info("journal configured")
+}
+
+// Formatter of the app journal, that wraps the configured formatter to
+// implement the per level routing to stderr and the priority prefixes.
+// Entries of the routed levels are written straight into stderr and
+// nothing is returned for the journal output. Priority prefixes are
+// the ones that systemd-journald recognizes, such as <3> for errors.
+type routeFormatter struct {
+    logrus.Formatter // the configured formatter
+    levels map[logrus.Level] bool // to stderr
+    stderr io.Writer // where routed levels go
+    priority bool // prefix with <N> priority
+    sync.Mutex // guards the stderr writes
+}
+
+// Format the entry with the wrapped formatter, prefixing it with the
+// priority, if configured. If the level of the entry is routed, write
+// it to stderr and return nothing, so that the journal output receives
+// an empty write. This is the implementation of the logrus.Formatter
+// interface; invoked by the journal for every entry that it writes.
+func (rf *routeFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+    serialized, err := rf.Formatter.Format(entry)
+    if err != nil { return nil, err } // cannot format
+    if rf.priority { // systemd-journald compatible
+        prefix := fmt.Sprintf("<%d>", severity(entry.Level))
+        serialized = append([]byte(prefix), serialized...)
+    } // the entry has been prefixed with priority
+    if !rf.levels[entry.Level] { return serialized, nil }
+    rf.Lock(); defer rf.Unlock() // one at a time
+    _, err = rf.stderr.Write(serialized) // routed
+    return []byte {}, err // nothing for the output
+}
+
+// Severities of the journal entries, as they are defined by the RFC
+// 5424 for syslog; only those that the journal levels map onto. These
+// are used for the priority prefixes, that systemd-journald recognizes,
+// and for picking the severity of the entries written into the syslog.
+// See the severity function for how the journal levels are mapped.
+const (
+    severityCritical = 2 // panic and fatal
+    severityError = 3 // error conditions
+    severityWarning = 4 // warning conditions
+    severityInfo = 6 // informational messages
+    severityDebug = 7 // debug and trace ones
+)
+
+// Map the level of the journal entry to the syslog severity, which is
+// used both by the syslog writer and by the priority prefixes. Panic
+// and fatal entries become critical; others map onto the severity with
+// the same meaning. See RFC 5424 for the definition of the severities.
+func severity(level logrus.Level) int {
+    switch level { // from the most severe one
+        case logrus.PanicLevel, logrus.FatalLevel: return severityCritical
+        case logrus.ErrorLevel: return severityError
+        case logrus.WarnLevel: return severityWarning
+        case logrus.InfoLevel: return severityInfo
+        default: return severityDebug // the rest
+    }
+}
+
+// File output of the app journal, that is rotated once it grows over
+// the size limit, or once it has been open for longer than the period;
+// whichever comes first. Zero values disable either kind of rotation.
+// The rotated files are renamed with the timestamp suffix and pruned,
+// keeping only the configured number of them, not older than max age.
+type rotatingFile struct {
+    path string // of the current file
+    size int64 // rotate past this size
+    every time.Duration // rotate this often
+    keep int // number of rotated files kept
+    maxAge time.Duration // of the rotated files
+    file *os.File // currently open file
+    written int64 // size of the current file
+    opened time.Time // when the file was opened
+    sync.Mutex // guards all of the above
+}
+
+// Open the file for appending, creating it and its directory, if they
+// do not exist yet. The size of the file is taken into the account, so
+// that the rotation by size works correctly across the restarts. The
+// opened instant is the modification time of existing file, or now if
+// it is new; so that rotation by time works across the restarts too.
+func (rf *rotatingFile) open() error {
+    const flags = os.O_WRONLY | os.O_APPEND | os.O_CREATE
+    if err := os.MkdirAll(filepath.Dir(rf.path), 0755); err != nil {
+        return err // cannot create the directory
+    } // the directory exists, open the file
+    file, err := os.OpenFile(rf.path, flags, 0644)
+    if err != nil { return err } // cannot open it
+    info, err := file.Stat() // get size and mtime
+    if err != nil { file.Close(); return err }
+    rf.file, rf.written = file, info.Size() // as is
+    rf.opened = time.Now() // a fresh file, by default
+    if info.Size() > 0 { rf.opened = info.ModTime() }
+    return nil // ready for writing to it
+}
+
+// Write the chunk of data into the file, rotating the file beforehand
+// if it is due; by size or by time. If the file could not be reopened
+// after the rotation, the data is written into stderr instead, so that
+// nothing gets lost; reopening is retried on every subsequent write.
+// This is the implementation of the io.Writer interface.
+func (rf *rotatingFile) Write(data []byte) (int, error) {
+    rf.Lock(); defer rf.Unlock() // one at a time
+    if len(data) == 0 { return 0, nil } // routed
+    bySize := rf.size > 0 && rf.written + int64(len(data)) > rf.size
+    byTime := rf.every > 0 && time.Since(rf.opened) >= rf.every
+    if (bySize || byTime) && rf.written > 0 { rf.rotate() }
+    if rf.file == nil && rf.open() != nil { // lost it
+        return os.Stderr.Write(data) // keep the entry
+    } // the file is open, either old or the new one
+    n, err := rf.file.Write(data) // to current file
+    rf.written += int64(n); return n, err // count
+}
+
+// Layout of the timestamp suffix of the rotated journal files. Only the
+// files with the suffix of this layout are pruned after the rotation,
+// so that the other files next to the journal, such as the archives or
+// the backups made by the operator, are never removed by the rotation.
+const rotationLayout = "20060102-150405.000000000"
+
+// Rotate the file: close it, rename it with the timestamp suffix and
+// open a new one under the same path. Afterwards, prune the rotated
+// files that are beyond the number kept or older than max age. Errors
+// are written to stderr, since the journal itself could not be used
+// here; the current file is reopened, if the rename has failed.
+func (rf *rotatingFile) rotate() {
+    rf.file.Close() // flush and release the file
+    rotated := rf.path + "." + time.Now().Format(rotationLayout)
+    if err := os.Rename(rf.path, rotated); err != nil {
+        fmt.Fprintf(os.Stderr, "journal rotation failed: %v\n", err)
+    } // rotated, or left as is; open it anyway
+    if err := rf.open(); err != nil { // cannot reopen
+        fmt.Fprintf(os.Stderr, "journal reopen failed: %v\n", err)
+        rf.file = nil // will be retried on next write
+    } // the new file is open, prune the old ones
+    candidates, _ := filepath.Glob(rf.path + ".*") // all
+    var matches []string = nil // only the rotated ones
+    for _, candidate := range candidates { // check suffix
+        suffix := strings.TrimPrefix(candidate, rf.path + ".")
+        _, err := time.Parse(rotationLayout, suffix) // ours?
+        if err == nil { matches = append(matches, candidate) }
+    } // files that are not rotated journals are left alone
+    sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+    for i, match := range matches { // newest first
+        info, err := os.Stat(match) // get the mtime
+        if err != nil { continue } // already gone
+        stale := rf.maxAge > 0 && time.Since(info.ModTime()) > rf.maxAge
+        if (rf.keep > 0 && i >= rf.keep) || stale { os.Remove(match) }
+    } // the rotated files have been pruned
+}
+
+// Registry of the hooks of the app journal, keyed by their names and
+// kept in the order of registration. Registry itself is installed as
+// the single hook of the journal, dispatching every entry to the hooks
+// registered for that level. This allows to add and remove the hooks
+// at any time, safely; see the Hook and Unhook methods of the App.
+type hookRegistry struct {
+    names []string // in order of registration
+    hooks map[string] logrus.Hook // by name
+    sync.RWMutex // guards both of the above
+}
+
+// Levels of the entries that the registry dispatches, which are all of
+// them; each registered hook declares the levels it is interested in.
+// Fire dispatches the entry to the hooks registered for its level, in
+// order of registration; errors of the hooks are joined and returned,
+// which the journal reports to stderr. Implements the logrus.Hook.
+func (hr *hookRegistry) Levels() []logrus.Level { return logrus.AllLevels }
+func (hr *hookRegistry) Fire(entry *logrus.Entry) error {
+    hr.RLock(); defer hr.RUnlock() // read only
+    var failures []error // errors of the hooks
+    for _, name := range hr.names { // in order
+        hook := hr.hooks[name] // registered hook
+        for _, level := range hook.Levels() { // for it?
+            if level != entry.Level { continue } // no
+            err := hook.Fire(entry) // dispatch entry
+            if err != nil { failures = append(failures,
+                fmt.Errorf("hook %v: %w", name, err)) }
+            break // each hook fires at most once
+        } // the hook has been dispatched, if needed
+    } // all the registered hooks have been walked
+    return errors.Join(failures...) // nil if none
+}
+
+// Register the hook of the app journal under the specified name, which
+// replaces the hook that is already registered under that name, if any.
+// Hooks could be registered before the app is booted, or by providers
+// during the boot, or at any other time; they apply to all the entries
+// written afterwards. Hooks must not write into the journal themselves.
+func (app *App) Hook(name string, hook logrus.Hook) {
+    if hook == nil { panic("missing the journal hook") }
+    registry := app.hooks // created along with the app
+    registry.Lock(); defer registry.Unlock() // write
+    if _, ok := registry.hooks[name]; !ok { // new one
+        registry.names = append(registry.names, name)
+    } // name has been recorded, if it is new one
+    registry.hooks[name] = hook // add or replace it
+}
+
+// Remove the hook of the app journal that is registered under the
+// specified name. Returns false, if there is no hook with that name.
+// The hook will not receive any entries written after this returns.
+// Please see the Hook method for the details on registering hooks.
+func (app *App) Unhook(name string) bool {
+    registry := app.hooks // created along with the app
+    registry.Lock(); defer registry.Unlock() // write
+    if _, ok := registry.hooks[name]; !ok { return false }
+    delete(registry.hooks, name) // no longer dispatched
+    for i, n := range registry.names { // drop the name
+        if n != name { continue } // keep looking for it
+        registry.names = append(registry.names[:i], registry.names[i + 1:]...)
+        break // the name has been removed
+    } // registry no longer holds the hook
+    return true // hook has been unregistered
+}
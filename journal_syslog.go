@@ -0,0 +1,85 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+//go:build !windows && !plan9
+
+package boot
+
+import "errors"
+import "strings"
+import "log/syslog"
+
+import "github.com/Sirupsen/logrus"
+
+// Hook that writes the journal entries into the syslog, with severity
+// matching the level of each entry. Entries are formatted with the same
+// formatter as the journal itself, less the routing. It is registered
+// under the syslog name, when the app.journal.syslog section is present
+// in the config. See the syslogConfig for the details of configuration.
+type syslogHook struct {
+    writer *syslog.Writer // connected to the daemon
+    formatter logrus.Formatter // as the journal
+}
+
+// Connect to the syslog daemon, as configured, and create the hook that
+// writes the journal entries into it. The tag defaults to the name of
+// the application. Returns an error if the facility is unknown or the
+// daemon is not reachable. This is invoked by the configureJournal, at
+// the boot time. See the syslogHook for details on how entries go.
+func (app *App) syslogHook(config syslogConfig, formatter logrus.Formatter) (logrus.Hook, error) {
+    facilities := map[string] syslog.Priority {
+        "user": syslog.LOG_USER, "daemon": syslog.LOG_DAEMON,
+        "local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1,
+        "local2": syslog.LOG_LOCAL2, "local3": syslog.LOG_LOCAL3,
+        "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+        "local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+    } // facilities, as accepted by the config
+    facility, ok := facilities[config.Facility]
+    if !ok { return nil, errors.New("unknown syslog facility") }
+    var tag string = config.Tag // or the app name
+    if tag == "" { tag = app.Name } // defaults
+    priority := facility | syslog.LOG_INFO // default
+    writer, err := syslog.Dial(config.Network, config.Address, priority, tag)
+    if err != nil { return nil, err } // unreachable
+    return &syslogHook { writer, formatter }, nil
+}
+
+// Levels of the entries that should be written into the syslog, which
+// are all of them; filtering is done by the journal level. Fire writes
+// the entry with the severity that matches the level of the entry. It
+// is the implementation of the logrus.Hook interface; invoked by the
+// journal for every entry, through the hook registry of the app.
+func (sh *syslogHook) Levels() []logrus.Level { return logrus.AllLevels }
+func (sh *syslogHook) Fire(entry *logrus.Entry) error {
+    serialized, err := sh.formatter.Format(entry)
+    if err != nil { return err } // cannot format
+    line := strings.TrimSpace(string(serialized))
+    switch severity(entry.Level) { // by severity
+        case severityCritical: return sh.writer.Crit(line)
+        case severityError: return sh.writer.Err(line)
+        case severityWarning: return sh.writer.Warning(line)
+        case severityInfo: return sh.writer.Info(line)
+        default: return sh.writer.Debug(line)
+    }
+}
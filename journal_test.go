@@ -0,0 +1,64 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "os"
+import "sort"
+import "time"
+import "strings"
+import "testing"
+import "path/filepath"
+
+func TestRotatingFilePrune(t *testing.T) {
+    directory := t.TempDir() // journal files go here
+    path := filepath.Join(directory, "app.log")
+    old := time.Now().Add(-time.Hour) // rotated before
+    var names = []string { // the files next to journal
+        "app.log.gz", "app.log.bak", "app.log.20060102", "other.log",
+        "app.log." + old.Format(rotationLayout),
+        "app.log." + old.Add(time.Minute).Format(rotationLayout),
+    } // unrelated files and two rotated journals
+    for _, name := range names { os.WriteFile(filepath.Join(directory, name), nil, 0644) }
+    file := &rotatingFile { path: path, keep: 1 } // keep one
+    if err := file.open(); err != nil { t.Fatalf("cannot open: %v", err) }
+    file.Write([]byte("entry\n")); file.rotate() // prune
+    defer file.file.Close() // release the new journal
+    entries, _ := os.ReadDir(directory) // what is left
+    var left []string // names of the remaining files
+    for _, e := range entries { left = append(left, e.Name()) }
+    sort.Strings(left) // have a stable order
+    var rotated int = 0 // rotated journals are kept
+    for _, name := range left { // count rotated ones
+        suffix, ok := strings.CutPrefix(name, "app.log.")
+        if _, err := time.Parse(rotationLayout, suffix); ok && err == nil { rotated++ }
+    } // only the newest rotated journal is kept
+    if rotated != 1 { t.Errorf("%v rotated journals are kept, want 1: %v", rotated, left) }
+    for _, name := range names[:4] { // unrelated ones
+        if _, err := os.Stat(filepath.Join(directory, name)); err != nil {
+            t.Errorf("unrelated file %v has been removed", name)
+        } // the file is left alone by the rotation
+    }
+}
+
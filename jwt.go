@@ -0,0 +1,396 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "os"
+import "time"
+import "strings"
+import "errors"
+import "math/big"
+import "path/filepath"
+import "encoding/base64"
+import "encoding/json"
+import "encoding/pem"
+import "crypto"
+import "crypto/hmac"
+import "crypto/rsa"
+import "crypto/ecdsa"
+import "crypto/x509"
+import "fmt"
+
+import _ "crypto/sha256"
+import _ "crypto/sha512"
+
+import "github.com/pelletier/go-toml"
+
+// Key within the context storage, under which the JWT middleware is
+// going to store the claims of a successfully verified token. Claims
+// are stored as the boot.Claims typed value. If the request did not
+// carry a token, or if the token could not be verified - there will
+// be no value stored under this key. See Context.Claims method.
+const ClaimsKey = "boot.jwt.claims"
+
+// Key within the context storage, under which the JWT middleware is
+// going to store the error that has occured while verifying a token
+// carried by the request. It is used by Endpoint.Satisfied to report
+// the precise reason of denying access to an endpoint, in the case
+// where a token was supplied but it could not have been verified.
+const ClaimsIssueKey = "boot.jwt.issue"
+
+// Key within the application storage, under which the JWT verifier
+// is going to be cached, once it has been built out of the app config.
+// The verifier is built lazily, upon the first request that is passed
+// through the JWT middleware. This is an internal key; do not use it
+// or modify the value stored under it from within the app code.
+const verifierKey = "boot.jwt.verifier"
+
+// Set of claims carried by a verified JSON Web Token. This is merely
+// a decoded JSON object of the token payload, with some convenience
+// methods to access the standard and commonly used claims. Numbers
+// are decoded as float64 values, according to the JSON decoding rules.
+// Please refer to the JWT specification for the meaning of claims.
+type Claims map[string] interface {}
+
+// Middleware that authenticates an incoming HTTP request using the
+// JSON Web Token it carries, either as a bearer token in the header or
+// as a cookie. Token signature is verified using the key configured in
+// the app.jwt config section; then the exp, nbf and aud claims are
+// checked. On success, claims are put into the context storage.
+func Authenticate(context *Context, next BiasedLogic) {
+    verifier := context.App.jwtVerifier() // cached
+    token := verifier.extract(context) // find token
+    if len(token) == 0 { next(context); return }
+    claims, err := verifier.verify(token) // check it
+    if err != nil { // token could not be verified?
+        elog := context.Journal.WithError(err)
+        elog.Warn("rejecting the supplied JWT token")
+        context.Set(ClaimsIssueKey, err) // reason
+        next(context); return // proceed as anon
+    } // token has been verified successfully
+    context.Set(ClaimsKey, claims) // store claims
+    next(context) // proceed to the next layer
+}
+
+// Fetch the claims of the JSON Web Token that has been verified by
+// the JWT middleware for the request that this context represents. If
+// there was no token or it could not have been verified, then the nil
+// value will be returned. Please refer to the Authenticate middleware
+// for more details on how and when the claims are being established.
+func (c *Context) Claims() Claims {
+    claims, _ := Load[Claims](&c.Storage, ClaimsKey)
+    return claims // either claims or nil value
+}
+
+// Fetch a list of scopes that have been granted by the token. Both,
+// the space delimited "scope" claim and an array "scopes" claim are
+// supported. Values of both claims will be merged together, if both
+// of the claims are present. Non-string values are silently ignored.
+// Please refer to the OAuth 2.0 specifications for more details.
+func (cl Claims) Scopes() []string {
+    var scopes = make([]string, 0) // aggregate
+    if s, ok := cl["scope"].(string); ok { // RFC
+        scopes = append(scopes, strings.Fields(s)...)
+    } // space delimited scopes have been added
+    return append(scopes, cl.strings("scopes")...)
+}
+
+// Fetch a list of roles that have been granted by the token. Roles
+// are expected to be in the "roles" claim, as an array of strings or
+// as a single string value. Non-string values are silently ignored.
+// Roles are not a standard claim, so this is merely a convention that
+// is used by the framework to implement the role based access control.
+func (cl Claims) Roles() []string { return cl.strings("roles") }
+
+// Fetch a claim that is either a single string or an array of them,
+// as a slice of strings. If the claim is absent or is not of either
+// of the supported types, then an empty slice will be returned. This
+// is an internal helper used for the multi-value claims, such as aud,
+// roles and scopes. Non-string values in arrays are being ignored.
+func (cl Claims) strings(name string) []string {
+    var values = make([]string, 0) // aggregate
+    switch claim := cl[name].(type) { // either
+        case string: values = append(values, claim)
+        case []interface {}: for _, v := range claim {
+            if s, ok := v.(string); ok { values = append(values, s) }
+        } // collected all string values of array
+    } // all the supported types are covered
+    return values // could be an empty slice
+}
+
+// Check whether the claims satisfy the requirements; all the scopes
+// must be granted and at least one of the roles must be granted, if
+// any of the roles are required. Returns an error that describes the
+// first requirement that is not satisfied, or nil value if all is well.
+// Please see Endpoint.Scopes and Endpoint.Roles for the details.
+func (cl Claims) satisfy(scopes, roles []string) error {
+    const escope = "token lacks required scope %v"
+    const erole = "token lacks any of the roles %v"
+    granted := make(map[string] bool) // scopes
+    for _, s := range cl.Scopes() { granted[s] = true }
+    for _, s := range scopes { // check each one
+        if !granted[s] { return fmt.Errorf(escope, s) }
+    } // all the required scopes are granted
+    if len(roles) == 0 { return nil } // no roles
+    for _, r := range cl.Roles() { // any of them
+        for _, x := range roles { if r == x { return nil } }
+    } // none of the required roles is granted
+    return fmt.Errorf(erole, strings.Join(roles, ", "))
+}
+
+// Verifier of the JSON Web Tokens, as configured by the app.jwt config
+// section. It holds the parsed key and the parameters of verification.
+// If the configuration is broken, the verifier will hold the error and
+// will refuse to verify any token, reporting that error instead. It is
+// built once per application instance; see App.jwtVerifier method.
+type jwtVerifier struct {
+    algorithm string // expected signing algorithm
+    key interface {} // HMAC secret or a public key
+    audience string // expected audience, optional
+    issuer string // expected issuer, optional
+    leeway time.Duration // clock skew tolerance
+    header string // header that carries a token
+    cookie string // cookie that carries a token
+    broken error // configuration error, if any
+}
+
+// Fetch the JWT verifier of this application instance, building it
+// from the app.jwt config section upon the first invocation. Result is
+// cached within the application storage. If the config is invalid, the
+// verifier will carry the error, and the error will be journaled once.
+// Please refer to the jwtVerifier struct for more details on it.
+func (app *App) jwtVerifier() *jwtVerifier {
+    return app.GetOrCreate(verifierKey, func() interface {} {
+        verifier := app.buildJwtVerifier() // parse
+        if verifier.broken != nil { // invalid setup?
+            elog := app.Journal.WithError(verifier.broken)
+            elog.Error("invalid JWT configuration")
+        } // configuration error has been journaled
+        return verifier // cache it within the app
+    }).(*jwtVerifier)
+}
+
+// Build the JWT verifier out of the app.jwt config section. Section
+// should specify an algorithm, and either an HMAC secret or a path to
+// the PEM encoded public key (or certificate), relative to the app root.
+// Optionally, audience, issuer, leeway, header and cookie could be set.
+// See the implementation for the exact names of the config fields.
+func (app *App) buildJwtVerifier() *jwtVerifier {
+    const esection = "missing app.jwt config section"
+    const ealgorithm = "unsupported JWT algorithm %v"
+    const esecret = "missing secret for JWT algorithm %v"
+    verifier := &jwtVerifier { header: "Authorization" }
+    section, ok := app.CurrentConfig().Get("app.jwt").(*toml.TomlTree)
+    if !ok { verifier.broken = errors.New(esection) }
+    if !ok { return verifier } // cannot do anything
+    str := func(key string) string { // shortcut
+        value, _ := section.Get(key).(string)
+        return value // empty string if absent
+    } // simplified access to the string values
+    verifier.algorithm = strings.ToUpper(str("algorithm"))
+    verifier.audience = str("audience") // optional
+    verifier.issuer = str("issuer") // optional
+    verifier.cookie = str("cookie") // optional
+    if h := str("header"); len(h) > 0 { verifier.header = h }
+    if l := str("leeway"); len(l) > 0 { // set?
+        verifier.leeway, verifier.broken = time.ParseDuration(l)
+    } // leeway is parsed, unless it's broken
+    switch verifier.algorithm[:min(2, len(verifier.algorithm))] {
+        case "HS": // HMAC with shared secret
+            verifier.key = []byte(str("secret"))
+            if len(str("secret")) == 0 { // no secret?
+                verifier.broken = fmt.Errorf(esecret, verifier.algorithm)
+            } // secret is there, use it for HMAC
+        case "RS", "ES": // RSA or ECDSA public key
+            path := filepath.Join(app.RootDirectory, str("public-key"))
+            key, err := readPublicKey(filepath.Clean(path))
+            verifier.key = key // may be nil, if broken
+            if err != nil { verifier.broken = err }
+        default: // algorithm is not supported
+            verifier.broken = fmt.Errorf(ealgorithm, verifier.algorithm)
+    } // the key has been established, maybe
+    return verifier // either working or broken
+}
+
+// Read and parse PEM encoded public key from the specified file. The
+// file could contain either a PKIX public key or an X.509 certificate;
+// in the latter case, public key of the certificate will be used. The
+// key should be of either RSA or ECDSA type, because those are the
+// only public key algorithms supported by the JWT implementation.
+func readPublicKey(path string) (interface {}, error) {
+    const epem = "no PEM data found in %v"
+    const etype = "unsupported public key type %T"
+    data, err := os.ReadFile(path) // read up
+    if err != nil { return nil, err } // failed
+    block, _ := pem.Decode(data) // first block
+    if block == nil { return nil, fmt.Errorf(epem, path) }
+    var key interface {} // either RSA or ECDSA
+    if block.Type == "CERTIFICATE" { // certificate?
+        cert, err := x509.ParseCertificate(block.Bytes)
+        if err != nil { return nil, err } // broken
+        key = cert.PublicKey // take its public key
+    } else { // assume this is a PKIX public key
+        key, err = x509.ParsePKIXPublicKey(block.Bytes)
+        if err != nil { return nil, err } // broken
+    } // got the public key, check its type now
+    switch key.(type) { // only RSA and ECDSA
+        case *rsa.PublicKey, *ecdsa.PublicKey: return key, nil
+        default: return nil, fmt.Errorf(etype, key)
+    }
+}
+
+// Extract the JSON Web Token from an HTTP request that the context
+// represents. First, the configured header is checked for a bearer
+// token; then the configured cookie, if any. If no token is found, an
+// empty string is returned. No validation of the token is made here.
+// Please refer to the buildJwtVerifier for the configuration details.
+func (v *jwtVerifier) extract(context *Context) string {
+    const bearer = "bearer " // case insensitive
+    if context.Request == nil { return "" } // N/A
+    value := context.Request.Header.Get(v.header)
+    if len(value) > len(bearer) { // could fit it?
+        prefix := strings.ToLower(value[:len(bearer)])
+        if prefix == bearer { return value[len(bearer):] }
+    } // no bearer token found in the header
+    if len(v.cookie) == 0 { return "" } // no cookie
+    cookie, err := context.Request.Cookie(v.cookie)
+    if err != nil { return "" } // no such cookie
+    return cookie.Value // token from the cookie
+}
+
+// Verify the JSON Web Token and return its claims, if verification
+// has been successful. Signature is verified using the configured key
+// and the algorithm; the algorithm in the token header must match one
+// that is configured. Then the exp, nbf, aud and iss claims are being
+// checked, according to the configuration of the verifier instance.
+func (v *jwtVerifier) verify(token string) (Claims, error) {
+    const emalformed = "malformed JWT token"
+    const ealgorithm = "unexpected JWT algorithm %v"
+    if v.broken != nil { return nil, v.broken }
+    parts := strings.Split(token, ".") // 3 parts
+    if len(parts) != 3 { return nil, errors.New(emalformed) }
+    var header struct { Algorithm string `json:"alg"` }
+    if err := decodeSegment(parts[0], &header); err != nil {
+        return nil, err // header is not decodable
+    } // header decoded, check the algorithm
+    if header.Algorithm != v.algorithm { // mismatch?
+        return nil, fmt.Errorf(ealgorithm, header.Algorithm)
+    } // algorithm matches, verify the signature
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil { return nil, errors.New(emalformed) }
+    signed := parts[0] + "." + parts[1] // input
+    if err := v.signature(signed, signature); err != nil {
+        return nil, err // signature is not valid
+    } // signature is valid, decode the claims
+    var claims Claims = make(Claims) // payload
+    if err := decodeSegment(parts[1], &claims); err != nil {
+        return nil, err // payload is not decodable
+    } // claims decoded; check the standard ones
+    return claims, v.validate(claims) // check
+}
+
+// Verify the signature of the signed portion of the token, using the
+// configured algorithm and key. Supports HMAC, RSA PKCS #1 v1.5 and
+// ECDSA signatures with SHA-256, SHA-384 and SHA-512 hash functions.
+// Returns nil if signature is valid, otherwise an error describing
+// the problem. See the JSON Web Algorithms specification for details.
+func (v *jwtVerifier) signature(signed string, signature []byte) error {
+    const einvalid = "invalid JWT token signature"
+    var hash crypto.Hash // hash function to use
+    switch v.algorithm[2:] { // by the bit size
+        case "256": hash = crypto.SHA256
+        case "384": hash = crypto.SHA384
+        case "512": hash = crypto.SHA512
+        default: return errors.New(einvalid)
+    } // hash function has been chosen
+    if v.algorithm[:2] == "HS" { // HMAC family?
+        mac := hmac.New(hash.New, v.key.([]byte))
+        mac.Write([]byte(signed)) // compute MAC
+        if hmac.Equal(mac.Sum(nil), signature) { return nil }
+        return errors.New(einvalid) // mismatch
+    } // asymmetric algorithms work on digest
+    digest := hash.New() // compute the digest
+    digest.Write([]byte(signed)) // whole input
+    sum := digest.Sum(nil) // digest is ready
+    switch key := v.key.(type) { // by key type
+        case *rsa.PublicKey: // RSA PKCS #1 v1.5
+            if v.algorithm[:2] != "RS" { break }
+            err := rsa.VerifyPKCS1v15(key, hash, sum, signature)
+            if err == nil { return nil } // valid
+        case *ecdsa.PublicKey: // ECDSA, r || s
+            if v.algorithm[:2] != "ES" { break }
+            size := (key.Curve.Params().BitSize + 7) / 8
+            if len(signature) != size * 2 { break }
+            r := new(big.Int).SetBytes(signature[:size])
+            s := new(big.Int).SetBytes(signature[size:])
+            if ecdsa.Verify(key, sum, r, s) { return nil }
+    } // signature did not pass verification
+    return errors.New(einvalid) // not valid
+}
+
+// Validate the standard claims of the token: exp, nbf, aud and iss.
+// Time based claims are checked with respect to configured leeway, to
+// tolerate a reasonable clock skew between the issuer and the app. If
+// the audience or issuer is not configured, they are not checked at
+// all. Returns an error describing the first claim that did not pass.
+func (v *jwtVerifier) validate(claims Claims) error {
+    const eexpired = "JWT token has expired"
+    const epremature = "JWT token is not valid yet"
+    const eaudience = "JWT token audience mismatch"
+    const eissuer = "JWT token issuer mismatch"
+    now := float64(time.Now().Unix()) // seconds
+    leeway := v.leeway.Seconds() // tolerance
+    if exp, ok := claims["exp"].(float64); ok {
+        if now > exp + leeway { return errors.New(eexpired) }
+    } // token is not expired, if exp claim is set
+    if nbf, ok := claims["nbf"].(float64); ok {
+        if now < nbf - leeway { return errors.New(epremature) }
+    } // token is already valid, if nbf claim is set
+    if len(v.audience) > 0 { // audience to check?
+        var matched bool = false // until found
+        for _, aud := range claims.strings("aud") {
+            if aud == v.audience { matched = true }
+        } // audience claim has been checked
+        if !matched { return errors.New(eaudience) }
+    } // audience either matched or not checked
+    if len(v.issuer) > 0 && claims["iss"] != v.issuer {
+        return errors.New(eissuer) // mismatch
+    } // issuer either matched or not checked
+    return nil // all the claims are valid
+}
+
+// Decode a single base64url encoded segment of the JSON Web Token and
+// unmarshal the JSON data it contains into the supplied destination.
+// Both, padded and unpadded encodings are tolerated, since there are
+// JWT issuers that do pad segments, despite the specification. This
+// is an internal helper; refer to the verify method for the usage.
+func decodeSegment(segment string, dst interface {}) error {
+    const emalformed = "malformed JWT token segment"
+    segment = strings.TrimRight(segment, "=") // unpad
+    data, err := base64.RawURLEncoding.DecodeString(segment)
+    if err != nil { return errors.New(emalformed) }
+    if json.Unmarshal(data, dst) != nil { // JSON?
+        return errors.New(emalformed) // not a JSON
+    } // segment has been decoded successfully
+    return nil // destination is populated
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "os"
+import "time"
+import "runtime"
+import "strings"
+import "strconv"
+import "sync/atomic"
+import "fmt"
+
+import "github.com/pelletier/go-toml"
+
+// Level of the memory pressure that the application is experiencing,
+// as determined by the memory limits monitor. The monitor compares the
+// sampled memory usage against the soft and hard thresholds configured
+// in the app.limits config section. Please refer to the App method
+// MemoryPressure to find out the current level of memory pressure.
+type MemoryLevel int32
+
+// Levels of the memory pressure: normal means that all the metrics
+// are below their soft thresholds; soft means that some metric is over
+// its soft threshold; hard means that some metric is over its hard
+// threshold. Levels only go down once all metrics are well below the
+// thresholds, according to the hysteresis configured for the monitor.
+const (
+    MemoryNormal MemoryLevel = iota // all is fine
+    MemorySoft // over some soft threshold
+    MemoryHard // over some hard threshold
+)
+
+// String represenation of the memory pressure level, which is used
+// mainly for identification purposes when viewed by a human. It is
+// also used to lookup the action to take for the level in the config;
+// see the Watchdog.HittingMemLimits method for the details on that.
+// Unknown levels are represented by their numeric value.
+func (ml MemoryLevel) String() string {
+    switch ml { // known levels only
+        case MemoryNormal: return "normal"
+        case MemorySoft: return "soft"
+        case MemoryHard: return "hard"
+        default: return strconv.Itoa(int(ml))
+    }
+}
+
+// Get the current level of the memory pressure that the application
+// is experiencing, as determined by the memory limits monitor. If the
+// monitor is not running, the level is always normal. This is safe to
+// call concurrently from many go-routines. See the memoryLimits struct
+// for details on how the level of memory pressure is determined.
+func (app *App) MemoryPressure() MemoryLevel {
+    return MemoryLevel(atomic.LoadInt32(&app.pressure))
+}
+
+// Configuration of the memory limits monitor, as decoded from the
+// app.limits config section. Thresholds of zero mean that metric is
+// not being checked against that threshold. Hysteresis is a fraction
+// of the threshold that the metric must drop below the threshold by,
+// in order for the level of the memory pressure to go down again.
+// Actions are what the Watchdog does once the level has been reached.
+// Interval is at least 100ms, since reading the memory stats stops the
+// world; a shorter one would keep pausing the application all the time.
+type memoryLimits struct {
+    Interval time.Duration `config:"interval" default:"10s" validate:"min=100ms"`
+    Hysteresis float64 `config:"hysteresis" default:"0.1" validate:"min=0,max=0.99"`
+    HeapSoft uint64 `config:"heap-soft"` // heap bytes
+    HeapHard uint64 `config:"heap-hard"` // heap bytes
+    RSSSoft uint64 `config:"rss-soft"` // resident bytes
+    RSSHard uint64 `config:"rss-hard"` // resident bytes
+    GoroutinesSoft uint64 `config:"goroutines-soft"`
+    GoroutinesHard uint64 `config:"goroutines-hard"`
+    SoftAction string `config:"soft-action" default:"gc" validate:"enum=gc|shutdown|log"`
+    HardAction string `config:"hard-action" default:"shutdown" validate:"enum=gc|shutdown|log"`
+}
+
+// Load the configuration of the memory limits monitor by decoding the
+// app.limits section of the supplied config tree. Returns nil if there
+// is no such section in the config, meaning that the monitor should
+// not be started. Panics with the ConfigError if the section is bad,
+// so that a mis-typed threshold or action fails the boot early on.
+func (app *App) loadLimits(tree *toml.TomlTree) *memoryLimits {
+    const section = "app.limits" // config section
+    if tree == nil || tree.Get(section) == nil { return nil }
+    limits := &memoryLimits {} // decoded from config
+    err := decodeConfig(tree, section, limits)
+    if err != nil { panic(err) } // malformed section
+    return limits // monitor configuration
+}
+
+// Thresholds of the metrics sampled by the memory limits monitor, in
+// the order of heap bytes, resident bytes and the go-routines count.
+// Each threshold is a pair of the soft and the hard one, so it could
+// be indexed by the memory pressure level, minus one. Zero thresholds
+// mean that the metric is not checked against that level at all.
+func (ml *memoryLimits) thresholds() [][2]uint64 {
+    return [][2]uint64 { // soft and hard ones
+        { ml.HeapSoft, ml.HeapHard }, // heap bytes
+        { ml.RSSSoft, ml.RSSHard }, // resident bytes
+        { ml.GoroutinesSoft, ml.GoroutinesHard },
+    }
+}
+
+// Action that should be taken once the memory pressure reaches the
+// specified level; one of "log", "gc" or "shutdown". Limits could be
+// nil, when the monitor is not configured; in which case the default
+// actions are used: soft level forces the GC and the hard level does
+// initiate the graceful shutdown of the application instance.
+func (ml *memoryLimits) action(level MemoryLevel) string {
+    if ml == nil { ml = &memoryLimits { SoftAction: "gc",
+        HardAction: "shutdown" } } // the defaults
+    if level == MemoryHard { return ml.HardAction }
+    return ml.SoftAction // soft or anything lower
+}
+
+// Parse a human readable size, such as 512MB or 1GiB, into a number
+// of bytes. Supports both decimal (KB, MB, GB, TB) and binary (KiB,
+// MiB, GiB, TiB) suffixes, as well as plain B or no suffix at all. The
+// suffix is case insensitive and could be separated with whitespace.
+// Returns an error if the size could not be parsed or is negative.
+func parseSize(size string) (uint64, error) {
+    const emalformed = "malformed size %q"
+    var units = []struct { suffix string; factor uint64 } {
+        {"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+        {"tib", 1 << 40}, {"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9},
+        {"tb", 1e12}, {"b", 1}, // must go the last
+    } // units are ordered, so longest match wins
+    text := strings.ToLower(strings.TrimSpace(size))
+    var factor uint64 = 1 // bytes, if no suffix
+    for _, unit := range units { // find suffix
+        if !strings.HasSuffix(text, unit.suffix) { continue }
+        text = strings.TrimSuffix(text, unit.suffix)
+        factor = unit.factor; break // found it
+    } // suffix, if any, has been stripped
+    text = strings.TrimSpace(text) // the number
+    number, err := strconv.ParseFloat(text, 64)
+    if err != nil || number < 0 { // malformed?
+        return 0, fmt.Errorf(emalformed, size)
+    } // number is good, apply the factor to it
+    return uint64(number * float64(factor)), nil
+}
+
+// Spawn the memory limits monitor, if it is configured within the
+// app.limits config section. Monitor samples the memory usage on the
+// configured interval, determines the memory pressure level and calls
+// the supervisor whenever the level goes up. Reloaded limits apply on
+// the next tick; removing the section pauses the monitor. The monitor
+// stops once the application has been shut down. See Deploy for usage.
+func (app *App) monitorLimits() {
+    limits := app.limits.Load() // as of the boot
+    if limits == nil { return } // not configured
+    log := app.Journal.WithField("every", limits.Interval)
+    log.Info("spawn the memory limits monitor")
+    interval := limits.Interval // could be reloaded
+    ticker := time.NewTicker(interval) // tick it
+    go func() { // this runs in the background
+        defer ticker.Stop() // release the ticker
+        for { select { // either tick or stopped
+            case <- app.lifetime.Done(): return // done
+            case <- ticker.C: // sample, unless removed
+                limits := app.limits.Load() // reloaded?
+                if limits == nil { continue } // paused
+                app.sampleLimits(limits) // sample it
+                if limits.Interval == interval { continue }
+                interval = limits.Interval // reconfigured
+                ticker.Reset(interval) // apply new one
+        }}
+    }() // monitor is running in the background
+}
+
+// Sample the memory usage once and update the memory pressure level
+// accordingly. The level goes up immediately, once any metric crosses
+// the threshold; but goes down only after all the metrics are below
+// their thresholds lowered by the hysteresis. Supervisor is called
+// every time the level goes up, not while it stays at the same level.
+func (app *App) sampleLimits(limits *memoryLimits) {
+    var stats runtime.MemStats // memory statistics
+    runtime.ReadMemStats(&stats) // sample memory
+    var rss uint64 = residentSize() // 0 if unknown
+    var routines = uint64(runtime.NumGoroutine())
+    samples := []uint64 { stats.HeapAlloc, rss, routines }
+    thresholds := limits.thresholds() // in order
+    previous := app.MemoryPressure() // last level
+    var level MemoryLevel = MemoryNormal // assume
+    for i, sample := range samples { // each metric
+        for l := MemoryHard; l > MemoryNormal; l-- {
+            bound := float64(thresholds[i][l - 1])
+            if bound == 0 { continue } // not set
+            if l <= previous { // lower to leave it
+                bound = bound * (1 - limits.Hysteresis)
+            } // threshold accounts for hysteresis
+            if float64(sample) < bound { continue }
+            if l > level { level = l }; break // max
+        } // the level for this metric is known
+    } // the level over all metrics is known
+    atomic.StoreInt32(&app.pressure, int32(level))
+    if level == previous { return } // no change
+    log := app.Journal.WithField("level", level)
+    log = log.WithField("heap", stats.HeapAlloc)
+    log = log.WithField("rss", rss) // 0 if unknown
+    log = log.WithField("goroutines", routines)
+    if level < previous { // memory pressure eased?
+        log.Info("memory pressure has eased")
+        return // nothing to do about it
+    } // memory pressure is rising, report it
+    log.Warn("memory pressure is rising")
+    app.Supervisor.HittingMemLimits(app, &stats)
+}
+
+// Get the resident set size of the current process, in bytes. It is
+// read from the /proc file system, so it is only available on Linux
+// and similar systems. Returns zero if the size could not have been
+// determined for whatever reason; in which case the RSS thresholds
+// will effectively be ignored by the memory limits monitor.
+func residentSize() uint64 {
+    data, err := os.ReadFile("/proc/self/statm")
+    if err != nil { return 0 } // not available
+    fields := strings.Fields(string(data)) // pages
+    if len(fields) < 2 { return 0 } // malformed
+    pages, err := strconv.ParseUint(fields[1], 10, 64)
+    if err != nil { return 0 } // malformed data
+    return pages * uint64(os.Getpagesize())
+}
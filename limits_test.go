@@ -0,0 +1,56 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "strings"
+import "testing"
+
+import "github.com/pelletier/go-toml"
+
+func TestLoadLimits(t *testing.T) {
+    var cases = []struct {
+        section string // the app.limits config section
+        message string // empty if it must be accepted
+    } {
+        { ``, "" }, { `interval = "100ms"`, "" },
+        { `interval = "1ns"`, "app.limits.interval: must be at least 100ms" },
+        { `interval = "99ms"`, "app.limits.interval: must be at least 100ms" },
+        { `soft-action = "panic"`, "app.limits.soft-action: must be one of gc, shutdown, log" },
+        { `hysteresis = 1.5`, "app.limits.hysteresis: must be at most 0.99" },
+    } // sections and the violations they make
+    for _, c := range cases { // load every section
+        tree, err := toml.Load("[app.limits]\n" + c.section)
+        if err != nil { t.Fatalf("cannot load config: %v", err) }
+        var message string // empty if accepted
+        func() { // loading panics on a bad section
+            defer func() { if r := recover(); r != nil { message = r.(error).Error() } }()
+            limits := quietApp().loadLimits(tree) // decode
+            if limits.Interval <= 0 { message = "no interval" }
+        }() // the section has been loaded or rejected
+        if (c.message == "") != (message == "") || !strings.Contains(message, c.message) {
+            t.Errorf("section %q got %q, want %q", c.section, message, c.message)
+        } // the outcome is as expected
+    }
+}
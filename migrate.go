@@ -0,0 +1,565 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "io"
+import "os"
+import "sort"
+import "time"
+import "regexp"
+import "strings"
+import "strconv"
+import "path/filepath"
+import "database/sql"
+import "errors"
+import "fmt"
+
+import stdctx "context"
+
+// Function that migrates the schema of the SQL database, one version
+// up or down, within the supplied transaction. It is used to implement
+// the migrations in Go, when plain SQL is not enough; such as when the
+// data has to be transformed in between. If it returns an error, then
+// the transaction is rolled back and the migration is not recorded.
+type MigrationLogic func (*sql.Tx) error
+
+// Single versioned migration of the schema of the SQL database. It is
+// either loaded from a pair of <version>_<name>.up.sql and .down.sql
+// files in the migrations directory, or declared in Go by App.Migration
+// method. Migrations are applied in the order of their versions, each
+// one in a separate transaction, recording the version in the table.
+type Migration struct {
+
+    // Version of the migration; a positive number that determines
+    // the order in which migrations are applied. It is common to use
+    // the timestamp of when migration was written, such as 20150102
+    // or 201501021504, to avoid clashes between the developers. Every
+    // version must be unique, whether declared in SQL files or in Go.
+    Version int64
+
+    // Short name of the migration that describes what it does, such
+    // as create_users. For migrations loaded from files, it is taken
+    // from the file name, right after the version. It is recorded in
+    // the migrations table along with the version, and is reported by
+    // the status command, so keep it human readable and succinct.
+    Name string
+
+    // Implementation of migrating the schema one version up, that is
+    // applying the migration. For the migrations loaded from the files,
+    // this executes the contents of the .up.sql file. It is mandatory;
+    // every migration must be able to go up. Please refer to the type
+    // MigrationLogic for the details on how the logic is invoked.
+    Up MigrationLogic
+
+    // Implementation of migrating the schema one version down, that
+    // is reverting the migration. For the migrations loaded from files,
+    // this executes the contents of the .down.sql file, if there is one.
+    // It is optional; but the migrations without it could not be rolled
+    // back, and the rollback will stop at such a migration with error.
+    Down MigrationLogic
+}
+
+// Status of a single migration, as it is reported by MigrationStatus
+// method. It combines the migrations known to the application, with
+// versions recorded in the migrations table of the database. Versions
+// that are recorded but not known to the application, such as those
+// that were applied by the newer releases, are reported as well.
+type MigrationRecord struct {
+    Version int64 `json:"version"` // of migration
+    Name string `json:"name"` // as known or recorded
+    Applied bool `json:"applied"` // is it recorded?
+    AppliedAt string `json:"appliedAt,omitempty"`
+    Known bool `json:"known"` // known to the app?
+}
+
+// Configuration of the migrations, as it is decoded from the config
+// section app.migrations. The intent refers to one of the databases in
+// the app.databases.sql section; directory is relative to app root;
+// and auto is the list of the envs, where the pending migrations are
+// applied automatically when the app is booted. See installMigrations.
+// Lock lease is for how long the lock stays valid without a heartbeat;
+// once it expires, the lock is considered stale and could be taken over.
+// The lease is timed by the waiting instance with its own clock, from
+// the moment it has last seen the heartbeat of the lock change. It is
+// at least a second, so that the heartbeats do not flood the database.
+type migrationsConfig struct {
+    Intent string `config:"intent,required"` // database
+    Directory string `config:"dir" default:"migrations"`
+    Table string `config:"table" default:"schema_migrations" validate:"pattern=^[a-zA-Z_][a-zA-Z0-9_]*$"`
+    Auto []string `config:"auto"` // envs to migrate at boot
+    LockTimeout time.Duration `config:"lock-timeout" default:"1m"`
+    LockLease time.Duration `config:"lock-lease" default:"1m" validate:"min=1s"`
+}
+
+// Declare a new migration, implemented in Go, within the current app.
+// Method takes the origin function that will take the migration and
+// properly set it up. The migration must have a positive version and
+// the Up logic; its version must not clash with any other migration,
+// either in Go or in the SQL files, which is checked when it is run.
+func (app *App) Migration(origin func(*Migration)) *Migration {
+    const eversion = "migration version must be positive"
+    const eup = "missing the up logic of the migration"
+    if !app.Booted.IsZero() { // app is booted?
+        panic("refusing to modify the booted app")
+    } // app is not yet booted; we are good to go
+    if origin == nil { // origin points to nowhere?
+        panic("missing the migration origin function")
+    } // origin is intact, we shall invoke it later
+    var migration *Migration = &Migration {} // alloc
+    origin(migration) // migration is made right here
+    if migration.Version <= 0 { panic(eversion) }
+    if migration.Up == nil { panic(eup) }
+    app.Lock() // accquire mutex lock on the app
+    app.migrations = append(app.migrations, migration)
+    app.Unlock() // release the accquired mutex
+    return migration // is ready for usage
+}
+
+// Install the built-in provider that applies the pending migrations
+// when the application is booted, if the app.migrations section is in
+// the config and the current env is listed in its auto key. Provider
+// depends on the provider of the database, so it is set up right after
+// the database is opened. Panics if the config section is malformed.
+func (app *App) installMigrations() {
+    if !app.Config.Has("app.migrations") { return }
+    var config migrationsConfig // app.migrations
+    err := app.DecodeConfig("app.migrations", &config)
+    if err != nil { panic(err) } // malformed config
+    app.migrator = &config // used by the commands
+    var auto bool = false // migrate in this env?
+    for _, env := range config.Auto { auto = auto || env == app.Env }
+    if !auto { return } // migrations are manual here
+    app.Provider(func(p *Provider) {
+        p.Name = "migrations" // dependable by others
+        p.About = "SQL schema migrations"
+        p.Depends = []string { "sql-" + config.Intent }
+        p.Available[app.Env] = true // configured here
+        p.Setup = func(app *App) { // migrate right away
+            if err := app.MigrateUp(); err != nil { panic(err) }
+        } // pending migrations have been applied
+    }) // provider of the migrations is installed
+}
+
+// Apply all the pending migrations, in the order of their versions,
+// each one in a separate transaction. Migrations table is created if
+// it does not exist yet. The migration lock is held all the way, so
+// only one instance of the app migrates at a time. Stops at the first
+// failed migration and returns the error; applied ones are recorded.
+func (app *App) MigrateUp() error {
+    return app.migrate(func(ctx stdctx.Context, db *sql.DB, c *migrationsConfig) error {
+        plan, err := app.migrationPlan(c) // all known
+        if err != nil { return err } // cannot plan
+        applied, err := appliedMigrations(db, c.Table)
+        if err != nil { return err } // cannot read
+        var count int = 0 // number of applied now
+        for _, m := range plan { // in version order
+            if _, ok := applied[m.Version]; ok { continue }
+            err := runMigration(ctx, db, c.Table, m, true)
+            if err != nil { return err } // stop here
+            app.Journal.WithField("version", m.Version).
+                WithField("name", m.Name).Info("applied migration")
+            count++ // one more migration is applied
+        } // all the pending migrations are applied
+        app.Journal.Infof("applied %v pending migrations", count)
+        return nil // the schema is up to date now
+    })
+}
+
+// Revert the specified number of the most recently applied migrations,
+// in the reverse order of their versions, each one in a separate tx.
+// The migration lock is held all the way. Stops at the first migration
+// that fails, or has no Down logic, or is not known to the app, and
+// returns the error; the reverted ones are removed from the table.
+func (app *App) MigrateDown(steps int) error {
+    const eunknown = "migration %v is not known to the app"
+    const edown = "migration %v could not be reverted"
+    return app.migrate(func(ctx stdctx.Context, db *sql.DB, c *migrationsConfig) error {
+        plan, err := app.migrationPlan(c) // all known
+        if err != nil { return err } // cannot plan
+        applied, err := appliedMigrations(db, c.Table)
+        if err != nil { return err } // cannot read
+        known := make(map[int64] *Migration) // by version
+        for _, m := range plan { known[m.Version] = m }
+        versions := make([]int64, 0, len(applied))
+        for v := range applied { versions = append(versions, v) }
+        sort.Slice(versions, func(i, j int) bool {
+            return versions[i] > versions[j] // latest first
+        }) // applied versions are in the reverse order
+        for i := 0; i < steps && i < len(versions); i++ {
+            m, ok := known[versions[i]] // find the logic
+            if !ok { return fmt.Errorf(eunknown, versions[i]) }
+            if m.Down == nil { return fmt.Errorf(edown, m.Version) }
+            err := runMigration(ctx, db, c.Table, m, false)
+            if err != nil { return err } // stop here
+            app.Journal.WithField("version", m.Version).
+                WithField("name", m.Name).Warn("reverted migration")
+        } // requested number of migrations are reverted
+        return nil // the schema has been rolled back
+    })
+}
+
+// Report the status of all the migrations: the ones that are known to
+// the application, whether they are applied or pending, as well as the
+// ones that are recorded in the migrations table, but are not known to
+// this app. Records are ordered by the version. The migrations table is
+// created, if it does not exist, but the lock is not taken for this.
+func (app *App) MigrationStatus() ([]MigrationRecord, error) {
+    db, c, err := app.migrationDatabase() // configured
+    if err != nil { return nil, err } // not configured
+    if err := createMigrationsTable(db, c.Table); err != nil {
+        return nil, err // could not create the table
+    } // the migrations table exists, read it up
+    plan, err := app.migrationPlan(c) // all known
+    if err != nil { return nil, err } // cannot plan
+    applied, err := appliedMigrations(db, c.Table)
+    if err != nil { return nil, err } // cannot read
+    records := make([]MigrationRecord, 0, len(plan))
+    for _, m := range plan { // all the known ones
+        record := MigrationRecord { Version: m.Version }
+        record.Name, record.Known = m.Name, true // known
+        if a, ok := applied[m.Version]; ok { // applied?
+            record.Applied, record.AppliedAt = true, a[1]
+            delete(applied, m.Version) // accounted for
+        } // status of the migration is determined
+        records = append(records, record) // in order
+    } // all the known migrations are reported
+    for version, a := range applied { // unknown ones
+        records = append(records, MigrationRecord { Version: version,
+            Name: a[0], Applied: true, AppliedAt: a[1] })
+    } // all the unknown migrations are reported too
+    sort.Slice(records, func(i, j int) bool {
+        return records[i].Version < records[j].Version
+    }) // records are ordered by the version
+    return records, nil // status of all migrations
+}
+
+// Execute the migration command, as given by the arguments, and write
+// the outcome into the writer. Commands are: "up" to apply all pending
+// migrations; "down [steps]" to revert the latest migrations, one by
+// default; and "status" to list the migrations and their status. This
+// is meant to be wired into the command line of the app, after Boot.
+func (app *App) MigrationCommand(w io.Writer, args ...string) error {
+    const eusage = "usage: up | down [steps] | status"
+    if len(args) == 0 { return errors.New(eusage) }
+    switch args[0] { // which command to execute?
+        case "up": return app.MigrateUp() // all pending
+        case "down": // revert the latest migrations
+            var steps int = 1 // revert one by default
+            if len(args) > 1 { // got number of steps?
+                n, err := strconv.Atoi(args[1]) // parse
+                if err != nil || n < 1 { return errors.New(eusage) }
+                steps = n // revert this many migrations
+            } // number of steps has been determined
+            return app.MigrateDown(steps) // revert
+        case "status": // list the migrations
+            records, err := app.MigrationStatus()
+            if err != nil { return err } // failed
+            for _, r := range records { // one per line
+                var state string = "pending" // not yet
+                if r.Applied { state = "applied " + r.AppliedAt }
+                if !r.Known { state = state + " (unknown)" }
+                fmt.Fprintf(w, "%d\t%s\t%s\n", r.Version, r.Name, state)
+            } // all the records have been written out
+            return nil // status has been reported
+        default: return errors.New(eusage) // unknown
+    }
+}
+
+// Obtain the SQL database that the migrations are configured for, as
+// well as the configuration of the migrations itself. Returns an error
+// if the migrations are not configured, or if the database has not
+// been opened by its provider; that is, if the application has not
+// been booted yet. Used by all the migration commands.
+func (app *App) migrationDatabase() (*sql.DB, *migrationsConfig, error) {
+    const enone = "migrations are not configured"
+    c := app.migrator // decoded when app is booted
+    if c == nil { return nil, nil, errors.New(enone) }
+    key := DatabasePrefix + c.Intent // opened by provider
+    db, err := Load[*sql.DB](&app.Storage, key)
+    if err != nil { return nil, nil, err } // not open
+    return db, c, nil // ready to run migrations
+}
+
+// Run the migration routine while holding the migration lock, so that
+// only one instance of the app migrates the database at a time. The
+// migrations table is created beforehand, if it does not exist yet.
+// The lock is released once the routine completes, whether it has
+// failed or not. The context is cancelled, if the lock has been lost.
+func (app *App) migrate(routine func(stdctx.Context, *sql.DB, *migrationsConfig) error) error {
+    db, c, err := app.migrationDatabase() // config
+    if err != nil { return err } // not configured
+    if err := createMigrationsTable(db, c.Table); err != nil {
+        return err // could not create the tables
+    } // the migrations tables exist, take the lock
+    ctx, release, err := app.lockMigrations(db, c)
+    if err != nil { return err } // could not lock
+    defer release() // let other instances migrate
+    return routine(ctx, db, c) // run it under the lock
+}
+
+// Assemble the plan of all the migrations known to the application:
+// the ones that are loaded from the SQL files in the directory, and
+// the ones that are declared in Go. Migrations are ordered by their
+// versions. Returns an error if the files could not be read, or if any
+// of the versions is declared more than once, or some up is missing.
+func (app *App) migrationPlan(c *migrationsConfig) ([]*Migration, error) {
+    const edup = "migration version %v is declared twice"
+    const eup = "migration %v has no up file"
+    pattern := regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+    byVersion := make(map[int64] *Migration) // index
+    var plan []*Migration = nil // all known ones
+    directory := filepath.Join(app.RootDirectory, c.Directory)
+    entries, err := os.ReadDir(directory) // the files
+    if err != nil && !os.IsNotExist(err) { return nil, err }
+    for _, entry := range entries { // walk the files
+        match := pattern.FindStringSubmatch(entry.Name())
+        if match == nil || entry.IsDir() { continue }
+        version, err := strconv.ParseInt(match[1], 10, 64)
+        if err != nil || version <= 0 { continue } // odd
+        full := filepath.Join(directory, entry.Name())
+        data, err := os.ReadFile(full) // read it up
+        if err != nil { return nil, err } // unreadable
+        m := byVersion[version] // the other half?
+        if m == nil { m = &Migration { Version: version, Name: match[2] }
+            byVersion[version] = m; plan = append(plan, m) }
+        if match[2] != m.Name { return nil, fmt.Errorf(edup, version) }
+        if match[3] == "up" { m.Up = sqlMigration(string(data)) }
+        if match[3] == "down" { m.Down = sqlMigration(string(data)) }
+    } // all the SQL files have been loaded
+    for _, m := range plan { // check every SQL pair
+        if m.Up == nil { return nil, fmt.Errorf(eup, m.Version) }
+    } // every loaded migration could go up
+    for _, m := range app.migrations { // declared in Go
+        if byVersion[m.Version] != nil { return nil, fmt.Errorf(edup, m.Version) }
+        byVersion[m.Version] = m; plan = append(plan, m)
+    } // all the Go migrations are in the plan
+    sort.Slice(plan, func(i, j int) bool {
+        return plan[i].Version < plan[j].Version
+    }) // the plan is ordered by the versions
+    return plan, nil // ready to be applied
+}
+
+// Make the migration logic out of the SQL text, as it is loaded from
+// the migration file. The whole text is executed at once, in a single
+// statement; which works with the drivers that allow multiple statements
+// in a single Exec, such as the ones of PostgreSQL and SQLite. For the
+// drivers that do not, keep a single statement per migration file.
+func sqlMigration(text string) MigrationLogic {
+    return func(tx *sql.Tx) error {
+        if strings.TrimSpace(text) == "" { return nil }
+        _, err := tx.Exec(text); return err // run it
+    }
+}
+
+// Create the migrations table and the lock table, unless they exist.
+// The migrations table records the versions of the applied migrations,
+// along with their names and the instant they were applied, as text.
+// Lock table holds at most one row, that is the lock held by one of the
+// app instances, along with the counter of its heartbeats. Plain SQL is
+// used, so that it works the same way with all the drivers.
+func createMigrationsTable(db *sql.DB, table string) error {
+    const create = "CREATE TABLE IF NOT EXISTS %s (%s)"
+    _, err := db.Exec(fmt.Sprintf(create, table, "version BIGINT " +
+        "PRIMARY KEY, name VARCHAR(255), applied_at VARCHAR(64)"))
+    if err != nil { return err } // could not create
+    _, err = db.Exec(fmt.Sprintf(create, table + "_lock", "id INTEGER " +
+        "PRIMARY KEY, owner VARCHAR(64), acquired VARCHAR(64), " +
+        "heartbeat BIGINT")) // counter of the renewals
+    return err // lock table has been created
+}
+
+// Read the versions of the applied migrations, as recorded in the
+// migrations table, along with their names and the instants of when
+// they were applied. The values are pairs of the name and the instant,
+// keyed by the version. Returns an error if the table could not be
+// read, for instance if it does not exist in the database.
+func appliedMigrations(db *sql.DB, table string) (map[int64] [2]string, error) {
+    const query = "SELECT version, name, applied_at FROM %s"
+    rows, err := db.Query(fmt.Sprintf(query, table))
+    if err != nil { return nil, err } // cannot read
+    defer rows.Close() // release the connection
+    applied := make(map[int64] [2]string) // by version
+    for rows.Next() { // walk all the recorded ones
+        var version int64; var name, at sql.NullString
+        if err := rows.Scan(&version, &name, &at); err != nil {
+            return nil, err // malformed record
+        } // got the record, store it by version
+        applied[version] = [2]string { name.String, at.String }
+    } // all the records have been read
+    return applied, rows.Err() // nil if all fine
+}
+
+// Run the migration up or down, in a separate transaction, recording
+// or removing the version in the migrations table within the same tx.
+// If the migration logic fails, the transaction is rolled back and the
+// error is returned, wrapped with the version and name of migration.
+// The transaction is bound to the context, that is cancelled once the
+// migration lock is lost; so the migration is not recorded then.
+// Values are inlined into SQL, as the placeholders are not portable.
+func runMigration(ctx stdctx.Context, db *sql.DB, table string, m *Migration, up bool) error {
+    const efailed = "migration %v %v failed: %w"
+    const insert = "INSERT INTO %s (version, name, applied_at) VALUES (%d, '%s', '%s')"
+    const remove = "DELETE FROM %s WHERE version = %d"
+    failed := func(err error) error { // wrap it up
+        if ctx.Err() != nil { err = stdctx.Cause(ctx) }
+        return fmt.Errorf(efailed, m.Version, m.Name, err)
+    } // the lost lock takes precedence over the rest
+    if ctx.Err() != nil { return failed(ctx.Err()) }
+    tx, err := db.BeginTx(ctx, nil) // each in separate tx
+    if err != nil { return failed(err) } // cannot begin
+    defer tx.Rollback() // no-op if committed
+    var logic MigrationLogic = m.Down // revert?
+    if up { logic = m.Up } // or apply it
+    if err := logic(tx); err != nil { // migrate
+        return failed(err) // rolled back
+    } // migration went fine, record it in the table
+    name := strings.ReplaceAll(m.Name, "'", "''") // quoted
+    moment := time.Now().UTC().Format(time.RFC3339)
+    statement := fmt.Sprintf(remove, table, m.Version)
+    if up { statement = fmt.Sprintf(insert, table, m.Version, name, moment) }
+    if _, err := tx.Exec(statement); err != nil {
+        return failed(err) // rolled back
+    } // migration has been recorded, commit it
+    if err := tx.Commit(); err != nil { return failed(err) }
+    return nil // the migration has been persisted
+}
+
+// Take the migration lock, by inserting the single row into the lock
+// table; which succeeds only for one instance of the app, due to the
+// primary key. Others retry until the lock timeout expires; but take
+// over the lock, if its heartbeat has not changed for the lease, since
+// its holder must have crashed. Only the clock of the waiting instance
+// is used for that, so the clocks of the hosts do not have to agree.
+// Errors other than contention over the lock are returned right away.
+// Returns the context, that is cancelled if the lock is lost, and the
+// function that releases the lock.
+func (app *App) lockMigrations(db *sql.DB, c *migrationsConfig) (stdctx.Context, func(), error) {
+    const elocked = "migrations are locked by %v since %v"
+    const insert = "INSERT INTO %s_lock (id, owner, acquired, heartbeat) VALUES (1, '%s', '%s', 0)"
+    const remove = "DELETE FROM %s_lock WHERE id = 1 AND owner = '%s' AND heartbeat = %d"
+    owner := strings.ReplaceAll(app.Reference, "'", "''")
+    deadline := time.Now().Add(c.LockTimeout) // give up
+    log := app.Journal.WithField("owner", app.Reference)
+    var vanished bool = false // lock row went away?
+    var observed *lockHolder = nil // as seen last
+    var changed time.Time // when it was seen changed
+    for { // keep trying until locked or deadline
+        moment := time.Now().UTC().Format(time.RFC3339)
+        _, err := db.Exec(fmt.Sprintf(insert, c.Table, owner, moment))
+        if err == nil { break } // the lock is taken by us
+        holder, found, qerr := migrationsLock(db, c.Table)
+        if qerr != nil { return nil, nil, qerr } // broken
+        if !found && vanished { return nil, nil, err } // not
+        if !found { vanished = true; continue } // retry
+        vanished = false // the lock is held by someone
+        if observed == nil || *observed != holder {
+            observed, changed = &holder, time.Now()
+        } else if time.Since(changed) > c.LockLease {
+            quoted := strings.ReplaceAll(holder.owner, "'", "''")
+            _, err := db.Exec(fmt.Sprintf(remove, c.Table, quoted, holder.beat))
+            if err != nil { return nil, nil, err } // cannot take over
+            log.WithField("stale", holder.owner).Warn("took over the stale migrations lock")
+            observed = nil; continue // take the lock again
+        } // lock is alive, wait until it is released
+        if time.Now().After(deadline) { // give up
+            return nil, nil, fmt.Errorf(elocked, holder.owner, holder.acquired)
+        } // lock is held by someone else, wait a bit
+        time.Sleep(time.Millisecond * 500) // retry
+    } // the lock has been taken, we are migrating
+    log.Info("took the migrations lock")
+    ctx, stop := app.heartbeatMigrations(db, c, owner)
+    return ctx, func() { // release the lock, if still ours
+        stop() // no more heartbeats, lock is released
+        const release = "DELETE FROM %s_lock WHERE id = 1 AND owner = '%s'"
+        _, err := db.Exec(fmt.Sprintf(release, c.Table, owner))
+        if err != nil { log.WithError(err).Warn("failed to unlock") }
+    }, nil // lock is held until released
+}
+
+// Holder of the migration lock, as it is recorded in the lock table:
+// the owner, being the Reference of the app instance; the instant when
+// the lock has been acquired, as written by the holder; and the counter
+// of heartbeats. The counter is compared only for equality, so that the
+// staleness does not depend on the clocks of the hosts agreeing.
+type lockHolder struct {
+    owner string // Reference of the app instance
+    acquired string // when the lock was taken
+    beat int64 // counter of the heartbeats
+}
+
+// Read the holder of the migration lock from the lock table. Returns
+// false if the lock is not held by anyone, that is there is no lock
+// row; and an error if the lock table could not be read at all. Please
+// see the lockHolder struct for the details on the recorded fields.
+// This is used by the lockMigrations to tell contention from failure.
+func migrationsLock(db *sql.DB, table string) (lockHolder, bool, error) {
+    const query = "SELECT owner, acquired, heartbeat FROM %s_lock WHERE id = 1"
+    var owner, acquired sql.NullString // columns
+    var beat sql.NullInt64 // counter of heartbeats
+    row := db.QueryRow(fmt.Sprintf(query, table))
+    err := row.Scan(&owner, &acquired, &beat) // read
+    if err == sql.ErrNoRows { return lockHolder {}, false, nil }
+    if err != nil { return lockHolder {}, false, err }
+    return lockHolder { owner.String, acquired.String, beat.Int64 }, true, nil
+}
+
+// Keep renewing the lease of the migration lock, by incrementing the
+// heartbeat counter of the lock row, three times per lease; so others
+// do not consider the lock to be stale while migrations are running.
+// The returned context is cancelled once the lock is lost: either it
+// has been taken over, or it could not be renewed for most of a lease.
+// Returns also the function that stops the heartbeats and waits.
+func (app *App) heartbeatMigrations(db *sql.DB, c *migrationsConfig, owner string) (stdctx.Context, func()) {
+    const update = "UPDATE %s_lock SET heartbeat = %d WHERE id = 1 AND owner = '%s'"
+    const elost = "lost the migrations lock to another instance"
+    const eexpired = "could not renew the migrations lock in time"
+    log := app.Journal.WithField("owner", app.Reference)
+    ctx, cancel := stdctx.WithCancelCause(stdctx.Background())
+    stop, done := make(chan struct {}), make(chan struct {})
+    every := c.LockLease / 3 // renew the lease
+    if every < time.Millisecond { every = time.Millisecond }
+    ticker := time.NewTicker(every) // renew it
+    go func() { // this runs in the background
+        defer close(done); defer ticker.Stop() // clean up
+        var beat int64 = 0 // heartbeats made so far
+        var renewed time.Time = time.Now() // last one
+        for { select { // either tick or stopped
+            case <- stop: return // lock is released
+            case <- ticker.C: // renew the lease
+                beat++ // others watch it to change
+                result, err := db.Exec(fmt.Sprintf(update, c.Table, beat, owner))
+                var n int64 = 1 // assume it is renewed
+                if err == nil { n, err = result.RowsAffected() }
+                if err == nil && n == 0 { // taken over
+                    log.Error(elost); cancel(errors.New(elost))
+                    return // no point in renewing it
+                } else if err == nil { renewed = time.Now(); continue }
+                log.WithError(err).Warn("failed to renew migrations lock")
+                if time.Since(renewed) < c.LockLease - every { continue }
+                log.Error(eexpired); cancel(errors.New(eexpired))
+                return // others may take it over by now
+        }}
+    }() // heartbeats go on, until the lock is released
+    return ctx, func() { close(stop); <- done; cancel(nil) }
+}
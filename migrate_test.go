@@ -0,0 +1,275 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "os"
+import "time"
+import "strings"
+import "testing"
+import "database/sql"
+import "path/filepath"
+import "fmt"
+
+import "github.com/pelletier/go-toml"
+
+// Make the application with the migrations configured against the fake
+// database, that is opened and stored as if the app has been booted;
+// the migrations are loaded from the directory, if it is not empty.
+func migratingApp(t *testing.T, dsn, directory string) *App {
+    app := quietApp() // with the database ready to open
+    app.openDatabase(fakeConfig("main", dsn))
+    app.RootDirectory = directory // where files are
+    app.migrator = &migrationsConfig { Intent: "main" }
+    app.migrator.Directory = "." // root directory
+    app.migrator.Table = "schema_migrations"
+    app.migrator.LockTimeout = time.Second // short
+    app.migrator.LockLease = time.Minute // default
+    t.Cleanup(func() { app.closeDatabase(fakeConfig("main", dsn)) })
+    return app
+}
+
+// Write the migration files into the temporary directory; the keys are
+// the file names and the values are the contents of the files.
+func migrationFiles(t *testing.T, files map[string] string) string {
+    directory := t.TempDir() // removed after the test
+    for name, content := range files { // write them all
+        full := filepath.Join(directory, name) // in there
+        err := os.WriteFile(full, []byte(content), 0644)
+        if err != nil { t.Fatalf("cannot write %v: %v", name, err) }
+    } // all the migration files have been written
+    return directory
+}
+
+func TestMigrationsConfig(t *testing.T) {
+    var cases = []struct {
+        section string // the app.migrations config section
+        message string // empty if it must be accepted
+    } {
+        { `intent = "main"`, "" },
+        { "intent = \"main\"\nlock-lease = \"1s\"", "" },
+        { "intent = \"main\"\nlock-lease = \"1ns\"", "app.migrations.lock-lease: must be at least 1s" },
+        { "intent = \"main\"\ntable = \"drop table\"", "app.migrations.table: must match" },
+    } // sections and the violations they make
+    for _, c := range cases { // decode every section
+        tree, err := toml.Load("[app.migrations]\n" + c.section)
+        if err != nil { t.Fatalf("cannot load config: %v", err) }
+        var config migrationsConfig // app.migrations
+        err = decodeConfig(tree, "app.migrations", &config)
+        var message string // empty if accepted
+        if err != nil { message = err.Error() }
+        if (c.message == "") != (message == "") || !strings.Contains(message, c.message) {
+            t.Errorf("section %q got %q, want %q", c.section, message, c.message)
+        } // the outcome is as expected
+        if err == nil && config.LockLease != time.Minute && c.section == `intent = "main"` {
+            t.Errorf("default lease is %v, want 1m", config.LockLease)
+        } // the default lease is applied
+    }
+}
+
+func TestMigrationPlan(t *testing.T) {
+    directory := migrationFiles(t, map[string] string {
+        "20150102_create_users.up.sql": "CREATE TABLE users (id INTEGER PRIMARY KEY)",
+        "20150102_create_users.down.sql": "DROP TABLE users",
+        "20150101_create_posts.up.sql": "CREATE TABLE posts (id INTEGER PRIMARY KEY)",
+        "README.md": "not a migration", "1_odd.sql": "not a migration either",
+    }) // files with two migrations and the noise
+    app := migratingApp(t, t.Name(), directory)
+    app.Migration(func(m *Migration) { // in Go
+        m.Version, m.Name = 20150103, "seed_users"
+        m.Up = func(*sql.Tx) error { return nil }
+    }) // declared in between of the SQL ones
+    plan, err := app.migrationPlan(app.migrator)
+    if err != nil { t.Fatalf("cannot plan: %v", err) }
+    var got []string // versions and names, in order
+    for _, m := range plan { got = append(got, fmt.Sprintf("%d_%s", m.Version, m.Name)) }
+    want := "20150101_create_posts 20150102_create_users 20150103_seed_users"
+    if strings.Join(got, " ") != want { t.Fatalf("plan is %v, want %v", got, want) }
+    if plan[0].Down != nil { t.Error("migration without down file got down") }
+    if plan[1].Up == nil || plan[1].Down == nil { t.Error("migration pair is incomplete") }
+}
+
+func TestMigrationPlanErrors(t *testing.T) {
+    up := func(*sql.Tx) error { return nil }
+    var cases = []struct {
+        name string // of the case
+        files map[string] string // migration files
+        version int64 // of the Go migration, if any
+        message string // expected within the error
+    } {
+        { "missing up", map[string] string { "1_a.down.sql": "" }, 0, "has no up file" },
+        { "files clash", map[string] string { "1_a.up.sql": "", "1_b.up.sql": "" }, 0, "declared twice" },
+        { "go clashes", map[string] string { "1_a.up.sql": "" }, 1, "declared twice" },
+    } // all of them must fail to plan
+    for _, c := range cases { t.Run(c.name, func(t *testing.T) {
+        app := migratingApp(t, t.Name(), migrationFiles(t, c.files))
+        if c.version > 0 { app.Migration(func(m *Migration) { m.Version, m.Up = c.version, up }) }
+        _, err := app.migrationPlan(app.migrator) // must fail
+        if err == nil || !strings.Contains(err.Error(), c.message) {
+            t.Errorf("got error %v, want it to contain %q", err, c.message)
+        } // the error is the expected one
+    }) }
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+    directory := migrationFiles(t, map[string] string {
+        "1_create_users.up.sql": "CREATE TABLE users (id INTEGER PRIMARY KEY)",
+        "1_create_users.down.sql": "DROP TABLE users",
+        "2_seed_users.up.sql": "INSERT INTO users (id) VALUES (1)",
+        "2_seed_users.down.sql": "DELETE FROM users",
+    }) // two migrations, one depends on the other
+    app := migratingApp(t, t.Name(), directory)
+    if err := app.MigrateUp(); err != nil { t.Fatalf("cannot migrate up: %v", err) }
+    if rows := fakeDB(t.Name()).rows("users"); len(rows) != 1 { t.Errorf("users are %v, want one", rows) }
+    if err := app.MigrateUp(); err != nil { t.Fatalf("cannot migrate up again: %v", err) }
+    records, err := app.MigrationStatus() // both applied
+    if err != nil || len(records) != 2 { t.Fatalf("status is %v, %v", records, err) }
+    for _, r := range records { if !r.Applied || !r.Known { t.Errorf("record %+v is not applied", r) } }
+    if err := app.MigrateDown(1); err != nil { t.Fatalf("cannot migrate down: %v", err) }
+    records, _ = app.MigrationStatus() // latest is reverted
+    if !records[0].Applied || records[1].Applied { t.Errorf("status is %+v after down", records) }
+    if rows := fakeDB(t.Name()).rows("users"); len(rows) != 0 { t.Errorf("users are %v, want none", rows) }
+    if rows := fakeDB(t.Name()).rows("schema_migrations_lock"); len(rows) != 0 { t.Errorf("lock is not released: %v", rows) }
+}
+
+func TestMigrateUpFailure(t *testing.T) {
+    directory := migrationFiles(t, map[string] string {
+        "1_create_users.up.sql": "CREATE TABLE users (id INTEGER PRIMARY KEY)",
+        "2_broken.up.sql": "INSERT INTO missing (id) VALUES (1)",
+    }) // the second one must fail and roll back
+    app := migratingApp(t, t.Name(), directory)
+    err := app.MigrateUp() // stops at the broken one
+    if err == nil || !strings.Contains(err.Error(), "migration 2 broken failed") {
+        t.Fatalf("got error %v, want the broken migration", err)
+    } // only the first migration is recorded
+    records, _ := app.MigrationStatus() // check it
+    if !records[0].Applied || records[1].Applied { t.Errorf("status is %+v after failure", records) }
+}
+
+func TestLockMigrationsContention(t *testing.T) {
+    first := migratingApp(t, t.Name(), t.TempDir())
+    second := migratingApp(t, t.Name(), t.TempDir())
+    second.migrator.LockTimeout = time.Millisecond
+    db, c, _ := first.migrationDatabase() // shared one
+    createMigrationsTable(db, c.Table) // lock table
+    _, release, err := first.lockMigrations(db, c)
+    if err != nil { t.Fatalf("cannot take the lock: %v", err) }
+    _, _, err = second.lockMigrations(db, second.migrator)
+    if err == nil || !strings.Contains(err.Error(), "locked by " + first.Reference) {
+        t.Fatalf("got error %v, want the lock to be held", err)
+    } // lock is held by the first, until released
+    release() // now the second instance could take it
+    _, release, err = second.lockMigrations(db, second.migrator)
+    if err != nil { t.Fatalf("cannot take the released lock: %v", err) }
+    release() // let others take the lock
+}
+
+func TestLockMigrationsStale(t *testing.T) {
+    app := migratingApp(t, t.Name(), t.TempDir())
+    db, c, _ := app.migrationDatabase() // shared one
+    createMigrationsTable(db, c.Table) // lock table
+    c.LockLease = time.Millisecond * 50 // short one
+    const insert = "INSERT INTO %s_lock (id, owner, acquired, heartbeat) VALUES (1, 'crashed', '%s', 7)"
+    future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+    db.Exec(fmt.Sprintf(insert, c.Table, future)) // left behind
+    _, release, err := app.lockMigrations(db, c) // takes over
+    if err != nil { t.Fatalf("cannot take over the stale lock: %v", err) }
+    defer release() // let others take the lock
+    holder, found, _ := migrationsLock(db, c.Table)
+    if !found || holder.owner != app.Reference { t.Errorf("lock is held by %+v", holder) }
+}
+
+func TestLockMigrationsAlive(t *testing.T) {
+    first := migratingApp(t, t.Name(), t.TempDir())
+    second := migratingApp(t, t.Name(), t.TempDir())
+    first.migrator.LockLease = time.Millisecond * 30
+    second.migrator.LockLease = time.Millisecond * 30
+    second.migrator.LockTimeout = time.Millisecond * 1200
+    db, c, _ := first.migrationDatabase() // shared one
+    createMigrationsTable(db, c.Table) // lock table
+    _, release, err := first.lockMigrations(db, c)
+    if err != nil { t.Fatalf("cannot take the lock: %v", err) }
+    defer release() // let others take the lock
+    _, _, err = second.lockMigrations(db, second.migrator)
+    if err == nil || !strings.Contains(err.Error(), "locked by " + first.Reference) {
+        t.Fatalf("got error %v, want the live lock to be kept", err)
+    } // the heartbeats keep the lock from going stale
+}
+
+func TestLockMigrationsFailure(t *testing.T) {
+    app := migratingApp(t, t.Name(), t.TempDir())
+    db, c, _ := app.migrationDatabase() // shared one
+    createMigrationsTable(db, c.Table) // lock table
+    c.LockTimeout = time.Minute // would wait for long
+    fakeDB(t.Name()).failing("^INSERT") // broken database
+    started := time.Now() // must not wait for the timeout
+    _, _, err := app.lockMigrations(db, c) // fails right away
+    if err == nil || !strings.Contains(err.Error(), "disk I/O error") {
+        t.Fatalf("got error %v, want the insert error", err)
+    } // the failure is not mistaken for contention
+    if elapsed := time.Since(started); elapsed > time.Second * 5 {
+        t.Errorf("failure has been retried for %v", elapsed)
+    } // returned without waiting for the lock
+}
+
+func TestLockMigrationsHeartbeat(t *testing.T) {
+    app := migratingApp(t, t.Name(), t.TempDir())
+    db, c, _ := app.migrationDatabase() // shared one
+    createMigrationsTable(db, c.Table) // lock table
+    c.LockLease = time.Millisecond * 30 // renewed often
+    ctx, release, err := app.lockMigrations(db, c)
+    if err != nil { t.Fatalf("cannot take the lock: %v", err) }
+    defer release() // let others take the lock
+    before, _, _ := migrationsLock(db, c.Table)
+    time.Sleep(c.LockLease * 3) // few heartbeats
+    after, _, _ := migrationsLock(db, c.Table)
+    if after.beat <= before.beat { t.Errorf("heartbeat is not renewed: %v", after.beat) }
+    if ctx.Err() != nil { t.Errorf("lock is lost: %v", ctx.Err()) }
+}
+
+func TestMigrateUpLostLock(t *testing.T) {
+    app := migratingApp(t, t.Name(), t.TempDir())
+    app.migrator.LockLease = time.Millisecond * 30
+    db, c, _ := app.migrationDatabase() // shared one
+    var second bool = false // has it been run?
+    app.Migration(func(m *Migration) { // taken over
+        m.Version, m.Name = 1, "taken_over"
+        m.Up = func(*sql.Tx) error { // lose the lock
+            db.Exec("DELETE FROM " + c.Table + "_lock")
+            time.Sleep(c.LockLease * 2); return nil
+        } // another instance takes the lock meanwhile
+    }) // the first migration loses the lock
+    app.Migration(func(m *Migration) { // must not run
+        m.Version, m.Name = 2, "after_lost"
+        m.Up = func(*sql.Tx) error { second = true; return nil }
+    }) // the second migration is not started
+    err := app.MigrateUp() // stops once the lock is lost
+    if err == nil || !strings.Contains(err.Error(), "lost the migrations lock") {
+        t.Fatalf("got error %v, want the lock to be lost", err)
+    } // migrating has stopped right away
+    if second { t.Error("migration has been run without the lock") }
+    if applied, _ := appliedMigrations(db, c.Table); len(applied) != 0 {
+        t.Errorf("migrations are recorded without the lock: %v", applied)
+    } // the interrupted migration is rolled back
+}
@@ -0,0 +1,385 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "regexp"
+import "strings"
+import "strconv"
+
+// Version of the OpenAPI specification that the documents generated
+// by the App.OpenAPI method do conform to. The generated document only
+// uses a small subset of the specification: paths, operations, their
+// parameters, request bodies, responses and the security schemes; so
+// it should be consumable by the most of the tools out there.
+const OpenAPIVersion = "3.0.3"
+
+// Schema is a loosely typed JSON Schema object, as it is embedded into
+// the OpenAPI document. It is deliberately kept as a plain map, since
+// the schemas could come from the application code in any shape; for
+// example, via the Endpoint.Schema field. Please refer to the OpenAPI
+// specification for the details on the supported schema keywords.
+type Schema map[string] interface {}
+
+// Pattern of the characters of the path that are not allowed within
+// the operation identifiers; the runs of them are replaced with the
+// underscore, when the identifier is derived from the path template.
+var operationClean = regexp.MustCompile("[^a-zA-Z0-9]+")
+
+// Root of the OpenAPI document, as generated by the App.OpenAPI method.
+// It describes every endpoint of every service that is installed within
+// the app, exactly as they are mounted into the HTTP request routers.
+// Paths are keyed by the OpenAPI path template, where the placeholders
+// of the router are converted into the {name} parameters.
+type OpenAPI struct {
+    OpenAPI string `json:"openapi"` // spec version
+    Info OpenAPIInfo `json:"info"` // app identity
+    Paths map[string] map[string] *OpenAPIOperation `json:"paths"`
+    Components OpenAPIComponents `json:"components"`
+}
+
+// Information about the application that the document describes; the
+// title is the application name and the version is the application
+// version. Description, if any, is taken from the app.openapi config
+// section. Please refer to the OpenAPI specification for the details
+// on how these fields are interpreted by the consuming tools.
+type OpenAPIInfo struct {
+    Title string `json:"title"` // application name
+    Version string `json:"version"` // semver
+    Description string `json:"description,omitempty"`
+}
+
+// Description of a single operation of the document; that is, of one
+// endpoint for one of its HTTP methods. Operation identifier is built
+// out of the method and the path, so it is unique within the document.
+// Tags carry the prefix of the service, so that the tools could group
+// the operations by the services they belong to.
+type OpenAPIOperation struct {
+    OperationID string `json:"operationId"` // unique
+    Summary string `json:"summary,omitempty"` // desc
+    Tags []string `json:"tags,omitempty"` // service
+    Parameters []OpenAPIParameter `json:"parameters,omitempty"`
+    RequestBody *OpenAPIBody `json:"requestBody,omitempty"`
+    Responses map[string] *OpenAPIResponse `json:"responses"`
+    Security []map[string] []string `json:"security,omitempty"`
+}
+
+// Description of a parameter of the operation, that is passed either
+// in the path or in the query string. Path parameters are always the
+// required ones, as demanded by the OpenAPI specification. Schema of
+// the parameter is derived from the type of the corresponding field
+// of the Input struct, or defaults to a string, if there is none.
+type OpenAPIParameter struct {
+    Name string `json:"name"` // parameter name
+    In string `json:"in"` // path or query
+    Required bool `json:"required"` // mandatory?
+    Description string `json:"description,omitempty"`
+    Schema Schema `json:"schema"` // type & rules
+}
+
+// Description of a request body or a response of the operation. Every
+// body is described by its content, which maps the MIME type onto the
+// schema of the data. Request bodies are always JSON in the generated
+// documents; responses may be described for the other MIME types that
+// are registered within the App.Encoders as well.
+type OpenAPIBody struct {
+    Required bool `json:"required,omitempty"` // body?
+    Content map[string] OpenAPIMedia `json:"content"`
+}
+
+// Description of a response of the operation; it is an OpenAPI body
+// that also carries a mandatory description. The generated documents
+// describe the successful response generically, as well as all of the
+// error responses, which have the shape of the ErrorEnvelope. Please
+// refer to the Watchdog supervisor for the status codes.
+type OpenAPIResponse struct {
+    Description string `json:"description"` // human
+    Headers map[string] OpenAPIHeader `json:"headers,omitempty"`
+    Content map[string] OpenAPIMedia `json:"content,omitempty"`
+}
+
+// Header of the response, as it is described in the OpenAPI document;
+// such as the Allow header of the 405 response or the WWW-Authenticate
+// header of the 401 response, that are sent by the Watchdog. Please
+// refer to the OpenAPI specification for the details on the header.
+type OpenAPIHeader struct {
+    Description string `json:"description"` // human
+    Schema Schema `json:"schema"` // of the value
+}
+
+// Media type object of the OpenAPI document, that holds the schema of
+// a request or response body, as it is encoded with a specific MIME
+// type. This is used by both the request bodies and the responses of
+// operations. Please refer to the OpenAPI specification for details
+// on the media type object and what else it could possibly contain.
+type OpenAPIMedia struct {
+    Schema Schema `json:"schema"` // of the body
+}
+
+// Reusable components of the document: the schemas that operations
+// refer to, such as the error envelope; and the security schemes, such
+// as the bearer token, that is used by the endpoints that declare the
+// Scopes or Roles. Please refer to OpenAPI specification for details.
+// Both of the maps are always present, but could be empty.
+type OpenAPIComponents struct {
+    Schemas map[string] Schema `json:"schemas"`
+    SecuritySchemes map[string] Schema `json:"securitySchemes"`
+}
+
+// Generate the OpenAPI document that describes every endpoint of every
+// service installed within the app, exactly as the endpoints are being
+// mounted into the HTTP request routers. Path templates are derived of
+// the service prefix and endpoint pattern; parameters and request body
+// are derived from the Input of the endpoint, unless Schema is given.
+func (app *App) OpenAPI() *OpenAPI {
+    document := &OpenAPI { OpenAPI: OpenAPIVersion }
+    document.Info.Title = app.Name // application name
+    document.Info.Version = app.Version.String() // semver
+    if tree := app.CurrentConfig(); tree != nil {
+        about := tree.Get("app.openapi.description")
+        document.Info.Description, _ = about.(string)
+    } // description is set, if it is configured
+    document.Paths = make(map[string] map[string] *OpenAPIOperation)
+    document.Components.Schemas = map[string] Schema {
+        "Error": errorSchema(), // the error envelope
+    } // schemas that are referred by operations
+    document.Components.SecuritySchemes = make(map[string] Schema)
+    identifiers := make(map[string] bool) // all taken
+    for _, srv := range app.Services { // walk all
+        for _, ep := range srv.Endpoints { // walk
+            path := openapiPath(srv.mountPoint(ep))
+            item := document.Paths[path] // existing?
+            if item == nil { item = make(map[string] *OpenAPIOperation) }
+            for method, ok := range ep.Methods { // verbs
+                if !ok { continue } // method is disabled
+                verb := strings.ToLower(method) // OpenAPI
+                operation := app.openapiOperation(srv, ep, verb, path)
+                var base string = operation.OperationID // as derived
+                for n := 2; identifiers[operation.OperationID]; n++ {
+                    operation.OperationID = base + "_" + strconv.Itoa(n)
+                } // paths like /a-b and /a_b get distinct ones
+                identifiers[operation.OperationID] = true
+                item[verb] = operation // described it
+            } // all the methods have been described
+            document.Paths[path] = item // path is done
+            if len(ep.Scopes) == 0 && len(ep.Roles) == 0 { continue }
+            document.Components.SecuritySchemes["bearer"] = Schema {
+                "type": "http", "scheme": "bearer", "bearerFormat": "JWT",
+            } // endpoint requires the JSON Web Token
+        } // all the endpoints have been described
+    } // all the services have been described
+    return document // document is ready to use
+}
+
+// Describe an endpoint for one of its HTTP methods, as an operation of
+// the OpenAPI document. Path parameters are taken from the placeholders
+// of the pattern, merged with the parameters from the endpoint Input,
+// which also provide the query parameters and the request body. The
+// responses include the error responses the Watchdog may produce.
+func (app *App) openapiOperation(srv *Service, ep *Endpoint, verb, path string) *OpenAPIOperation {
+    identifier := operationClean.ReplaceAllString(path, "_")
+    identifier = verb + "_" + strings.Trim(identifier, "_")
+    operation := &OpenAPIOperation { OperationID: identifier }
+    operation.Summary = ep.Description // as is
+    operation.Tags = []string { srv.Prefix } // group
+    operation.Responses = app.openapiResponses(ep)
+    if len(ep.Scopes) > 0 || len(ep.Roles) > 0 { // JWT
+        scopes := append([]string {}, ep.Scopes...) // own
+        operation.Security = []map[string] []string {
+            { "bearer": scopes }, // bearer token, scoped
+        } // operation requires an authenticated user
+    } // security requirements have been described
+    fields := make(map[string] InputField) // by name
+    for _, f := range ep.inputFields() { fields[f.Name] = f }
+    for _, name := range placeholders(ep.Pattern) {
+        parameter := OpenAPIParameter { Name: name }
+        parameter.In = "path"; parameter.Required = true
+        parameter.Schema = Schema { "type": "string" }
+        if f, ok := fields[name]; ok { parameter.Schema = fieldSchema(f) }
+        delete(fields, name) // do not describe it twice
+        operation.Parameters = append(operation.Parameters, parameter)
+    } // all the path parameters have been described
+    body := Schema { "type": "object" } // JSON body
+    properties := make(map[string] Schema) // fields
+    required := make([]string, 0) // mandatory fields
+    for _, f := range ep.inputFields() { // in order
+        if _, ok := fields[f.Name]; !ok { continue } // path
+        if f.In == "body" { // goes in the request body
+            properties[f.Name] = fieldSchema(f) // field
+            if f.Required { required = append(required, f.Name) }
+            continue // body property, not a parameter
+        } // this is a query or header parameter
+        parameter := OpenAPIParameter { Name: f.Name, In: f.In }
+        parameter.Required = f.Required // mandatory?
+        parameter.Schema = fieldSchema(f) // type & rules
+        operation.Parameters = append(operation.Parameters, parameter)
+    } // all the input fields have been described
+    if len(properties) > 0 { body["properties"] = properties }
+    if len(required) > 0 { body["required"] = required }
+    if ep.Schema != nil || len(properties) > 0 { // body?
+        var schema Schema = body // derived from Input
+        if ep.Schema != nil { schema = Schema { "allOf":
+            []interface {} { ep.Schema } } } // as is
+        operation.RequestBody = &OpenAPIBody { Required: true }
+        operation.RequestBody.Content = map[string] OpenAPIMedia {
+            MimeJSON: { Schema: schema }, // JSON only
+        } // request body has been described
+    } // request body is described, if there is one
+    return operation // operation is described
+}
+
+// Describe the responses of the endpoint: the successful one, that
+// could be encoded with any of the registered encoders; and the error
+// ones, that are produced by the Watchdog supervisor and have a shape
+// of the ErrorEnvelope. Any request could be rejected for its body, or
+// for the method not allowed on the path; and the endpoints that need
+// scopes or roles could be denied access, with the bearer challenge.
+func (app *App) openapiResponses(ep *Endpoint) map[string] *OpenAPIResponse {
+    reference := Schema { "$ref": "#/components/schemas/Error" }
+    failure := func(description string) *OpenAPIResponse {
+        response := &OpenAPIResponse { Description: description }
+        response.Content = make(map[string] OpenAPIMedia)
+        for kind := range app.Encoders { // any encoder
+            response.Content[kind] = OpenAPIMedia { reference }
+        } // error envelope could be encoded in any of them
+        return response // response is described
+    } // closure that describes an error response
+    header := func(r *OpenAPIResponse, name, description string) {
+        r.Headers = map[string] OpenAPIHeader { name: {
+            description, Schema { "type": "string" } } }
+    } // closure that describes the header of a response
+    responses := map[string] *OpenAPIResponse {
+        "200": { Description: "operation has succeeded" },
+        "400": failure("request body is malformed"),
+        "405": failure("request method is not allowed"),
+        "413": failure("request body is too large"),
+        "500": failure("operation has failed unexpectedly"),
+        "503": failure("operation is not available"),
+        "504": failure("operation has timed out"),
+    } // the responses that every endpoint may produce
+    header(responses["405"], "Allow", "methods allowed on the path")
+    if ep.Input != nil { // could be rejected as invalid
+        responses["400"].Description = "request body is malformed or input is not valid"
+    } // bad request is described, as it may happen
+    if len(ep.Scopes) > 0 || len(ep.Roles) > 0 { // JWT
+        responses["401"] = failure("bearer token is missing or not valid")
+        responses["403"] = failure("bearer token lacks the scopes or roles")
+        header(responses["401"], "WWW-Authenticate", "bearer challenge")
+        header(responses["403"], "WWW-Authenticate", "bearer challenge")
+    } // access denials are described, if they may happen
+    return responses // responses are described
+}
+
+// Convert the input field description into the JSON Schema, that is
+// embedded into the OpenAPI document. Type and format are taken as is;
+// the validation rules are mapped onto the corresponding keywords of
+// the JSON Schema: bounds become the minimum and maximum for numbers
+// or the length limits for strings and arrays; enum is split up. The
+// bounds of durations are not lengths, so they are not mapped at all.
+func fieldSchema(f InputField) Schema {
+    schema := Schema { "type": f.Type } // JSON type
+    if f.Format != "" { schema["format"] = f.Format }
+    bounds := map[string] [2]string { // keywords
+        "string": { "minLength", "maxLength" },
+        "array": { "minItems", "maxItems" },
+        "object": { "minProperties", "maxProperties" },
+    } // by type, default is for numbers
+    keywords, ok := bounds[f.Type] // length bounds?
+    if !ok { keywords = [2]string { "minimum", "maximum" } }
+    for i, rule := range []string { "min", "max" } {
+        arg, ok := f.Rules[rule] // is it declared?
+        if !ok || f.Format == "duration" { continue }
+        number, _ := strconv.ParseFloat(arg, 64)
+        schema[keywords[i]] = number // the bound
+    } // bounds have been mapped onto keywords
+    if p, ok := f.Rules["pattern"]; ok { schema["pattern"] = p }
+    if e, ok := f.Rules["enum"]; ok { schema["enum"] = strings.Split(e, "|") }
+    return schema // schema of the field
+}
+
+// Convert the URL pattern, as it is mounted into the request routers,
+// into the OpenAPI path template. Both the :name placeholders and the
+// *wildcard placeholders become {name} parameters; note that OpenAPI
+// has no notion of the wildcards matching multiple path segments. This
+// is used to key the paths of the generated OpenAPI document.
+func openapiPath(pattern string) string {
+    segments := strings.Split(pattern, "/") // split
+    for i, s := range segments { // find placeholders
+        if len(s) < 2 || (s[0] != ':' && s[0] != '*') { continue }
+        segments[i] = "{" + s[1:] + "}" // parameter
+    } // all the placeholders have been converted
+    return strings.Join(segments, "/")
+}
+
+// Extract the names of the placeholders from the URL pattern of the
+// endpoint, in the order they appear in the pattern. Both the :name
+// and the *wildcard placeholders are extracted. The names are exactly
+// the keys under which the matched values appear in the Context.Data.
+// Please refer to the router documentation for the pattern format.
+func placeholders(pattern string) []string {
+    names := make([]string, 0) // in order
+    for _, s := range strings.Split(pattern, "/") {
+        if len(s) < 2 || (s[0] != ':' && s[0] != '*') { continue }
+        names = append(names, s[1:]) // placeholder
+    } // all the placeholders have been extracted
+    return names // could be empty slice
+}
+
+// Build the JSON Schema of the ErrorEnvelope, which is the shape of
+// every error response produced by the framework. It is referred to by
+// the error responses of every operation in the generated document. It
+// must be kept in sync with the ErrorEnvelope and ErrorBody structs.
+// Details are omitted, as they are only exposed in debugging mode.
+func errorSchema() Schema {
+    field := Schema { "type": "object", "properties": Schema {
+        "field": Schema { "type": "string" },
+        "message": Schema { "type": "string" },
+    }} // the error of an individual input field
+    body := Schema { "type": "object", "properties": Schema {
+        "status": Schema { "type": "integer" },
+        "message": Schema { "type": "string" },
+        "reference": Schema { "type": "string" },
+        "fields": Schema { "type": "array", "items": field },
+    }} // description of the error itself
+    body["required"] = []string { "message", "reference", "status" }
+    return Schema { "type": "object", "required": []string { "error" },
+        "properties": Schema { "error": body }} // envelope
+}
+
+// Create and install a built-in service that exposes the OpenAPI doc
+// of the application over HTTP, under the specified prefix. Service
+// has a single endpoint, "openapi.json", that serves the document, as
+// it is generated by App.OpenAPI. The service is also mounted at boot
+// if the app.openapi.prefix config key is set; see the App.Boot.
+func (app *App) MountOpenAPI(prefix string) *Service {
+    return app.Service(func(srv *Service) {
+        srv.Prefix = prefix // mount point
+        srv.Endpoint(func(ep *Endpoint) {
+            ep.Pattern = "openapi.json" // document
+            ep.Description = "OpenAPI document"
+            ep.Business = func(c *Context) {
+                c.JSON(200, c.App.OpenAPI())
+            } // OpenAPI document has been served
+        }) // JSON endpoint has been mounted
+    })
+}
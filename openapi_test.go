@@ -0,0 +1,62 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "testing"
+
+func TestOpenAPIOperations(t *testing.T) {
+    app := New("openapi", "1.0.0") // bare application
+    app.Service(func(srv *Service) { // few endpoints
+        srv.Prefix = "/api" // all under the prefix
+        business := func(*Context) {} // does nothing
+        srv.Endpoint(func(ep *Endpoint) { ep.Pattern = "/a-b"; ep.Business = business })
+        srv.Endpoint(func(ep *Endpoint) { ep.Pattern = "/a_b"; ep.Business = business })
+        srv.Endpoint(func(ep *Endpoint) { // secured
+            ep.Pattern, ep.Scopes = "/secret", []string { "read" }
+            ep.Business = business // does nothing
+        }) // the endpoint that requires the token
+    }) // service is installed, but not mounted
+    document := app.OpenAPI() // describe the app
+    dashed := document.Paths["/api/a-b"]["get"]
+    underscored := document.Paths["/api/a_b"]["get"]
+    if dashed.OperationID == underscored.OperationID {
+        t.Errorf("operations share the identifier %v", dashed.OperationID)
+    } // identifiers of the operations are unique
+    for _, status := range []string { "400", "405", "413", "500", "503", "504" } {
+        if dashed.Responses[status] == nil { t.Errorf("response %v is not described", status) }
+    } // responses that any endpoint could produce
+    if _, ok := dashed.Responses["405"].Headers["Allow"]; !ok {
+        t.Error("response 405 does not describe the Allow header")
+    } // the allowed methods are advertised
+    if dashed.Responses["401"] != nil { t.Error("open endpoint describes 401") }
+    secret := document.Paths["/api/secret"]["get"] // secured
+    for _, status := range []string { "401", "403" } { // denials
+        response := secret.Responses[status] // described?
+        if response == nil { t.Errorf("response %v is not described", status); continue }
+        if _, ok := response.Headers["WWW-Authenticate"]; !ok {
+            t.Errorf("response %v does not describe the challenge", status)
+        } // the bearer challenge is advertised
+    }
+}
@@ -24,6 +24,7 @@
 package boot
 
 import "time"
+import "errors"
 
 // Seal up the pipeline and prepare for execution cycles. Current
 // implementation is responsible for building up the middleware chain.
@@ -33,15 +34,36 @@ import "time"
 func (pipe *Pipeline) Compile(app *App) {
     pipe.Compiled = time.Now() // mark
     pipe.App = app // remember application
+    pipe.meter = app.stats.meter(pipe) // stats
     pipe.onion = func (c *Context) { // prepare
+        started := pipe.meter.begin() // in-flight
         err := pipe.Operation.Apply(c) // run op
+        pipe.meter.end(started, err) // record it
+        c.Lock(); c.issue = err; c.Unlock() // keep
         if err != nil { // operation ended with error
             var op Operation = pipe.Operation // shortcut
             var sv Supervisor = app.Supervisor // shortcut
-            switch err { // switch on the application error value
-                case OperationUnavailable: sv.OperationUnavailable(c, op)
-                case OperationTimeout: sv.OperationTimeout(c, op)
-                default: sv.OperationPaniced(c, op, err)
+            var source SourceLocation = op.Definition()
+            elog := c.Journal.WithError(err) // log it
+            elog = elog.WithField("operation", op) // op
+            elog = elog.WithField("source", source) // code
+            var invalid *BindingError // rejected input
+            seal := func() { // logic may still be writing
+                if _, ok := op.(*Endpoint); ok { c.seal() }
+            } // only the supervisor could respond then
+            switch { // switch on the application error value
+                case err == OperationUnavailable: sv.OperationUnavailable(c, op)
+                case err == OperationTimeout: seal(); sv.OperationTimeout(c, op)
+                case err == OperationCancelled: seal(); elog.Warn("operation cancelled")
+                case errors.As(err, &invalid): // rejected
+                    is, ok := sv.(InputSupervisor) // optional
+                    if !ok { is = &Watchdog {} } // fallback
+                    is.OperationInvalid(c, op, invalid)
+                default: // operation has paniced, report it
+                    seal() // the response is up to supervisor
+                    var pe *PanicError // crash report
+                    if errors.As(err, &pe) { app.reportCrash(pe) }
+                    sv.OperationPaniced(c, op, err)
             } // we have dispatched the error value
             pipe.Operation.ResolveIssue(c, err)
         } // operation application has finished
@@ -66,7 +88,11 @@ func (pipe *Pipeline) Compile(app *App) {
 // the operation itself, such as - middleware and/or other utilities.
 // See the implementation code for more information. Also, please take
 // a look at the Apply method of the Operation interface definition.
-func (pipe *Pipeline) Run(context *Context) { pipe.onion(context) }
+func (pipe *Pipeline) Run(context *Context) {
+    pipe.App.running.Add(1) // pipeline is running
+    defer pipe.App.running.Done() // pipeline done
+    pipe.onion(context) // run the compiled onion
+}
 
 // Pipeline is a structure that wraps an operation with all required
 // pieces of data and implementation to properly run it. It Basically
@@ -89,6 +115,13 @@ type Pipeline struct {
     // for more information on initializing and using the field
     onion BiasedLogic
 
+    // Meter of the operation that this pipeline is running. It is used
+    // to record the statistics of every operation application, such as
+    // its latency, outcome and the number of in-flight applications.
+    // It is obtained from the application statistics subsystem when
+    // the pipeline is being compiled. See the statistics struct.
+    meter *meter
+
     // Holds a pointer to an operation that this pipeline is intended
     // to execute. An operation is something that contains a piece of
     // application's business logic and knows how to invoke it. A pipe
@@ -24,6 +24,79 @@
 package boot
 
 import "time"
+import "strings"
+import "sync/atomic"
+import "fmt"
+
+// Order the providers installed within the application, so that any
+// provider comes after all the providers it depends on; also known as
+// the topological sort. Relative order of the independent providers is
+// preserved as they were declared. Returns an error if a dependency is
+// missing or if there is a dependency cycle amongst the providers.
+func (app *App) sortProviders() ([]*Provider, error) {
+    const emissing = "provider %v depends on missing %v"
+    const ecycle = "providers have a dependency cycle: %v"
+    const edup = "duplicate provider name %v"
+    named := make(map[string] *Provider) // by name
+    pending := make(map[*Provider] int) // in-degree
+    for _, p := range app.Providers { // index all
+        if len(p.Name) == 0 { continue } // unnamed
+        if named[p.Name] != nil { // not unique?
+            return nil, fmt.Errorf(edup, p.Name)
+        } // provider names must be unique
+        named[p.Name] = p // index by name
+    } // all named providers are indexed
+    for _, p := range app.Providers { // edges
+        for _, dep := range p.Depends { // walk
+            if named[dep] == nil { // not declared?
+                return nil, fmt.Errorf(emissing, p, dep)
+            } // dependency does exist, count it
+            pending[p]++ // one more to wait for
+        } // all the dependencies are counted
+    } // all in-degrees are calculated now
+    var sorted = make([]*Provider, 0, len(app.Providers))
+    var placed = make(map[*Provider] bool) // done
+    for len(sorted) < len(app.Providers) {
+        var progress bool = false // any placed?
+        for _, p := range app.Providers { // sweep
+            if placed[p] || pending[p] > 0 { continue }
+            placed[p] = true; progress = true // ok
+            sorted = append(sorted, p) // place it
+            for _, q := range app.Providers { // deps
+                for _, dep := range q.Depends { // walk
+                    if dep == p.Name { pending[q]-- }
+                } // dependants are now less pending
+            } // all of the dependants are updated
+        } // done with a sweep over providers
+        if !progress { break } // cycle detected
+    } // either all sorted or there is a cycle
+    if len(sorted) == len(app.Providers) { return sorted, nil }
+    var cycled = make([]string, 0) // leftovers
+    for _, p := range app.Providers { // collect
+        if !placed[p] { cycled = append(cycled, p.String()) }
+    } // all the providers in cycle are collected
+    return nil, fmt.Errorf(ecycle, strings.Join(cycled, ", "))
+}
+
+// String represenation of this provider, which is used mainly
+// for identification purposes when viewed by a human. The value
+// is not forced to be unique, but it should unambiguously state
+// the provider's identity that can be used by a developer to
+// trace it down right to its implementation or definition.
+func (p *Provider) String() string {
+    if len(p.Name) > 0 { return p.Name }
+    return p.About // unnamed provider
+}
+
+// Get the pointer to the most recent copy of the Config struct, as it
+// has been decoded at the boot or at the last reload of the config; of
+// the same type as the Config itself. The struct that it points to is
+// never modified once published, so it is safe to read concurrently
+// with the reloads. Returns the Config, if nothing is published yet.
+func (p *Provider) CurrentConfig() interface {} {
+    if c := p.published.Load(); c != nil { return c }
+    return p.Config // not decoded yet, or no config
+}
 
 // Provider is an entity that proviedes some sort of functionality
 // for the application. Good example of this is a provider that could
@@ -32,6 +105,20 @@ import "time"
 // via the application storage mechanism. Use the API to create one.
 type Provider struct {
 
+    // Name is a short string token that identifies the provider. It
+    // is used by other providers to declare a dependency on this one.
+    // It is advised to keep it machine & human readable: in a form of
+    // a slug - no spaces, all lower case, et cetera. Should be unique
+    // amongst all the providers that are installed within the app.
+    Name string
+
+    // Names of the providers that this provider depends upon. The
+    // framework guarantees that these providers will be setup prior
+    // to setting this one up, and will be cleaned up after this one
+    // is cleaned up. Dependency cycles are not allowed and will be
+    // reported when the application is being booted up.
+    Depends []string
+
     // Description of the provider; it should be a short and succinct
     // synopsis of what this provider does, as a human readable string.
     // Keep it short yet descriptive enough to understand a basic idea
@@ -60,6 +147,35 @@ type Provider struct {
     // If there is no cleanup function - nil value should be set.
     Cleanup UnbiasedLogic
 
+    // Dotted path of the config section that the provider consumes,
+    // such as app.databases. It is decoded into the Config struct, when
+    // the application is being booted, before any providers are set up;
+    // so that all the config errors of all providers and services are
+    // reported at once, rather than failing in the middle of the setup.
+    ConfigSection string
+
+    // Pointer to the struct that the config section of this provider
+    // should be decoded into; see the App.DecodeConfig for the details
+    // on the struct tags that are supported. The provider setup could
+    // then use the struct directly, without digging into the config.
+    // It is decoded in place once, at boot; reloads publish the fresh
+    // copies instead, see CurrentConfig. If there is no config that is
+    // consumed by the provider - set it to nil, it is optional.
+    Config interface {}
+
+    // Most recent copy of the Config struct, as it has been decoded
+    // at the boot or the last config reload. It is published by the
+    // framework atomically, as a whole; so readers never observe half
+    // updated struct. Please use the CurrentConfig method to read it.
+    published atomic.Value
+
+    // Optional function that is invoked every time the configuration
+    // of the application has been reloaded, with the previous and the
+    // current config trees; CurrentConfig returns the updated struct.
+    // It is only invoked if the provider has been set up, and it is
+    // used to apply the changes without restarting the application.
+    Reconfigure ReloadLogic
+
     // Instant in time when the provider was invoked. The nil value
     // should indicate that current provider instance has not yet been
     // invoked. This value is used internally by the framework in the
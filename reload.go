@@ -0,0 +1,196 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "os"
+import "time"
+import "errors"
+import "syscall"
+import "os/signal"
+import "path/filepath"
+import "fmt"
+
+import "github.com/pelletier/go-toml"
+import "github.com/Sirupsen/logrus"
+
+// Reload the configuration of the application from all of its layers,
+// validate it and switch the application over to it. Validation covers
+// the app.require checks, the journal level and the config sections
+// that are declared by the providers and services. If it fails, the
+// reload is rejected and the application keeps running on the current
+// config. Otherwise, the new config is published atomically (see the
+// CurrentConfig) and replaces the App.Config; declared config structs
+// are published, the journal level is applied and the Reconfigure
+// callbacks are invoked, in order.
+// Binding, memory limits, the access log and the JWT verifier are all
+// rebuilt from the new config as well. The rest of the keys, such as
+// app.servers, app.databases, app.migrations, app.grace-period and the
+// journal keys other than the level, take effect on restart only.
+func (app *App) Reload() (err error) {
+    app.reloading.Lock() // one reload at a time
+    defer app.reloading.Unlock() // allow the next
+    log := app.Journal.WithField("env", app.Env)
+    defer func() { // loading the config panics
+        if r := recover(); r != nil { // a bad config
+            e, ok := r.(error) // was it an error?
+            if !ok { e = fmt.Errorf("%v", r) }
+            err = e // reject, return the error
+        } // loading has not panicked, if we are here
+        if err == nil { return } // reloaded fine
+        log.WithError(err).Error("config reload rejected")
+    }() // never let a bad config disrupt the app
+    tree, sources := app.loadConfig(app.Env, "config")
+    level, err := app.configLevel(tree) // journal
+    if err != nil { return err } // malformed level
+    commit, failures := app.checkConfig(tree)
+    if len(failures) > 0 { return errors.Join(failures...) }
+    binding := app.loadBinding(tree) // panics if bad
+    limits := app.loadLimits(tree) // panics if bad
+    access := app.buildAccessLog(tree) // the last
+    var previous *toml.TomlTree = app.CurrentConfig()
+    app.current.Store(&configSnapshot { tree, sources })
+    app.Lock(); app.Config = tree; app.Unlock() // field
+    commit(false) // publish the declared structs
+    app.binding.Store(binding) // params binding
+    app.limits.Store(limits) // the memory limits
+    replaced := app.access.Swap(access) // old one
+    if replaced != nil { replaced.close() } // file
+    app.Delete(verifierKey) // rebuilt on demand
+    app.Journal.SetLevel(level) // apply it live
+    app.reconfigure(previous, tree) // callbacks
+    log.WithField("level", level).Info("config has been reloaded")
+    return nil // application uses the new config
+}
+
+// Determine the journal level that is configured in the config tree,
+// by the app.journal.level key. If the key is absent, the level that
+// has been requested when the application was booted is used instead.
+// Returns an error if the level is malformed. This is applied when the
+// application is being booted, and every time the config is reloaded.
+func (app *App) configLevel(tree *toml.TomlTree) (logrus.Level, error) {
+    const elevel = "invalid app.journal.level: %v"
+    switch value := tree.Get("app.journal.level").(type) {
+        case nil: return app.level, nil // as booted
+        case string: // parse it as the level name
+            level, err := logrus.ParseLevel(value)
+            if err != nil { return level, fmt.Errorf(elevel, value) }
+            return level, nil // configured level
+        default: return app.level, fmt.Errorf(elevel, value)
+    }
+}
+
+// Invoke the Reconfigure callbacks of the providers that have been set
+// up and of the services that are up, in that order; passing them the
+// previous and the current config trees. Callbacks are invoked one by
+// one, in the order of the installation; a panic in any callback is
+// journaled and does not prevent the rest of them from being invoked.
+func (app *App) reconfigure(previous, current *toml.TomlTree) {
+    invoke := func(log *logrus.Entry, logic ReloadLogic) {
+        defer func() { // callback must not crash app
+            r := recover(); if r == nil { return } // OK
+            log.WithField("panic", r).Error("failed to reconfigure")
+        }() // guard against panicking callbacks
+        logic(app, previous, current) // notify
+    } // closure that invokes a single callback
+    for _, p := range app.Providers { // set up?
+        if p.Invoked.IsZero() || p.Reconfigure == nil { continue }
+        invoke(app.Journal.WithField("provider", p), p.Reconfigure)
+    } // all the providers have been notified
+    for _, s := range app.Services { // is up?
+        if s.Erected.IsZero() || s.Reconfigure == nil { continue }
+        invoke(app.Journal.WithField("service", s), s.Reconfigure)
+    } // all the services have been notified
+}
+
+// Spawn the config watcher, that reloads the config whenever the app
+// receives the SIGHUP signal, or when any of the config files has been
+// modified. Files are polled for modification time, on the interval
+// that is configured by the app.reload-interval key; 2 seconds being
+// the default, while zero disables polling. Watcher stops once the
+// application has been shut down. See Deploy for the usage.
+func (app *App) watchConfig() {
+    const einterval = "invalid app.reload-interval"
+    var interval time.Duration = time.Second * 2
+    tree := app.CurrentConfig() // as it is now
+    if ri, ok := tree.Get("app.reload-interval").(string); ok {
+        parsed, err := time.ParseDuration(ri) // parse
+        if err != nil { panic(einterval) } // broken
+        interval = parsed // override the default
+    } // interval is either default or configured
+    hangup := make(chan os.Signal, 1) // reload
+    signal.Notify(hangup, syscall.SIGHUP) // watch
+    var tick <- chan time.Time // nil if not polling
+    var ticker *time.Ticker // polls config files
+    if interval > 0 { // polling is enabled
+        ticker = time.NewTicker(interval)
+        tick = ticker.C // poll on every tick
+    } // otherwise, reload on SIGHUP only
+    log := app.Journal.WithField("every", interval)
+    log.Info("spawn the config watcher")
+    stamps := app.configStamps() // as loaded
+    go func() { // this runs in the background
+        defer signal.Stop(hangup) // stop watching
+        if ticker != nil { defer ticker.Stop() }
+        for { select { // signal, tick or stopped
+            case <- app.lifetime.Done(): return // done
+            case <- hangup: // explicitly requested
+                app.Journal.Info("got SIGHUP, reload config")
+                stamps = app.configStamps(); app.Reload()
+            case <- tick: // check if files are modified
+                current := app.configStamps() // stat
+                if sameStamps(stamps, current) { continue }
+                app.Journal.Info("config modified, reload it")
+                stamps = current; app.Reload() // reload
+        }}
+    }() // watcher is running in the background
+}
+
+// Get the modification times of all the config files that make up the
+// layers of the config, keyed by their path. Files that do not exist
+// are recorded with the zero time, so that creating or removing any of
+// the optional layers is noticed as well. See the configLayers method
+// for the list of layers; these are polled by the config watcher.
+func (app *App) configStamps() map[string] time.Time {
+    stamps := make(map[string] time.Time) // by path
+    for _, layer := range app.configLayers(app.Env) {
+        var fileName string = fmt.Sprintf("%s.toml", layer)
+        path := filepath.Join(app.RootDirectory, "config", fileName)
+        info, err := os.Stat(path) // get the mtime
+        if err != nil { stamps[path] = time.Time {}; continue }
+        stamps[path] = info.ModTime() // last modified
+    } // all the layers have been stat-ed
+    return stamps // snapshot of modification times
+}
+
+// Compare two snapshots of the modification times of the config files.
+// Returns true if they are identical; that is, if none of the files has
+// been modified, created or removed between the snapshots were taken.
+// See the configStamps method for how the snapshots are being taken.
+func sameStamps(a, b map[string] time.Time) bool {
+    if len(a) != len(b) { return false } // changed
+    for path, stamp := range a { // compare each
+        if !stamp.Equal(b[path]) { return false }
+    } // all the modification times are equal
+    return true // nothing has been modified
+}
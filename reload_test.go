@@ -0,0 +1,51 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "os"
+import "testing"
+import "path/filepath"
+
+func TestReloadConfig(t *testing.T) {
+    app := quietApp() // as if it has been booted
+    app.RootDirectory, app.Env = t.TempDir(), "dev"
+    config := filepath.Join(app.RootDirectory, "config")
+    write := func(text string) { // the env config file
+        os.MkdirAll(config, 0755) // config directory
+        err := os.WriteFile(filepath.Join(config, "dev.toml"), []byte(text), 0644)
+        if err != nil { t.Fatalf("cannot write config: %v", err) }
+    } // writes the config file of the dev env
+    write("[app]\nanswer = 1\n") // the booted config
+    tree, sources := app.loadConfig(app.Env, "config")
+    app.current.Store(&configSnapshot { tree, sources })
+    app.Config = tree // as the Boot does it
+    write("[app]\nanswer = 42\n") // changed config
+    if err := app.Reload(); err != nil { t.Fatalf("cannot reload: %v", err) }
+    if v := app.Config.Get("app.answer"); v != int64(42) { t.Errorf("App.Config has %v", v) }
+    if app.CurrentConfig() != app.Config { t.Error("App.Config is not the current config") }
+    write("[app.journal]\nlevel = \"loud\"\n") // bad config
+    if err := app.Reload(); err == nil { t.Fatal("bad config has been reloaded") }
+    if v := app.Config.Get("app.answer"); v != int64(42) { t.Errorf("rejected App.Config has %v", v) }
+}
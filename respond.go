@@ -0,0 +1,390 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "io"
+import "bytes"
+import "mime"
+import "sort"
+import "strings"
+import "strconv"
+import "net/http"
+import "encoding/json"
+import "encoding/xml"
+import "errors"
+import "sync"
+
+import "github.com/vmihailenco/msgpack"
+
+// Encoder of the response bodies, that writes the value in some
+// specific format into the writer. Encoders are registered within the
+// App.Encoders map, keyed by the MIME type they produce; and are picked
+// by the content negotiation, according to the Accept header of the
+// request. See the Context.Render method for the usage of encoders.
+type Encoder func(w io.Writer, value interface {}) error
+
+// MIME types of the encoders that are registered within every app by
+// default. JSON is the default type, which is used when the client has
+// not expressed any preference or when it accepts any type. Any other
+// encoder could be registered within the App.Encoders map, or any of
+// the default ones could be replaced or removed from the map.
+const (
+    MimeJSON = "application/json" // the default one
+    MimeXML = "application/xml" // encoding/xml
+    MimeMsgpack = "application/msgpack" // MessagePack
+)
+
+// Encoder of the JSON with the standard library; it is registered by
+// default and is the last resort of the content negotiation, when the
+// application has removed the JSON encoder from the App.Encoders map.
+func jsonEncoder(w io.Writer, value interface {}) error {
+    return json.NewEncoder(w).Encode(value)
+}
+
+// Create the map of the encoders that are registered within every app
+// by default: JSON, XML and MessagePack. The MessagePack encoder uses
+// the json tags of the structs, so the same structs could be encoded in
+// either of these formats with the same field names. This is used by
+// the New function to set up the App.Encoders with the defaults.
+func defaultEncoders() map[string] Encoder {
+    return map[string] Encoder {
+        MimeJSON: jsonEncoder, // the standard library
+        MimeXML: func(w io.Writer, value interface {}) error {
+            return xml.NewEncoder(w).Encode(value)
+        }, // encode XML with the standard library
+        MimeMsgpack: func(w io.Writer, value interface {}) error {
+            encoder := msgpack.NewEncoder(w) // binary
+            return encoder.UseJSONTag(true).Encode(value)
+        }, // encode MessagePack, honor the json tags
+    }
+}
+
+// Pick the encoder for the response, according to the Accept header
+// of the request. Media ranges are ordered by their quality, with the
+// wildcards matching any registered encoder, preferring JSON. If there
+// is no acceptable encoder, the JSON is used anyway; it is better for
+// the client to get something rather than a bare 406 status code.
+func (app *App) negotiate(accept string) (string, Encoder) {
+    type ranged struct { mime string; q float64 }
+    ranges := make([]ranged, 0) // of Accept header
+    for _, part := range strings.Split(accept, ",") {
+        kind, ps, err := mime.ParseMediaType(part)
+        if err != nil { continue } // malformed range
+        q, err := strconv.ParseFloat(ps["q"], 64)
+        if err != nil { q = 1.0 } // default quality
+        if q > 0 { ranges = append(ranges, ranged { kind, q }) }
+    } // all the acceptable media ranges are parsed
+    sort.SliceStable(ranges, func(i, j int) bool {
+        return ranges[i].q > ranges[j].q // best first
+    }) // media ranges are ordered by their quality
+    for _, r := range ranges { // find acceptable
+        if e, ok := app.Encoders[r.mime]; ok { return r.mime, e }
+        major := strings.TrimSuffix(r.mime, "*") // wildcard?
+        if major == r.mime { continue } // exact, not found
+        if r.mime == "*/*" { major = "" } // matches any
+        if e, ok := app.Encoders[MimeJSON]; ok &&
+            strings.HasPrefix(MimeJSON, major) { return MimeJSON, e }
+        keys := make([]string, 0, len(app.Encoders))
+        for k := range app.Encoders { keys = append(keys, k) }
+        sort.Strings(keys) // have a stable order for picking
+        for _, k := range keys { // any matching encoder
+            if strings.HasPrefix(k, major) { return k, app.Encoders[k] }
+        } // no registered encoder matches the wildcard
+    } // nothing acceptable, fall back to the JSON
+    if e, ok := app.Encoders[MimeJSON]; ok { return MimeJSON, e }
+    return MimeJSON, jsonEncoder // not registered
+}
+
+// Write the value as the response body, with the specified status
+// code, using the encoder picked by the content negotiation. Please see
+// the App.Encoders for the registered encoders. This is the preferred
+// way of responding with data from the endpoints, as it allows clients
+// to pick the format they are able to consume; JSON being default.
+func (c *Context) Render(status int, value interface {}) error {
+    return c.render(c.ResponseWriter, status, value)
+}
+
+// Write the value as the response body into the specified writer,
+// using the encoder picked by the content negotiation. This is the
+// implementation of the Render method; it is used directly by the
+// Watchdog, in order to respond through the writer that bypasses the
+// seal of the responder, after the endpoint has timed out or paniced.
+func (c *Context) render(w http.ResponseWriter, status int, value interface {}) error {
+    var accept string // no preference by default
+    if c.Request != nil { accept = c.Request.Header.Get("Accept") }
+    kind, encoder := c.App.negotiate(accept) // pick one
+    return c.encode(w, status, kind, encoder, value)
+}
+
+// Write the value as the JSON response body, with the specified status
+// code, regardless of what the client has stated in its Accept header.
+// Please use the Render method, if content negotiation is desirable.
+// Returns an error if value could not be encoded, in which case the
+// 500 Internal Server Error is written instead of the status code.
+func (c *Context) JSON(status int, value interface {}) error {
+    encoder, ok := c.App.Encoders[MimeJSON] // custom?
+    if !ok { encoder = jsonEncoder } // the default
+    return c.encode(c.ResponseWriter, status, MimeJSON, encoder, value)
+}
+
+// Encode the value with the encoder into a buffer, then set the type
+// header, write the status code and the body into the writer. If the
+// value could not be encoded, for example XML does not support maps,
+// it falls back to JSON; and if that fails too, the 500 status code is
+// written. Please use Render or JSON methods from application code.
+func (c *Context) encode(w http.ResponseWriter, status int, kind string, e Encoder, value interface {}) error {
+    var buffer bytes.Buffer // body is encoded first
+    err := e(&buffer, value) // encode value to buffer
+    if err != nil && kind != MimeJSON { // fall back?
+        c.Journal.WithError(err).WithField("type", kind).
+            Warn("failed to encode, falling back to JSON")
+        encoder, ok := c.App.Encoders[MimeJSON] // custom?
+        if !ok { encoder = jsonEncoder } // the default
+        kind = MimeJSON; buffer.Reset() // start anew
+        err = encoder(&buffer, value) // encode as JSON
+    } // value is encoded, unless JSON has failed as well
+    if err != nil { // nothing sensible to respond with
+        c.Journal.WithError(err).Error("failed to encode response body")
+        status := http.StatusInternalServerError // 500
+        http.Error(w, http.StatusText(status), status)
+        return err // the status has been replaced
+    } // body is ready to be written out to client
+    content := kind // MIME type, as is by default
+    if kind == MimeJSON || kind == MimeXML || strings.HasPrefix(kind, "text/") {
+        content = kind + "; charset=utf-8" // text type
+    } // content type has been determined for the body
+    w.Header().Set("Content-Type", content) // format
+    w.WriteHeader(status) // the status code goes first
+    _, err = w.Write(buffer.Bytes()) // the whole body
+    return err // nil if all went fine
+}
+
+// Respond with an error, with the specified status code. The error is
+// wrapped into the consistent error envelope, the same one that is used
+// by the default Watchdog supervisor; and is encoded using the content
+// negotiation. If the error is nil, the standard text for the status
+// code is used as the message. See the ErrorEnvelope for details.
+func (c *Context) Error(status int, err error) error {
+    var message string = http.StatusText(status)
+    if err != nil { message = err.Error() } // got it
+    envelope := ErrorEnvelope { ErrorBody {
+        Status: status, Message: message, // error
+        Reference: c.Reference, // context identity
+    }} // error envelope is ready for responding
+    var invalid *BindingError // got field errors?
+    if errors.As(err, &invalid) { envelope.Error.Fields = invalid.Fields }
+    c.Header().Set("X-Content-Type-Options", "nosniff")
+    return c.Render(status, envelope) // negotiate
+}
+
+// Respond with the 204 No Content status code and without any body.
+// This is the conventional response to the requests that have been
+// successfully handled, but have nothing to return back to the client,
+// such as the DELETE requests. Any body written after this method has
+// been invoked will be rejected by the standard HTTP stack.
+func (c *Context) NoContent() {
+    c.WriteHeader(http.StatusNoContent)
+}
+
+// Redirect the client to the specified URL, with the specified status
+// code, which should be in the 3xx range. The URL could be relative to
+// the request path; please refer to the http.Redirect function of the
+// standard library for the details on how the URL is being resolved.
+// This requires the context to represent an HTTP request.
+func (c *Context) Redirect(status int, url string) {
+    http.Redirect(c, c.Request, url, status)
+}
+
+// Stream the response body to the client, in chunks, flushing every
+// chunk as soon as it is written. The step function is called over and
+// over again, to write the next chunk; until it returns false or the
+// context is cancelled, for instance if the client has disconnected.
+// Returns the context error, if the stream has been cut short by it.
+func (c *Context) Stream(kind string, step func(w io.Writer) bool) error {
+    c.Header().Set("Content-Type", kind) // format
+    c.WriteHeader(http.StatusOK) // commence stream
+    for { // keep writing chunks, until done
+        if err := c.Err(); err != nil { return err }
+        more := step(c); c.Flush() // write it out
+        if !more { return nil } // stream is done
+    } // stream goes on, until stepper stops it
+}
+
+// Flush any buffered response data out to the client, if the response
+// writer supports it. This is useful for streaming responses, such as
+// the server sent events, long polling and so on. Please see the Stream
+// method, that flushes automatically after writing each of the chunks.
+// Nothing happens if the response writer does not support flushing.
+func (c *Context) Flush() {
+    controller := http.NewResponseController(c.ResponseWriter)
+    controller.Flush() // ignore unsupported writers
+}
+
+// Whether the response has already been written, at least partially:
+// that is, whether the status code has been sent to the client. This
+// is used by the supervisor to avoid writing a second response, when
+// the endpoint has already responded before failing, for example. It
+// is always false for contexts that do not represent HTTP requests.
+func (c *Context) Written() bool {
+    rw, ok := c.ResponseWriter.(*responder)
+    return ok && rw.Status() != 0 // tracked
+}
+
+// Seal the responder of the context, so that the business logic that
+// may still be running, after its operation has timed out, paniced or
+// has been cancelled, could not write into the response anymore. This
+// is done by the pipeline before the supervisor is invoked; so it is
+// only the supervisor that could respond, see the responder.bypass.
+func (c *Context) seal() {
+    rw, ok := c.ResponseWriter.(*responder)
+    if ok { rw.seal() } // not an HTTP request?
+}
+
+// Wrapper of the HTTP response writer, that tracks whether the status
+// code has been written, which status code it was and how many bytes
+// of the body have been written. It is installed by the App.ServeHTTP
+// for every HTTP request; and supports flushing, hijacking and other
+// extensions via Unwrap method, used by the http.ResponseController.
+// It is safe to use from multiple go-routines; and could be sealed.
+type responder struct {
+    http.ResponseWriter // the original writer
+    status int // status code, zero if not written
+    size int64 // number of body bytes written
+    sealed bool // rejects the business logic writes
+    detached http.Header // handed out once sealed
+    sync.Mutex // guards all of the above
+}
+
+// Seal the responder, so that all the subsequent writes are rejected
+// with the http.ErrHandlerTimeout error and the status codes ignored.
+// The headers are detached from the original writer, so that the late
+// writes into them do not race with the supervisor, which is the only
+// one that could respond once sealed; see the bypass method for that.
+func (rw *responder) seal() {
+    rw.Lock(); defer rw.Unlock() // guard it
+    rw.detached = make(http.Header) // discarded
+    rw.sealed = true // no more business writes
+}
+
+// Headers of the response, that will be sent along with the status
+// code, once it has been written. Once the responder has been sealed,
+// these are detached headers, that are never sent to the client; so
+// that the business logic, left running after its operation has been
+// abandoned, could not interfere with the response of the supervisor.
+func (rw *responder) Header() http.Header {
+    rw.Lock(); defer rw.Unlock() // guard it
+    if rw.sealed { return rw.detached } // discarded
+    return rw.ResponseWriter.Header() // original
+}
+
+// Write the status code to the client, unless it has already been
+// written, in which case the call is ignored; the same as standard
+// HTTP stack does, but without the superfluous journal entries. This
+// records the status code, so it could be queried later on. It is the
+// implementation of the http.ResponseWriter interface method.
+func (rw *responder) WriteHeader(status int) {
+    rw.Lock(); defer rw.Unlock() // guard it
+    if rw.sealed { return } // response is taken
+    rw.writeHeader(status) // unless already sent
+}
+
+// Write a chunk of the response body to the client, writing the 200
+// status code first, if none has been written yet; exactly the way the
+// standard HTTP stack does. This records the number of bytes written,
+// so it could be queried later on. Returns http.ErrHandlerTimeout if
+// the responder has been sealed, that is the operation was abandoned.
+func (rw *responder) Write(data []byte) (int, error) {
+    rw.Lock(); defer rw.Unlock() // guard it
+    if rw.sealed { return 0, http.ErrHandlerTimeout }
+    return rw.write(data) // count the bytes written
+}
+
+// Flush any buffered response data out to the client, writing the 200
+// status code first, if none has been written yet. It is discovered by
+// the http.ResponseController, which is used by Context.Flush method.
+// Returns http.ErrHandlerTimeout if the responder has been sealed or
+// http.ErrNotSupported if the original writer could not be flushed.
+func (rw *responder) FlushError() error {
+    rw.Lock(); defer rw.Unlock() // guard it
+    if rw.sealed { return http.ErrHandlerTimeout }
+    if rw.status == 0 { rw.writeHeader(http.StatusOK) }
+    controller := http.NewResponseController(rw.ResponseWriter)
+    return controller.Flush() // the original writer
+}
+
+// Write the status code and the chunk of the body into the original
+// writer, recording the status code and the number of bytes written.
+// These assume that the lock is being held by the caller; and do not
+// check the seal. They are shared by the responder methods and by the
+// writer that bypasses the seal, used exclusively by the supervisor.
+func (rw *responder) writeHeader(status int) {
+    if rw.status != 0 { return } // already sent
+    rw.status = status // record the status code
+    rw.ResponseWriter.WriteHeader(status)
+}
+func (rw *responder) write(data []byte) (int, error) {
+    if rw.status == 0 { rw.writeHeader(http.StatusOK) }
+    n, err := rw.ResponseWriter.Write(data)
+    rw.size += int64(n); return n, err // count
+}
+
+// Status code that has been written to the client, or zero if it has
+// not been written yet. Size is the number of bytes of response body
+// that have been written so far. These are used to track the outcome
+// of the HTTP requests; for example, to avoid writing responses twice.
+// Unwrap is used by http.ResponseController to reach the original.
+func (rw *responder) Status() int { rw.Lock(); defer rw.Unlock(); return rw.status }
+func (rw *responder) Size() int64 { rw.Lock(); defer rw.Unlock(); return rw.size }
+func (rw *responder) Sealed() bool { rw.Lock(); defer rw.Unlock(); return rw.sealed }
+func (rw *responder) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
+
+// Obtain the writer that writes into the original response writer,
+// bypassing the seal of the responder, while still tracking the status
+// code and the size of the response. This is used by the supervisor to
+// respond, once the operation has been abandoned and the responder got
+// sealed; so the late writes of the business logic are rejected.
+func (rw *responder) bypass() http.ResponseWriter {
+    return &bypassWriter { rw } // shares the lock
+}
+
+// Writer that bypasses the seal of the responder; see the responder
+// bypass method for details. It shares the lock and the tracking with
+// the responder, so the status code and the size could be observed by
+// the access log and the journal, as if it was written by responder.
+// This is the implementation of the http.ResponseWriter interface.
+type bypassWriter struct { rw *responder }
+
+func (bw *bypassWriter) Header() http.Header {
+    return bw.rw.ResponseWriter.Header() // original
+}
+
+func (bw *bypassWriter) WriteHeader(status int) {
+    bw.rw.Lock(); defer bw.rw.Unlock() // guard it
+    bw.rw.writeHeader(status) // unless already sent
+}
+
+func (bw *bypassWriter) Write(data []byte) (int, error) {
+    bw.rw.Lock(); defer bw.rw.Unlock() // guard it
+    return bw.rw.write(data) // count the bytes written
+}
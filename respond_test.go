@@ -0,0 +1,63 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "io"
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+    const custom = "text/csv" // registered in addition
+    var cases = [][2]string {
+        { "", MimeJSON }, { "*/*", MimeJSON },
+        { "application/xml", MimeXML }, { "application/*", MimeJSON },
+        { "text/*", custom }, { "image/png", MimeJSON },
+        { "application/msgpack, application/json;q=0.5", MimeMsgpack },
+        { "application/json;q=0.5, application/xml", MimeXML },
+        { "application/xml;q=0, */*;q=0.1", MimeJSON },
+        { "garbage;;, application/xml", MimeXML },
+        { "text/csv;q=0.9, application/xml;q=0.9", custom },
+    } // Accept headers and the picked media types
+    app := New("negotiate", "1.0.0") // default encoders
+    app.Encoders[custom] = app.Encoders[MimeJSON]
+    for _, c := range cases { // negotiate every one
+        kind, encoder := app.negotiate(c[0]) // pick it
+        if kind != c[1] { t.Errorf("Accept %q picked %q, want %q", c[0], kind, c[1]) }
+        if encoder == nil { t.Errorf("Accept %q picked no encoder", c[0]) }
+    }
+}
+
+func TestNegotiateFallback(t *testing.T) {
+    app := New("negotiate", "1.0.0") // default encoders
+    var custom bool = false // has it been picked?
+    app.Encoders[MimeJSON] = func(w io.Writer, value interface {}) error {
+        custom = true; return nil // custom JSON encoder
+    } // the application has replaced the JSON encoder
+    kind, encoder := app.negotiate("image/png") // none
+    encoder(io.Discard, nil) // which one is it?
+    if kind != MimeJSON || !custom { t.Error("fallback does not use the custom JSON encoder") }
+    delete(app.Encoders, MimeJSON) // no JSON at all
+    kind, encoder = app.negotiate("image/png") // none
+    if kind != MimeJSON || encoder == nil { t.Error("fallback has no JSON encoder") }
+}
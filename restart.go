@@ -0,0 +1,227 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "os"
+import "net"
+import "time"
+import "sort"
+import "bufio"
+import "strings"
+import "strconv"
+import "os/exec"
+import "fmt"
+
+// Names of the environment variables that are used to hand listening
+// sockets over from the parent process to the child one, when the app
+// is being restarted. Listeners is a comma separated list of intent=fd
+// pairs; notify is the fd of the pipe that the child reports to, once
+// it has been deployed; and parent is the Reference of the parent app.
+const (
+    HandoffListeners = "HANDOFF_LISTENERS" // intent=fd
+    HandoffNotify = "HANDOFF_NOTIFY" // fd of the pipe
+    HandoffParent = "HANDOFF_PARENT" // parent Reference
+)
+
+// Obtain the listener for the application server with the specified
+// intent. If the listener has been handed over by the parent process,
+// then it is used as is, regardless of the address; otherwise a new
+// TCP listener is bound to the address. Listeners are recorded within
+// the app, so they could be handed over to the child on the restart.
+func (app *App) listen(intent, address string) net.Listener {
+    const einherit = "failed to inherit listener %v: %v"
+    log := app.Journal.WithField("intent", intent)
+    app.Lock(); defer app.Unlock() // guard the maps
+    var listener net.Listener // inherited or new
+    if file, ok := app.inherited[intent]; ok { // got it
+        delete(app.inherited, intent) // consumed
+        inherited, err := net.FileListener(file)
+        if err != nil { panic(fmt.Errorf(einherit, intent, err)) }
+        file.Close() // listener has its own copy of fd
+        listener = inherited // reuse parent's socket
+        log.WithField("bind", listener.Addr()).Info("inherited listener")
+    } else { // not inherited, bind a new listener
+        bound, err := net.Listen("tcp", address)
+        if err != nil { panic(err) } // cannot bind
+        listener = bound // a fresh listening socket
+    } // got listener, record it for the handoff
+    app.listeners[intent] = listener // by intent
+    return listener // ready to serve on it
+}
+
+// Pick up the listening sockets handed over by the parent process, if
+// the application has been started as a result of the restart. These
+// are found by the file descriptors listed in the HandoffListeners
+// environment variable. The variables are cleared afterwards, so that
+// they do not leak into any other processes spawned by application.
+func (app *App) inheritListeners() {
+    app.Lock(); defer app.Unlock() // guard it
+    app.inherited = make(map[string] *os.File)
+    pairs := os.Getenv(HandoffListeners) // intent=fd
+    os.Unsetenv(HandoffListeners) // do not leak it
+    for _, pair := range strings.Split(pairs, ",") {
+        intent, descriptor, ok := strings.Cut(pair, "=")
+        fd, err := strconv.Atoi(descriptor) // number
+        if !ok || err != nil || fd < 3 { continue }
+        file := os.NewFile(uintptr(fd), intent) // wrap
+        app.inherited[intent] = file // by intent
+    } // all the inherited listeners are picked up
+}
+
+// Report back to the parent process that this application has been
+// deployed and took over the listeners, if it has been started as a
+// result of the restart. The Reference and the Booted instant of the
+// application are written into the pipe that the parent waits on. The
+// inherited listeners that are no longer declared are closed here.
+func (app *App) notifyParent() {
+    parent := os.Getenv(HandoffParent) // Reference
+    descriptor := os.Getenv(HandoffNotify) // pipe
+    os.Unsetenv(HandoffParent); os.Unsetenv(HandoffNotify)
+    app.Lock() // guard the inherited listeners
+    for intent, file := range app.inherited { // unused
+        app.Journal.WithField("intent", intent).
+            Warn("inherited listener is not declared")
+        file.Close(); delete(app.inherited, intent)
+    } // all the unused listeners are closed
+    app.Unlock() // release the accquired mutex
+    fd, err := strconv.Atoi(descriptor) // number
+    if parent == "" || err != nil { return } // no
+    log := app.Journal.WithField("parent", parent)
+    pipe := os.NewFile(uintptr(fd), "handoff")
+    defer pipe.Close() // parent will get the EOF
+    booted := app.Booted.Format(time.RFC3339Nano)
+    _, err = fmt.Fprintf(pipe, "%s %s\n", app.Reference, booted)
+    if err != nil { log.WithError(err).Warn("failed to notify parent") }
+    log.Info("took over the listeners from parent")
+}
+
+// Restart the application with zero downtime. A new process is spawned
+// from the same executable, with the same arguments, and the listening
+// sockets of all the app servers are handed over to it as inherited
+// file descriptors. Method waits until the child reports it has been
+// deployed, up to the app.restart-timeout (a minute by default), and
+// returns; the caller should then drain and shut this app down. If the
+// child fails to deploy in time, it is killed and error is returned.
+func (app *App) Restart() error {
+    const echild = "child process has exited: %v"
+    const etimeout = "child process did not deploy in %v"
+    var timeout time.Duration = time.Minute // default
+    tree := app.CurrentConfig() // as it is now
+    if rt, ok := tree.Get("app.restart-timeout").(string); ok {
+        parsed, err := time.ParseDuration(rt) // parse
+        if err != nil { return fmt.Errorf("invalid app.restart-timeout") }
+        timeout = parsed // override the default
+    } // timeout is either default or configured
+    executable, err := os.Executable() // ourselves
+    if err != nil { return err } // cannot respawn
+    reader, writer, err := os.Pipe() // child reports
+    if err != nil { return err } // cannot notify
+    defer reader.Close() // done waiting on the child
+    files, pairs, err := app.listenerFiles() // dup
+    if err != nil { writer.Close(); return err }
+    files = append(files, writer) // notify pipe last
+    command := exec.Command(executable, os.Args[1:]...)
+    command.Stdin, command.Stdout = os.Stdin, os.Stdout
+    command.Stderr = os.Stderr // share the terminal
+    command.ExtraFiles = files // starting from fd 3
+    command.Env = handoffEnviron(pairs, 3 + len(files) - 1, app.Reference)
+    err = command.Start() // spawn the child process
+    for _, f := range files { f.Close() } // child has
+    if err != nil { return err } // could not spawn
+    exited := make(chan error, 1) // child is gone
+    go func() { exited <- command.Wait() }() // reap
+    reported := make(chan string, 1) // child is up
+    go func() { // read the report of the child
+        line, _ := bufio.NewReader(reader).ReadString('\n')
+        reported <- strings.TrimSpace(line) // or empty
+    }() // waiting for the child to report back
+    log := app.Journal.WithField("pid", command.Process.Pid)
+    log.Info("spawned child process, awaiting deploy")
+    select { // report, exit or timeout; first wins
+        case line := <- reported: // got the report
+            child, booted, _ := strings.Cut(line, " ")
+            if child == "" { break } // no report, EOF
+            log = log.WithField("child", child)
+            log.WithField("booted", booted).
+                Info("child process has been deployed")
+            return nil // child took over, drain now
+        case err := <- exited: // died before report
+            return fmt.Errorf(echild, err)
+        case <- time.After(timeout): // took too long
+            command.Process.Kill() // give up on it
+            return fmt.Errorf(etimeout, timeout)
+    } // pipe was closed without any report
+    select { // did the child exit, or just closed?
+        case err := <- exited: return fmt.Errorf(echild, err)
+        case <- time.After(timeout): // still running
+            command.Process.Kill() // hung up child
+            return fmt.Errorf(echild, "no report")
+    }
+}
+
+// Duplicate the file descriptors of the listeners of all app servers,
+// so they could be handed over to the child process. Returns the files
+// along with intent=fd pairs, where fd is the descriptor number that
+// the file will have in the child; which is 3 for the first file, and
+// so on. Listeners are ordered by their intent, for a stable order.
+func (app *App) listenerFiles() ([]*os.File, []string, error) {
+    type filer interface { File() (*os.File, error) }
+    app.Lock(); defer app.Unlock() // guard it
+    intents := make([]string, 0, len(app.listeners))
+    for intent := range app.listeners { intents = append(intents, intent) }
+    sort.Strings(intents) // have a stable order
+    files := make([]*os.File, 0, len(intents))
+    pairs := make([]string, 0, len(intents))
+    for _, intent := range intents { // dup each
+        f, ok := app.listeners[intent].(filer)
+        if !ok { continue } // not a socket listener
+        file, err := f.File() // duplicate the fd
+        if err != nil { // cannot hand it over
+            for _, f := range files { f.Close() }
+            return nil, nil, err // release others
+        } // got the duplicated file descriptor
+        pairs = append(pairs, fmt.Sprintf("%s=%d", intent, 3 + len(files)))
+        files = append(files, file) // in order
+    } // all the listeners have been duplicated
+    return files, pairs, nil // ready to hand over
+}
+
+// Prepare the environment of the child process, which is the same as
+// the environment of this process, less any stale handoff variables,
+// plus the handoff variables that describe the inherited listeners, the
+// pipe to report to and the Reference of this application instance.
+// See the HandoffListeners and others for details on the variables.
+func handoffEnviron(pairs []string, notify int, parent string) []string {
+    environ := make([]string, 0) // child environment
+    for _, pair := range os.Environ() { // drop stale
+        name, _, _ := strings.Cut(pair, "=") // name
+        if name == HandoffListeners || name == HandoffNotify ||
+            name == HandoffParent { continue } // stale
+        environ = append(environ, pair) // inherit it
+    } // environment has been copied, less handoff
+    return append(environ, // handoff variables
+        HandoffListeners + "=" + strings.Join(pairs, ","),
+        HandoffNotify + "=" + strconv.Itoa(notify),
+        HandoffParent + "=" + parent)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+//go:build unix
+
+package boot
+
+import "os"
+import "time"
+import "syscall"
+import "os/signal"
+
+import stdctx "context"
+
+// Spawn the restart watcher, that restarts the application whenever
+// it receives the SIGUSR2 signal. Once the child process has deployed,
+// this application is drained and shut down, within the grace period;
+// that makes the Deploy return. If the restart fails, the application
+// keeps running as is. Watcher stops once the app has been shut down.
+func (app *App) watchRestart() {
+    restart := make(chan os.Signal, 1) // restart
+    signal.Notify(restart, syscall.SIGUSR2) // watch
+    go func() { // this runs in the background
+        defer signal.Stop(restart) // stop watching
+        for { select { // either signal or stopped
+            case <- app.lifetime.Done(): return // done
+            case <- restart: // explicitly requested
+                log := app.Journal.WithField("ref", app.Reference)
+                log.Warn("got SIGUSR2, restart the app")
+                if err := app.Restart(); err != nil {
+                    log.WithError(err).Error("failed to restart")
+                    continue // keep running as we are
+                } // child took over, shut this one down
+                var grace time.Duration = app.GracePeriod
+                bg := stdctx.Background() // root context
+                ctx, cancel := stdctx.WithTimeout(bg, grace)
+                app.Shutdown(ctx); cancel(); return // done
+        }}
+    }() // watcher is running in the background
+}
@@ -25,6 +25,9 @@ package boot
 
 import "time"
 import "sync"
+import "sync/atomic"
+
+import stdctx "context"
 
 import "github.com/renstrom/shortuuid"
 
@@ -43,6 +46,7 @@ func (srv *Service) Up(app *App) {
     context.Created = srv.Erected // creation stamp
     context.Journal = log // setup derived logger
     context.Reference = shortuuid.New() // V4
+    context.Context = app.lifetime // app-wide
     log.Info("booting application service up")
     srv.Erected = time.Now() // mark service up
     for _, aux := range srv.Auxes { // walk auxes
@@ -54,7 +58,10 @@ func (srv *Service) Up(app *App) {
         if ce := aux.CronExpression; len(ce) > 0 {
             oplog.Infof("schedule CRON at %v", ce)
             app.CronEngine.AddFunc(ce, func() {
-                aux.Run(context) // CRON-called
+                started := time.Now() // measure it
+                aux.Run(context.derive(srv)) // go!
+                took := time.Since(started) // elapsed
+                app.stats.cronRun(srv, aux, took)
             }) // schedule as a new CRON task
         } // see if it needs to be invoked on up
         if aux.WhenUp { // invoke when service up
@@ -77,6 +84,7 @@ func (srv *Service) Down(app *App) {
     context.Created = time.Now() // creation stamp
     context.Journal = log // setup derived logger
     context.Reference = shortuuid.New() // V4
+    context.Context = stdctx.Background() // final
     log.Info("taking application service down")
     for _, aux := range srv.Auxes { // walk auxes
         oplog := log.WithField("aux", aux) // OP log
@@ -95,6 +103,16 @@ func (srv *Service) Down(app *App) {
 // trace it down right to its implementation or definition.
 func (srv *Service) String() string { return srv.Prefix }
 
+// Get the pointer to the most recent copy of the Config struct, as it
+// has been decoded at the boot or at the last reload of the config; of
+// the same type as the Config itself. The struct that it points to is
+// never modified once published, so it is safe to read concurrently
+// with the reloads. Returns the Config, if nothing is published yet.
+func (srv *Service) CurrentConfig() interface {} {
+    if c := srv.published.Load(); c != nil { return c }
+    return srv.Config // not decoded yet, or no config
+}
+
 // Service is a group of endpoints that are functionally related. It
 // also serves as a common data exchange bus between the endpoints that
 // belong to the same service. Endpoints may store data in the service,
@@ -123,6 +141,35 @@ type Service struct {
     // to the App structure and its Env field for more information.
     Available map[string] bool
 
+    // Dotted path of the config section that the service consumes,
+    // such as app.mailer. It is decoded into the Config struct, when
+    // the application is being booted, before any providers are set up;
+    // so that all the config errors of all providers and services are
+    // reported at once, rather than failing in the middle of a deploy.
+    ConfigSection string
+
+    // Pointer to the struct that the config section of this service
+    // should be decoded into; see the App.DecodeConfig for the details
+    // on the struct tags that are supported. Endpoints of the service
+    // could then use the struct directly, without digging into config.
+    // It is decoded in place once, at boot; reloads publish the fresh
+    // copies instead, see CurrentConfig. If there is no config that is
+    // consumed by the service - set it to nil, it is optional.
+    Config interface {}
+
+    // Most recent copy of the Config struct, as it has been decoded
+    // at the boot or the last config reload. It is published by the
+    // framework atomically, as a whole; so readers never observe half
+    // updated struct. Please use the CurrentConfig method to read it.
+    published atomic.Value
+
+    // Optional function that is invoked every time the configuration
+    // of the application has been reloaded, with the previous and the
+    // current config trees; CurrentConfig returns the updated struct.
+    // It is only invoked if the service has been brought up, and it is
+    // used to apply the changes without restarting the application.
+    Reconfigure ReloadLogic
+
     // Map of aux operations belonging to a service. Normally, field
     // should not be manipulated directly, but rather using framework
     // API for that. All aux ops within a group should usually share
@@ -157,4 +204,11 @@ type Service struct {
     // multiple of ways; and may also be used by whoever is interested
     // the time of when the service was loaded, if it was at all.
     Erected time.Time
+
+    // Store source location of where the definition of this service
+    // is implemented. This information may not always be available. It
+    // will be accordingly reflected in the return struct in this case.
+    // Maintenance of this information should be done within framework.
+    // Please refer to the SourceLocation struct for more details.
+    SourceLocation
 }
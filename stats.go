@@ -0,0 +1,506 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "io"
+import "net"
+import "net/http"
+import "sort"
+import "strings"
+import "sync"
+import "time"
+import "encoding/json"
+import "errors"
+import "fmt"
+
+// Upper bounds of the latency histogram buckets, in seconds. These
+// are the same bounds that are used by default in Prometheus client
+// libraries, covering a range from 5 milliseconds to 10 seconds. An
+// implicit bucket with the infinite upper bound is always present at
+// the end; it corresponds to the total count of the observations.
+var LatencyBuckets = []float64 {
+    .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// Outcomes of an operation application, as they are recorded by the
+// statistics subsystem. Every operation application ends up with one
+// of these outcomes; which is determined by the error value that the
+// application has ended with. See the outcome function for details on
+// how the error values are mapped to the outcomes.
+const (
+    OutcomeOk = "ok" // operation completed normally
+    OutcomeTimeout = "timeout" // operation timed out
+    OutcomeUnavailable = "unavailable" // op is N/A
+    OutcomeCancelled = "cancelled" // op cancelled
+    OutcomeInvalid = "invalid" // input rejected
+    OutcomePanic = "panic" // operation has paniced
+)
+
+// Map the error value that an operation application has ended with
+// to the outcome of that application, as it is being recorded by the
+// statistics subsystem. Any error value that is not one of the known
+// framework error values is considered to be a panic, in line with
+// how the Pipeline dispatches the errors to the app supervisor.
+func outcome(err error) string {
+    switch err { // framework error values
+        case nil: return OutcomeOk
+        case OperationTimeout: return OutcomeTimeout
+        case OperationUnavailable: return OutcomeUnavailable
+        case OperationCancelled: return OutcomeCancelled
+    } // not a plain framework error value
+    var invalid *BindingError // rejected input?
+    if errors.As(err, &invalid) { return OutcomeInvalid }
+    return OutcomePanic // anything else
+}
+
+// Statistics subsystem of an application instance. Collects counts
+// and latencies of the operation applications, grouped by outcome, as
+// well as the in-flight gauges, connection counts of the app servers
+// and durations of the CRON job runs. All the data is kept in memory;
+// use the App.Statistics method to take a snapshot of the data.
+type statistics struct {
+    sync.Mutex // guards the maps below
+    meters map[string] *meter // per operation
+    servers map[string] *connMeter // per intent
+    cron map[string] *histogram // per aux
+}
+
+// Meter of a single operation; such as endpoint or aux operation. It
+// tracks the number of in-flight applications of the operation and a
+// latency histogram per every outcome of the operation application.
+// Meters are created when a pipeline is compiled and are kept for the
+// whole lifetime of the application. See the Pipeline.Compile.
+type meter struct {
+    sync.Mutex // guards the fields below
+    kind, service, name string // identity
+    inflight int64 // applications in progress
+    outcomes map[string] *histogram // latency
+}
+
+// Meter of the connections of a single application server. It tracks
+// the number of the currently active connections, as well as the total
+// number of the connections accepted by the server since it has been
+// spawned. Connections are tracked using the ConnState hook of the
+// http.Server; see the connState method for the implementation.
+type connMeter struct {
+    sync.Mutex // guards the fields below
+    active int64 // currently open connections
+    total uint64 // accepted since the start
+}
+
+// Cumulative histogram of the latency observations, in seconds. It
+// uses the bucket bounds that are defined by the LatencyBuckets. This
+// is not safe for concurrent access on its own; the owner structure
+// must guard the access to the histogram with a lock. Observations
+// are recorded by the observe method, see it for more details.
+type histogram struct {
+    counts []uint64 // per bucket, not cumulative
+    count uint64 // total number of observations
+    sum float64 // sum of all observations, seconds
+}
+
+// Record a single observation of the specified duration within this
+// histogram. The observation is counted in the first bucket whose upper
+// bound is not less than the duration; or only counted in the total if
+// it exceeds all the bounds. The owner structure must hold the lock.
+// Please see the snapshot method for how data is being exposed.
+func (h *histogram) observe(duration time.Duration) {
+    var seconds float64 = duration.Seconds()
+    if h.counts == nil { // not yet allocated?
+        h.counts = make([]uint64, len(LatencyBuckets))
+    } // buckets are allocated, record it now
+    h.count++; h.sum += seconds // totals
+    for i, bound := range LatencyBuckets { // find
+        if seconds <= bound { h.counts[i]++; break }
+    } // observation has been put into a bucket
+}
+
+// Take a snapshot of the histogram data, with the cumulative bucket
+// counts, as it is customary for the histogram presentation format.
+// The owner structure must hold the lock when invoking this method.
+// Please refer to HistogramStats struct for more details on the data.
+// The returned snapshot is detached from the histogram itself.
+func (h *histogram) snapshot() HistogramStats {
+    stats := HistogramStats { Count: h.count, Sum: h.sum }
+    stats.Buckets = make([]BucketStats, len(LatencyBuckets))
+    var cumulative uint64 = 0 // running total
+    for i, bound := range LatencyBuckets { // walk
+        if h.counts != nil { cumulative += h.counts[i] }
+        stats.Buckets[i] = BucketStats { bound, cumulative }
+    } // all the buckets are filled with data
+    return stats // detached snapshot of data
+}
+
+// Obtain the meter for the operation that is being run by the given
+// pipeline; creating the meter if it does not exist yet. Meters are
+// keyed by the kind, service and name of the operation, so compiling
+// the pipeline for the same operation again reuses the same meter.
+// Please refer to the Pipeline.Compile method for the usage details.
+func (st *statistics) meter(pipe *Pipeline) *meter {
+    var kind, name string = "operation", pipe.Operation.String()
+    switch op := pipe.Operation.(type) { // by type
+        case *Endpoint: kind = "endpoint"
+            if pipe.Service != nil { name = pipe.Service.mountPoint(op) }
+        case *Aux: kind = "aux" // aux by its handle
+    } // kind and name of the operation are known
+    var service string = "" // the owning service
+    if pipe.Service != nil { service = pipe.Service.Prefix }
+    key := strings.Join([]string { kind, service, name }, "\x00")
+    st.Lock(); defer st.Unlock() // guard the maps
+    if m := st.meters[key]; m != nil { return m }
+    m := &meter { kind: kind, service: service, name: name }
+    m.outcomes = make(map[string] *histogram) // empty
+    st.meters[key] = m // register the new meter
+    return m // meter is ready for recording
+}
+
+// Mark the beginning of an operation application. Increments the
+// in-flight gauge and returns the instant of the beginning, which has
+// to be passed to the end method, once the application is completed.
+// Please see the Pipeline.Compile method for the usage details. The
+// method is safe to be called concurrently, from many go-routines.
+func (m *meter) begin() time.Time {
+    m.Lock(); defer m.Unlock() // guard it
+    m.inflight++ // one more in progress
+    return time.Now() // mark the beginning
+}
+
+// Mark the end of an operation application that has been started at
+// the specified instant and has ended with the specified error value.
+// Decrements the in-flight gauge and records the latency within the
+// histogram that corresponds to the outcome of the application. The
+// method is safe to be called concurrently, from many go-routines.
+func (m *meter) end(started time.Time, err error) {
+    var elapsed time.Duration = time.Since(started)
+    m.Lock(); defer m.Unlock() // guard it
+    m.inflight-- // one less in progress
+    var key string = outcome(err) // outcome
+    if m.outcomes[key] == nil { // first time?
+        m.outcomes[key] = &histogram {} // alloc
+    } // histogram for the outcome is there
+    m.outcomes[key].observe(elapsed) // record
+}
+
+// Build the connection state hook for the application server with
+// the specified intent. The hook should be installed as ConnState of
+// the corresponding http.Server instance; it counts new connections
+// and tracks the number of active ones. Hijacked connections are
+// considered closed, since the server does not manage them anymore.
+func (st *statistics) connState(intent string) func(net.Conn, http.ConnState) {
+    st.Lock(); defer st.Unlock() // guard the maps
+    cm := st.servers[intent] // reuse existing one
+    if cm == nil { cm = &connMeter {}; st.servers[intent] = cm }
+    return func(_ net.Conn, state http.ConnState) {
+        cm.Lock(); defer cm.Unlock() // guard it
+        switch state { // only the edge states
+            case http.StateNew: cm.active++; cm.total++
+            case http.StateClosed: cm.active--
+            case http.StateHijacked: cm.active--
+        } // the rest of states are not relevant
+    }
+}
+
+// Record a single run of the CRON job, that is the aux operation in
+// the service with the specified prefix, along with the duration that
+// the run took to complete. Durations are recorded into a histogram,
+// per every distinct aux operation. Please refer to the Service.Up
+// method for the details on how the CRON jobs are being scheduled.
+func (st *statistics) cronRun(srv *Service, aux *Aux, took time.Duration) {
+    key := srv.Prefix + "\x00" + aux.Handle // identity
+    st.Lock(); defer st.Unlock() // guard the maps
+    if st.cron[key] == nil { st.cron[key] = &histogram {} }
+    st.cron[key].observe(took) // record the run
+}
+
+// Take a snapshot of the statistics that have been collected by the
+// application up to this moment. The snapshot is a plain structure that
+// is serializable to JSON, and is detached from the live data. Entries
+// are sorted, so the output is stable between the invocations. See the
+// MountStatistics method to expose these statistics over HTTP.
+func (app *App) Statistics() *Statistics {
+    st := app.stats // shortcut to the live data
+    snapshot := &Statistics { Reference: app.Reference }
+    snapshot.Uptime = time.Since(app.Booted).Seconds()
+    snapshot.Operations = make([]OperationStats, 0)
+    snapshot.Servers = make([]ServerStats, 0) // empty
+    snapshot.Cron = make([]CronStats, 0) // empty
+    st.Lock(); defer st.Unlock() // guard the maps
+    for _, m := range st.meters { // walk meters
+        m.Lock() // guard the meter while copying
+        op := OperationStats { Kind: m.kind, Name: m.name }
+        op.Service = m.service; op.InFlight = m.inflight
+        op.Outcomes = make(map[string] HistogramStats)
+        for key, h := range m.outcomes { op.Outcomes[key] = h.snapshot() }
+        m.Unlock() // meter data has been copied
+        snapshot.Operations = append(snapshot.Operations, op)
+    } // all operation meters have been copied
+    for intent, cm := range st.servers { // walk
+        cm.Lock() // guard the meter while copying
+        server := ServerStats { Intent: intent }
+        server.Active = cm.active; server.Total = cm.total
+        cm.Unlock() // meter data has been copied
+        snapshot.Servers = append(snapshot.Servers, server)
+    } // all connection meters have been copied
+    for key, h := range st.cron { // walk CRON jobs
+        parts := strings.SplitN(key, "\x00", 2) // id
+        job := CronStats { Service: parts[0], Handle: parts[1] }
+        job.Runs = h.snapshot() // copy of histogram
+        snapshot.Cron = append(snapshot.Cron, job)
+    } // all of the CRON histograms are copied
+    sort.Slice(snapshot.Operations, func(i, j int) bool {
+        a, b := snapshot.Operations[i], snapshot.Operations[j]
+        if a.Service != b.Service { return a.Service < b.Service }
+        if a.Kind != b.Kind { return a.Kind < b.Kind }
+        return a.Name < b.Name // by the identity
+    }) // operations are sorted in a stable order
+    sort.Slice(snapshot.Servers, func(i, j int) bool {
+        return snapshot.Servers[i].Intent < snapshot.Servers[j].Intent
+    }) // servers are sorted in a stable order
+    sort.Slice(snapshot.Cron, func(i, j int) bool {
+        a, b := snapshot.Cron[i], snapshot.Cron[j]
+        if a.Service != b.Service { return a.Service < b.Service }
+        return a.Handle < b.Handle // by identity
+    }) // CRON jobs are sorted in a stable order
+    return snapshot // detached snapshot of data
+}
+
+// Write the statistics out in the Prometheus text exposition format.
+// Operation latencies are exposed as histograms, labeled by the kind,
+// service, operation and the outcome; in-flight gauges are labeled by
+// the same labels, except for outcome. Server connection counts and the
+// CRON job durations are exposed as well. Returns the first error.
+func (stats *Statistics) WritePrometheus(w io.Writer) error {
+    p := &promWriter { Writer: w } // sticky error
+    p.header("boot_uptime_seconds", "gauge", "Application uptime.")
+    p.sample("boot_uptime_seconds", "", stats.Uptime)
+    const opd = "boot_operation_duration_seconds"
+    p.header(opd, "histogram", "Operation latency by outcome.")
+    for _, op := range stats.Operations { // walk
+        outcomes := make([]string, 0) // sorted keys
+        for key := range op.Outcomes { outcomes = append(outcomes, key) }
+        sort.Strings(outcomes) // have a stable order
+        for _, key := range outcomes { // per outcome
+            labels := promLabels("kind", op.Kind, "service",
+                op.Service, "operation", op.Name, "outcome", key)
+            p.histogram(opd, labels, op.Outcomes[key])
+        } // all the outcomes have been written
+    } // all operation latencies have been written
+    const opi = "boot_operation_in_flight"
+    p.header(opi, "gauge", "Operations currently in progress.")
+    for _, op := range stats.Operations { // walk
+        labels := promLabels("kind", op.Kind, "service",
+            op.Service, "operation", op.Name) // identity
+        p.sample(opi, labels, float64(op.InFlight))
+    } // all of the in-flight gauges are written
+    const sca = "boot_server_connections_active"
+    const sct = "boot_server_connections_total"
+    p.header(sca, "gauge", "Currently open server connections.")
+    for _, s := range stats.Servers { // walk servers
+        labels := promLabels("intent", s.Intent) // id
+        p.sample(sca, labels, float64(s.Active))
+    } // all the active connections are written
+    p.header(sct, "counter", "Accepted server connections.")
+    for _, s := range stats.Servers { // walk servers
+        labels := promLabels("intent", s.Intent) // id
+        p.sample(sct, labels, float64(s.Total))
+    } // all the total connections are written
+    const cjd = "boot_cron_duration_seconds"
+    p.header(cjd, "histogram", "Duration of CRON job runs.")
+    for _, job := range stats.Cron { // walk jobs
+        labels := promLabels("service", job.Service,
+            "aux", job.Handle) // identity of the job
+        p.histogram(cjd, labels, job.Runs) // runs
+    } // all the CRON job durations are written
+    return p.err // first error that has occured
+}
+
+// Writer of the Prometheus text exposition format. It remembers the
+// first error that has occured while writing, and then ignores all
+// the subsequent writes, so the caller only has to check for errors
+// once, at the very end. This is an internal helper for the method
+// Statistics.WritePrometheus; see it for the usage details.
+type promWriter struct { io.Writer; err error }
+
+// Write out formatted line; unless an error has occured previously.
+// This is the lowest level primitive of the Prometheus writer, which
+// all other methods of the writer are built upon. See the promWriter
+// for the details on the error handling strategy employed here.
+// Line terminator is being appended to the line automatically.
+func (p *promWriter) line(format string, args ...interface {}) {
+    if p.err != nil { return } // already failed
+    _, p.err = fmt.Fprintf(p.Writer, format + "\n", args...)
+}
+
+// Write out the HELP and TYPE header lines for the metric family with
+// the specified name. Every metric family must have a header written
+// before any of its samples, according to the exposition format. See
+// the Prometheus documentation on the text format for more details.
+// Type should be one of gauge, counter or histogram here.
+func (p *promWriter) header(name, kind, help string) {
+    p.line("# HELP %s %s", name, help) // describe
+    p.line("# TYPE %s %s", name, kind) // the type
+}
+
+// Write out a single sample of the metric with the specified name,
+// the preformatted labels and the value. Labels should be formatted
+// using promLabels function; or be an empty string if there are no
+// labels for the sample. Values are written in their shortest form,
+// as it is allowed by the Prometheus text exposition format.
+func (p *promWriter) sample(name, labels string, value float64) {
+    if len(labels) > 0 { labels = "{" + labels + "}" }
+    p.line("%s%s %v", name, labels, value) // sample
+}
+
+// Write out all the samples of a histogram with the specified name
+// and preformatted labels: the cumulative buckets, including the one
+// with infinite upper bound, as well as the sum and count samples. See
+// the Prometheus documentation on how histograms are represented in
+// the text exposition format. Labels could be an empty string.
+func (p *promWriter) histogram(name, labels string, h HistogramStats) {
+    var prefix string = labels // for bucket labels
+    if len(prefix) > 0 { prefix = prefix + "," }
+    for _, b := range h.Buckets { // cumulative
+        le := fmt.Sprintf("%sle=\"%v\"", prefix, b.UpperBound)
+        p.sample(name + "_bucket", le, float64(b.Count))
+    } // all the finite buckets have been written
+    inf := fmt.Sprintf("%sle=\"+Inf\"", prefix) // all
+    p.sample(name + "_bucket", inf, float64(h.Count))
+    p.sample(name + "_sum", labels, h.Sum) // seconds
+    p.sample(name + "_count", labels, float64(h.Count))
+}
+
+// Format pairs of label names and values as the label set of sample,
+// without the enclosing curly braces. Values are escaped according to
+// the Prometheus text exposition format: backslash, double quote and
+// line feed characters are escaped with a backslash. Arguments must be
+// in pairs of name followed by its value; see the usage for examples.
+func promLabels(pairs ...string) string {
+    escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+    var parts = make([]string, 0, len(pairs) / 2)
+    for i := 0; i + 1 < len(pairs); i += 2 { // pairs
+        value := escaper.Replace(pairs[i + 1]) // escape
+        parts = append(parts, fmt.Sprintf("%s=\"%s\"", pairs[i], value))
+    } // all the pairs have been formatted
+    return strings.Join(parts, ",") // label set
+}
+
+// Create and install a built-in service that exposes the statistics
+// of the application over HTTP, under the specified prefix. Service
+// has two endpoints: "json" serves the statistics as a JSON document
+// and "metrics" serves them in the Prometheus text exposition format.
+// The service is returned, so it could be further configured if needed.
+func (app *App) MountStatistics(prefix string) *Service {
+    return app.Service(func(srv *Service) {
+        srv.Prefix = prefix // mount point
+        srv.Endpoint(func(ep *Endpoint) {
+            ep.Pattern = "json" // JSON document
+            ep.Description = "statistics as JSON"
+            ep.Business = func(c *Context) {
+                const mime = "application/json"
+                c.Header().Set("Content-Type", mime)
+                encoder := json.NewEncoder(c) // writer
+                encoder.SetIndent("", "  ") // pretty
+                encoder.Encode(c.App.Statistics())
+            } // statistics have been served as JSON
+        }) // JSON endpoint has been mounted
+        srv.Endpoint(func(ep *Endpoint) {
+            ep.Pattern = "metrics" // Prometheus text
+            ep.Description = "statistics for Prometheus"
+            ep.Business = func(c *Context) {
+                const mime = "text/plain; version=0.0.4"
+                c.Header().Set("Content-Type", mime)
+                c.App.Statistics().WritePrometheus(c)
+            } // statistics have been served as text
+        }) // Prometheus endpoint has been mounted
+    })
+}
+
+// Snapshot of the statistics collected by an application instance.
+// Contains the operation statistics, server connection counts and the
+// CRON job run durations. This is a plain data structure, serializable
+// as JSON; and it's detached from the live data. It is built by the
+// App.Statistics method; please refer to it for more details.
+type Statistics struct {
+    Reference string `json:"ref"` // app instance
+    Uptime float64 `json:"uptime"` // in seconds
+    Operations []OperationStats `json:"operations"`
+    Servers []ServerStats `json:"servers"` // conns
+    Cron []CronStats `json:"cron"` // job durations
+}
+
+// Statistics of a single operation; either endpoint or aux op. It
+// is identified by the kind, service prefix and the name; which is the
+// mounted URL pattern for endpoints and the handle for aux operations.
+// Contains number of the in-flight applications and latency histogram
+// per every outcome that has been recorded for the operation so far.
+type OperationStats struct {
+    Kind string `json:"kind"` // endpoint or aux
+    Service string `json:"service"` // its prefix
+    Name string `json:"name"` // pattern or handle
+    InFlight int64 `json:"inFlight"` // in progress
+    Outcomes map[string] HistogramStats `json:"outcomes"`
+}
+
+// Statistics of the connections of a single application server, as
+// identified by the intent of the server, as it is specified in the
+// config. Contains the number of the currently active connections and
+// the total number of connections accepted since server was spawned.
+// Please refer to the App.Deploy method on how servers are spawned.
+type ServerStats struct {
+    Intent string `json:"intent"` // server identity
+    Active int64 `json:"active"` // currently open
+    Total uint64 `json:"total"` // accepted in total
+}
+
+// Statistics of a single CRON job; that is an aux operation that has
+// a CRON expression. It is identified by the service prefix and the
+// aux handle. Contains histogram of the durations of the job runs,
+// including all the runs regardless of their outcome. See the aux op
+// statistics within the OperationStats for the outcome breakdown.
+type CronStats struct {
+    Service string `json:"service"` // its prefix
+    Handle string `json:"handle"` // aux handle
+    Runs HistogramStats `json:"runs"` // durations
+}
+
+// Snapshot of a latency histogram. Contains the total count and sum
+// of the observations, along with the cumulative counts per bucket.
+// Sum is expressed in seconds; as well as upper bounds of the buckets.
+// Bucket with the infinite upper bound is not included, as its count
+// is always equal to the total count of the observations.
+type HistogramStats struct {
+    Count uint64 `json:"count"` // observations
+    Sum float64 `json:"sum"` // seconds in total
+    Buckets []BucketStats `json:"buckets"` // cumulative
+}
+
+// Single bucket of a latency histogram snapshot. Contains the upper
+// bound of the bucket, in seconds, and the cumulative count of all the
+// observations that are less than or equal to the upper bound. Please
+// refer to the HistogramStats for more details on histogram snapshot.
+// The buckets are defined by the LatencyBuckets variable.
+type BucketStats struct {
+    UpperBound float64 `json:"le"` // seconds
+    Count uint64 `json:"count"` // cumulative
+}
@@ -24,6 +24,136 @@
 package boot
 
 import "sync"
+import "sort"
+import "errors"
+import "fmt"
+
+// Error value to represent a situation when a storage does not have
+// any value stored under the requested key. The typed accessors will
+// wrap this value with the key that was looked up, so check for it
+// using the errors.Is function, rather than by comparing directly.
+// Please see the usage of this value by the framework for details.
+var StorageKeyMissing = errors.New("storage key is missing")
+
+// Error value to represent a situation when a value stored within a
+// storage under the requested key is not of the requested type. The
+// typed accessors will wrap this value with the key and types, so you
+// should check for it using the errors.Is function, not directly.
+// Please see the usage of this value by the framework for details.
+var StorageTypeMismatch = errors.New("storage value type mismatch")
+
+// Fetch the value stored under the specified key, with its type as
+// requested by the caller. If there is no such key, or if the stored
+// value is not of the requested type, the corresponding error will be
+// returned, along with the zero value of the type. This is preferred
+// way of accessing the storage, rather than type asserting by hand.
+func Load[T any](s *Storage, key string) (T, error) {
+    value, ok := s.Get(key) // fetch untyped value
+    return cast[T](key, value, ok) // assert type
+}
+
+// Fetch the value stored under the specified key, with its type as
+// requested by the caller; falling back from the context storage to
+// the service storage and then to the application storage. Please see
+// the Context.Lookup method for details on the fallback, and Load
+// function for details on the errors that could be returned.
+func Resolve[T any](c *Context, key string) (T, error) {
+    value, ok := c.Lookup(key) // fetch untyped value
+    return cast[T](key, value, ok) // assert type
+}
+
+// Assert that value fetched from the storage is of requested type.
+// Produces the descriptive errors for the typed storage accessors,
+// when there is either no value or it's not of the requested type.
+// Please refer to the Load and Resolve functions for the details.
+// This function is an internal helper, not intended for export.
+func cast[T any](key string, value interface {}, ok bool) (T, error) {
+    const emissing = "%w: %v" // key missing
+    const emismatch = "%w: %v is %T, not %T"
+    var zero T // zero value of requested type
+    if !ok { return zero, fmt.Errorf(emissing, StorageKeyMissing, key) }
+    typed, ok := value.(T) // check the type
+    if ok { return typed, nil } // type is fine
+    e := fmt.Errorf(emismatch, StorageTypeMismatch, key, value, zero)
+    return zero, e // value is of another type
+}
+
+// Fetch the value stored under the specified key. Second value that
+// is returned indicates whether the key was present in the storage or
+// not. Value is returned untyped; consider using the Load function if
+// you need a typed value. This method is safe for concurrent access,
+// as it does read-lock the storage for the duration of the access.
+func (s *Storage) Get(key string) (interface {}, bool) {
+    s.RLock(); defer s.RUnlock() // read-lock
+    value, ok := s.Container[key] // fetch
+    return value, ok // value and presence
+}
+
+// Store the value under the specified key, replacing any value that
+// might have been stored under the same key before. The underlying
+// container will be allocated if it has not been allocated yet. This
+// method is safe for concurrent access, as it does write-lock the
+// storage for the duration of the access. See Get method as well.
+func (s *Storage) Set(key string, value interface {}) {
+    s.Lock(); defer s.Unlock() // write-lock
+    if s.Container == nil { // not allocated?
+        s.Container = make(map[string] interface {})
+    } // container is allocated and ready to use
+    s.Container[key] = value // store the value
+}
+
+// Remove the value stored under the specified key, if there was any.
+// Removing a key that does not exist is not considered an error, the
+// method will simply do nothing in that case. This method is safe for
+// concurrent access, as it does write-lock the storage for the time
+// of the access. Please see Get and Set methods for more details.
+func (s *Storage) Delete(key string) {
+    s.Lock(); defer s.Unlock() // write-lock
+    delete(s.Container, key) // remove the key
+}
+
+// Fetch the value stored under the specified key; if there is no
+// value, then create it using the supplied initializer function and
+// store it under the key. Initializer is invoked under the storage
+// lock, so it is guaranteed to be invoked at most once per key. It
+// must not access the same storage, otherwise it will deadlock.
+func (s *Storage) GetOrCreate(key string, init func() interface {}) interface {} {
+    s.Lock(); defer s.Unlock() // write-lock
+    if value, ok := s.Container[key]; ok {
+        return value // value already exists
+    } // no value yet, need to create it
+    if s.Container == nil { // not allocated?
+        s.Container = make(map[string] interface {})
+    } // container is allocated and ready to use
+    value := init() // invoke the initializer
+    s.Container[key] = value // store the value
+    return value // value is freshly created
+}
+
+// Get all the keys that have values stored under them within this
+// storage. Keys are returned sorted in the lexicographical order, to
+// have a stable output. This method is safe for concurrent access, as
+// it does read-lock the storage for the duration of the access. Note
+// that the storage could have changed once the method has returned.
+func (s *Storage) Keys() []string {
+    s.RLock(); defer s.RUnlock() // read-lock
+    keys := make([]string, 0, len(s.Container))
+    for key := range s.Container { keys = append(keys, key) }
+    sort.Strings(keys) // have a stable order
+    return keys // sorted slice of keys
+}
+
+// Get a shallow copy of all the key/value records that are stored
+// within this storage. Modifying the returned map will not affect the
+// storage, although values themselves are not copied. This method is
+// safe for concurrent access, as it does read-lock the storage for the
+// duration of the access. Useful for inspection and debug purposes.
+func (s *Storage) Snapshot() map[string] interface {} {
+    s.RLock(); defer s.RUnlock() // read-lock
+    snapshot := make(map[string] interface {})
+    for k, v := range s.Container { snapshot[k] = v }
+    return snapshot // is a detached copy
+}
 
 // A general purpose storage that is intended as a frequently and
 // easily embeddable piece of functionality used by many structures
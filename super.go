@@ -24,6 +24,13 @@
 package boot
 
 import "runtime"
+import "strings"
+import "net/http"
+import "runtime/debug"
+import "errors"
+import "fmt"
+
+import stdctx "context"
 
 // Watchdog is a default implementation of the app supervisor to
 // be used out of the box, without having to write your own one. It
@@ -37,42 +44,232 @@ type Watchdog struct {}
 // the application. This method should respond to the client with
 // the corresponding message and optionally perform other, internal
 // routines, such as writing to the application journal.
-func (wd *Watchdog) EndpointNotFound(*Context) {}
+func (wd *Watchdog) EndpointNotFound(context *Context) {
+    const message = "no endpoint matches the request"
+    context.Journal.Warn("responding with not found")
+    status := http.StatusNotFound // 404
+    wd.respond(context, ErrorBody { Status: status, Message: message })
+}
 
 // Invoked when an incoming HTTP request could not be routed to an
 // endpoint because the application does not support an HTTP method
 // (also known as verb) that have been requested. This method should
 // respond to the client with the corresponding message and maybe
 // perform other, internal routines, such as write app journal.
-func (wd *Watchdog) MethodNotAllowed(*Context) {}
+func (wd *Watchdog) MethodNotAllowed(context *Context) {
+    const message = "request method is not allowed"
+    var path string = context.Request.URL.Path
+    allowed := context.App.allowedMethods(path)
+    log := context.Journal.WithField("allow", allowed)
+    log.Warn("responding with method not allowed")
+    header := strings.Join(allowed, ", ") // allowed
+    context.Header().Set("Allow", header) // advertise
+    status := http.StatusMethodNotAllowed // 405
+    wd.respond(context, ErrorBody { Status: status, Message: message })
+}
 
 // Invoked when an operation application has timed out. This could
 // have happened due to different reasons. This can happen for aux
 // operation as well as for endpoint. There is no strict algorithm
 // as to when this method will be called, as the issues could be
 // entirely handled within Operation and Pipeline coding.
-func (wd *Watchdog) OperationTimeout(*Context, Operation) {}
+func (wd *Watchdog) OperationTimeout(context *Context, op Operation) {
+    const message = "operation has timed out"
+    log := context.Journal.WithField("operation", op)
+    log = log.WithField("source", op.Definition())
+    log.Warn("operation application has timed out")
+    if _, ok := op.(*Endpoint); !ok { return } // aux
+    status := http.StatusGatewayTimeout // 504
+    wd.respond(context, ErrorBody { Status: status, Message: message })
+}
 
 // Invoked when an operation is not availe in a current env. Could
 // have happened due to different reasons. This can happen for aux
-// operation as well as for endpoint. There is no strict algorithm
-// as to when this method will be called, as the issues could be
-// entirely handled within Operation and Pipeline coding.
-func (wd *Watchdog) OperationUnavailable(*Context, Operation) {}
+// operation as well as for endpoint. When an endpoint denies access,
+// because the request carries no valid token, it is responded with
+// 401 and the bearer challenge; when the token is valid but does not
+// grant the required scopes or roles, it is responded with the 403.
+// Otherwise, the operation is deemed unavailable and 503 is used.
+func (wd *Watchdog) OperationUnavailable(context *Context, op Operation) {
+    const message = "operation is not available"
+    log := context.Journal.WithField("operation", op)
+    log = log.WithField("source", op.Definition())
+    ep, ok := op.(*Endpoint) // only endpoints respond
+    var reason error // access denied, if not nil
+    if ok { reason = ep.Satisfied(context) } // why?
+    if reason != nil { wd.deny(context, reason); return }
+    log.Warn("operation is not available to apply")
+    if !ok { return } // aux operations do not respond
+    status := http.StatusServiceUnavailable // 503
+    wd.respond(context, ErrorBody { Status: status, Message: message })
+}
+
+// Respond to the request that has been denied access to the endpoint,
+// due to the reason, as reported by the Endpoint.Satisfied method. If
+// there are no verified claims, the client is not authenticated; and
+// gets 401 with the WWW-Authenticate header, carrying the challenge of
+// the bearer scheme. Otherwise, the client is authenticated, but lacks
+// the required scopes or roles; and gets 403 with the same challenge.
+func (wd *Watchdog) deny(context *Context, reason error) {
+    const challenge = `Bearer error="%v", error_description="%v"`
+    log := context.Journal.WithError(reason) // why
+    status := http.StatusUnauthorized // 401 by default
+    var code string = "invalid_token" // RFC 6750 code
+    if context.Claims() != nil { // authenticated?
+        status = http.StatusForbidden // 403, but lacks
+        code = "insufficient_scope" // RFC 6750 code
+    } // status and code of the denial are determined
+    issue, _ := Load[error](&context.Storage, ClaimsIssueKey)
+    if status == http.StatusUnauthorized && issue == nil {
+        context.Header().Set("WWW-Authenticate", "Bearer")
+    } else { // a token was supplied, explain the reason
+        description := strings.ReplaceAll(reason.Error(), `"`, "'")
+        context.Header().Set("WWW-Authenticate",
+            fmt.Sprintf(challenge, code, description))
+    } // challenge of the bearer scheme is advertised
+    log.WithField("status", status).Warn("access to endpoint denied")
+    wd.respond(context, ErrorBody { Status: status,
+        Message: reason.Error() }) // the denial reason
+}
 
 // Invoked when an operation application has paniced. This could
 // have happened due to different reasons. This can happen for aux
 // operation as well as for endpoint. There is no strict algorithm
 // as to when this method will be called, as the issues could be
 // entirely handled within Operation and Pipeline coding.
-func (wd *Watchdog) OperationPaniced(*Context, Operation, error) {}
+func (wd *Watchdog) OperationPaniced(context *Context, op Operation, err error) {
+    const message = "operation has failed unexpectedly"
+    log := context.Journal.WithField("operation", op)
+    log = log.WithField("source", op.Definition())
+    log.WithError(err).Error("operation application paniced")
+    if _, ok := op.(*Endpoint); !ok { return } // aux
+    var details *ErrorDetails = nil // only if debug
+    if context.App.Debugging() { // expose details?
+        details = &ErrorDetails { Cause: err.Error() }
+        details.Source = op.Definition() // where
+        var pe *PanicError // carries stack trace
+        if errors.As(err, &pe) { details.Stack = pe.Stack }
+    } // details have been filled in, if need be
+    status := http.StatusInternalServerError // 500
+    wd.respond(context, ErrorBody { Status: status,
+        Message: message, Details: details }) // debug
+}
+
+// Invoked when an operation has been rejected, because its input did
+// not pass the validation, as declared by the Input of the endpoint.
+// The binding error carries the errors of all the offending fields.
+// This responds with the 400 Bad Request status code, along with the
+// errors of the fields, so the client could correct the request.
+func (wd *Watchdog) OperationInvalid(context *Context, op Operation, err *BindingError) {
+    const message = "request input is not valid"
+    log := context.Journal.WithField("operation", op)
+    log = log.WithField("source", op.Definition())
+    log.WithError(err).Warn("operation input is not valid")
+    if _, ok := op.(*Endpoint); !ok { return } // aux
+    status := http.StatusBadRequest // 400
+    wd.respond(context, ErrorBody { Status: status,
+        Message: message, Fields: err.Fields }) // fields
+}
+
+// Invoked when the body of an incoming HTTP request could not be read
+// into the input parameters, either because it exceeds the body limit
+// configured in app.binding, or because it is a malformed JSON or form.
+// This responds with the 413 Request Entity Too Large status code, or
+// the 400 Bad Request; the endpoint is not invoked in either case.
+func (wd *Watchdog) BodyRejected(context *Context, err error) {
+    var message string = "request body is malformed"
+    status := http.StatusBadRequest // 400 by default
+    var tooLarge *http.MaxBytesError // over limit?
+    if errors.As(err, &tooLarge) { // body is too big
+        message = "request body is too large" // 413
+        status = http.StatusRequestEntityTooLarge
+    } // status has been picked based on the error
+    log := context.Journal.WithField("status", status)
+    log.WithError(err).Warn("request body has been rejected")
+    wd.respond(context, ErrorBody { Status: status, Message: message })
+}
 
 // Invoked when the framework detects that the process has been
 // running out of the memory limits as configured for application.
 // It is then a responsibility of a supervisor to take (or not)
 // action, such as reboot or stop the application process and/or
 // notify the staff about a problem through available methods.
-func (wd *Watchdog) HittingMemLimits(*App, *runtime.MemStats) {}
+// The action to take is configured per memory pressure level, within
+// the app.limits section: soft-action and hard-action, being one of
+// "log", "gc" or "shutdown". By default, soft level forces the GC and
+// hard level initiates the graceful shutdown of the application.
+func (wd *Watchdog) HittingMemLimits(app *App, stats *runtime.MemStats) {
+    var level MemoryLevel = app.MemoryPressure()
+    var action string = app.limits.Load().action(level)
+    log := app.Journal.WithField("heap", stats.HeapAlloc)
+    log = log.WithField("sys", stats.Sys) // obtained
+    log = log.WithField("level", level) // pressure
+    log = log.WithField("action", action) // to take
+    log.Warn("application is hitting memory limits")
+    switch action { // the configured action
+        case "gc": debug.FreeOSMemory() // force GC
+        case "shutdown": go func() { // do not block
+            bg := stdctx.Background() // root context
+            grace := app.GracePeriod // allowed to drain
+            ctx, cancel := stdctx.WithTimeout(bg, grace)
+            defer cancel() // release context resources
+            app.Shutdown(ctx) // drain & tear app down
+        }() // shutdown is running in the background
+    } // action is taken; "log" means nothing else
+}
+
+// Respond to the HTTP request that the context represents with the
+// error envelope, that wraps the supplied error description; unless
+// the response has already been written. The envelope always carries
+// the reference of the context, so the client could report it and the
+// staff could find it in the journal. Format is negotiated by Accept.
+func (wd *Watchdog) respond(c *Context, body ErrorBody) {
+    if c.ResponseWriter == nil { return } // no HTTP
+    if c.Written() { // endpoint has already responded
+        c.Journal.Warn("response is already written")
+        return // do not write the second response
+    } // nothing has been written, respond with error
+    var w http.ResponseWriter = c.ResponseWriter
+    rw, ok := w.(*responder) // sealed by pipeline?
+    if ok && rw.Sealed() { w = rw.bypass() } // take over
+    body.Reference = c.Reference // context identity
+    envelope := ErrorEnvelope { Error: body } // wrap
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    c.render(w, body.Status, envelope) // negotiated
+}
+
+// Consistent envelope of the error responses that are produced by the
+// framework, in particular by the default Watchdog supervisor. Every
+// error response body is an object with a single "error" field, that
+// holds the error description. Please refer to the ErrorBody
+// struct for details on the fields of the error description.
+type ErrorEnvelope struct {
+    Error ErrorBody `json:"error" xml:"error"` // description
+}
+
+// Description of an error, as it is put into the error envelope. It
+// has the HTTP status code, human readable message, reference of the
+// context that the error has occured within, errors of the individual
+// input fields, if any, and optionally the error details, which are
+// only exposed in the debugging mode of the app; see App.Debugging.
+type ErrorBody struct {
+    Status int `json:"status" xml:"status"` // HTTP status code
+    Message string `json:"message" xml:"message"` // human readable
+    Reference string `json:"reference" xml:"reference"` // of context
+    Fields []FieldError `json:"fields,omitempty" xml:"field,omitempty"`
+    Details *ErrorDetails `json:"details,omitempty" xml:"details,omitempty"`
+}
+
+// Details of an error, that are only exposed to the HTTP clients in
+// the debugging mode of the application, as they may be sensitive. It
+// contains the cause of the error, the source location of operation
+// where the error has occured and the stack trace, if it is available.
+// Please refer to the App.Debugging method for more details on that.
+type ErrorDetails struct {
+    Cause string `json:"cause" xml:"cause"` // error message
+    Source SourceLocation `json:"source" xml:"source"` // operation
+    Stack string `json:"stack,omitempty" xml:"stack,omitempty"` // trace
+}
 
 // Supervisor is responsible for handling issues that might occur
 // during the normal operation mode. These issues are typically needed
@@ -123,3 +320,33 @@ type Supervisor interface {
     // notify the staff about a problem through available methods.
     HittingMemLimits(*App, *runtime.MemStats)
 }
+
+// Optional extension of the Supervisor interface, for supervisors that
+// want to handle the rejected input of operations on their own. It is
+// discovered by the type assertion, so that the existing supervisors
+// keep on satisfying the Supervisor interface. The supervisors that do
+// not implement it get the rejected input handled by the Watchdog.
+type InputSupervisor interface {
+
+    // Invoked when an operation has been rejected, because its input
+    // did not pass the validation, as it is declared by the operation.
+    // The binding error carries the errors of all offending fields. It
+    // is a responsibility of a supervisor to report these back to the
+    // client, if the operation is an endpoint serving HTTP request.
+    OperationInvalid(*Context, Operation, *BindingError)
+}
+
+// Optional extension of the Supervisor interface, for supervisors that
+// want to handle the rejected HTTP request bodies on their own. It is
+// discovered by the type assertion, so that the existing supervisors
+// keep on satisfying the Supervisor interface. The supervisors that do
+// not implement it get the rejected bodies handled by the Watchdog.
+type BodySupervisor interface {
+
+    // Invoked when the body of an incoming HTTP request could not be
+    // read into the input parameters, because it is either too large
+    // or malformed; the error tells which one, see http.MaxBytesError.
+    // It is a responsibility of a supervisor to respond to the client,
+    // since the endpoint that the request has matched is not invoked.
+    BodyRejected(*Context, error)
+}
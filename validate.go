@@ -0,0 +1,288 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package boot
+
+import "time"
+import "sync"
+import "regexp"
+import "reflect"
+import "strings"
+import "strconv"
+import "unicode/utf8"
+import "fmt"
+
+// Key of the context storage, under which the validated input of the
+// endpoint is stored, when the endpoint declares its Input. The value
+// is a pointer to a new instance of the Input struct type, with all
+// the parameters bound into it. Please use Resolve or Load functions
+// to fetch it, using the pointer to Input struct as the type.
+const InputKey = "boot.input"
+
+// Description of a single input parameter of an endpoint, as derived
+// from the Input struct declared by the endpoint. It is used to expose
+// the input of the endpoint in the inventory and documentation of the
+// app. Location is either path, query or body; type is a JSON Schema
+// type name. Rules are taken verbatim from the validate tag.
+type InputField struct {
+    Name string `json:"name"` // parameter name
+    In string `json:"in"` // path, query or body
+    Type string `json:"type"` // JSON Schema type
+    Format string `json:"format,omitempty"` // hint
+    Required bool `json:"required"` // mandatory?
+    Rules map[string] string `json:"rules,omitempty"`
+}
+
+// Obtain the struct type of the input that the endpoint declares. The
+// Input could either be a struct value or a pointer to struct; only
+// its type matters, the value itself is never modified. Panics if it
+// is neither of these, since that is a programming error that should
+// be caught early on, when the endpoint is being defined.
+func inputType(input interface {}) reflect.Type {
+    const einput = "endpoint input must be a struct"
+    kind := reflect.TypeOf(input) // of the prototype
+    if kind != nil && kind.Kind() == reflect.Ptr {
+        kind = kind.Elem() // pointer to a struct
+    } // got what supposed to be the struct type
+    if kind == nil || kind.Kind() != reflect.Struct {
+        panic(einput) // programming error
+    } // the input is of the struct type indeed
+    return kind // type to bind the input into
+}
+
+// Validate the input of the HTTP request against the Input declared
+// by the endpoint, if any. A fresh instance of the Input struct type
+// is allocated and the parameters of the context are bound into it.
+// If the binding succeeds, the instance is stored in the context under
+// InputKey; otherwise the BindingError with all offenders is returned.
+func (ep *Endpoint) validate(context *Context) error {
+    if ep.Input == nil { return nil } // no input
+    input := reflect.New(inputType(ep.Input))
+    err := context.Bind(input.Interface()) // bind
+    if err != nil { return err } // invalid input
+    context.Set(InputKey, input.Interface())
+    return nil // input is valid and stored
+}
+
+// Describe the input of the endpoint, as declared by the Input struct
+// type. Every bindable field becomes one input parameter. Location is
+// derived from the endpoint pattern placeholders and the methods: any
+// placeholder is a path parameter; other parameters go in the query
+// string for GET, HEAD and DELETE endpoints, or in the body otherwise.
+// It could be overriden with the in tag of the field, for example,
+// `in:"query"`. Panics if the validate tags are malformed.
+func (ep *Endpoint) inputFields() []InputField {
+    fields := make([]InputField, 0) // none yet
+    if ep.Input == nil { return fields } // none
+    inPath := make(map[string] bool) // route params
+    for _, name := range placeholders(ep.Pattern) { inPath[name] = true }
+    var location string = "query" // no body methods
+    for m, ok := range ep.Methods { // any with body?
+        if !ok { continue } // method is disabled
+        if m != "GET" && m != "HEAD" && m != "DELETE" {
+            location = "body"; break // carries body
+        } // method is expected to have the body
+    } // default location has been determined
+    var walk func(kind reflect.Type) // recursive
+    walk = func(kind reflect.Type) { // struct type
+        for i := 0; i < kind.NumField(); i++ {
+            field := kind.Field(i) // definition
+            if field.PkgPath != "" && !field.Anonymous { continue }
+            if field.Anonymous && field.Type.Kind() == reflect.Struct {
+                walk(field.Type); continue // embedded
+            } // it is a regular, exported struct field
+            name, required := bindTag(field) // param
+            if name == "" { continue } // not bindable
+            record := InputField { Name: name, In: location }
+            record.Required = required // mandatory?
+            record.Type, record.Format = schemaType(field.Type)
+            record.Rules = parseRules(field) // as is
+            if inPath[name] { record.In = "path" }
+            if in := field.Tag.Get("in"); in != "" { record.In = in }
+            fields = append(fields, record) // got it
+        } // all the fields have been described
+    } // closure that walks the struct fields
+    walk(inputType(ep.Input)); return fields
+}
+
+// Map the Go type of the input field to the JSON Schema type name and
+// an optional format hint. Pointers are dereferenced; durations are
+// represented as strings with the duration format; slices and arrays
+// are arrays; maps and structs are objects. This is used to describe
+// the input parameters in the inventory and documentation.
+func schemaType(kind reflect.Type) (string, string) {
+    for kind.Kind() == reflect.Ptr { kind = kind.Elem() }
+    if kind == reflect.TypeOf(time.Duration(0)) {
+        return "string", "duration" // as in "5s"
+    } // not a duration, go by the kind of type
+    switch kind.Kind() { // JSON Schema types
+        case reflect.String: return "string", ""
+        case reflect.Bool: return "boolean", ""
+        case reflect.Int8, reflect.Int16, reflect.Int32,
+            reflect.Uint8, reflect.Uint16: return "integer", "int32"
+        case reflect.Int, reflect.Int64, reflect.Uint,
+            reflect.Uint32, reflect.Uint64: return "integer", "int64"
+        case reflect.Float32: return "number", "float"
+        case reflect.Float64: return "number", "double"
+        case reflect.Slice, reflect.Array: return "array", ""
+        default: return "object", "" // map or struct
+    }
+}
+
+// Pattern of the beginning of the next rule within the validate tag;
+// that is the name followed by the equals sign. Commas that are not
+// followed by it belong to the argument of the previous rule instead.
+var ruleStart = regexp.MustCompile(`^\s*[a-zA-Z]+\s*=`)
+
+// Cache of the rules that have been parsed out of the validate tags,
+// keyed by the text of the tag. Rules are checked on every bound HTTP
+// request and every decoded config section; so the tags are parsed and
+// the patterns are compiled once, upon the first use of every tag, as
+// the endpoints and config structs are defined by the application.
+var ruleCache sync.Map
+
+// Rules declared by the validate tag of the struct field, as parsed by
+// the compileRules function. The declared map holds the arguments of
+// the rules verbatim, by name; the rest are the parsed arguments, that
+// are ready to be checked against the values. See parseRules function.
+type fieldRules struct {
+    declared map[string] string // verbatim
+    min, max float64 // bounds, when declared
+    pattern *regexp.Regexp // compiled, if any
+    enum []string // allowed values, if any
+}
+
+// Parse the validate tag of the struct field into a map of rules. The
+// tag is a comma separated list of rules, each being a name=argument
+// pair: min and max (numeric bounds or durations, lengths of strings),
+// pattern (regular expression) and enum (values separated with |).
+// Panics if the tag is malformed, since it is a programming error.
+// The map is a copy of the cached rules; see compileRules function.
+func parseRules(field reflect.StructField) map[string] string {
+    rules := compileRules(field) // parsed and cached
+    if rules == nil { return nil } // no rules at all
+    declared := make(map[string] string, len(rules.declared))
+    for k, v := range rules.declared { declared[k] = v }
+    return declared // a copy, safe to modify
+}
+
+// Parse the validate tag of the struct field and compile its rules,
+// or get them from the cache, if the same tag has been parsed before.
+// The tag is split only at the commas that start the next rule; so the
+// patterns could contain commas, such as ^[a-z]{1,3}$. Returns nil if
+// there are no rules. Panics if the tag is malformed; see parseRules.
+func compileRules(field reflect.StructField) *fieldRules {
+    const erule = "malformed validate tag of %v: %v"
+    tag := strings.TrimSpace(field.Tag.Get("validate"))
+    if tag == "" { return nil } // no rules at all
+    if cached, ok := ruleCache.Load(tag); ok {
+        return cached.(*fieldRules) // parsed once
+    } // tag has not been parsed yet, do it now
+    var pieces []string = nil // rules of the tag
+    for _, piece := range strings.Split(tag, ",") {
+        if len(pieces) > 0 && !ruleStart.MatchString(piece) {
+            pieces[len(pieces) - 1] += "," + piece
+            continue // comma within the argument
+        } // piece is the beginning of the next rule
+        pieces = append(pieces, piece) // new rule
+    } // the tag has been split into the rules
+    rules := &fieldRules { declared: make(map[string] string) }
+    for _, rule := range pieces { // parse each one
+        name, arg, ok := strings.Cut(rule, "=")
+        name = strings.TrimSpace(name) // rule
+        arg = strings.TrimSpace(arg) // argument
+        var err error = nil // argument is good?
+        switch name { // check the argument
+            case "min": rules.min, err = parseBound(arg)
+            case "max": rules.max, err = parseBound(arg)
+            case "pattern": rules.pattern, err = regexp.Compile(arg)
+            case "enum": rules.enum = strings.Split(arg, "|")
+                if arg == "" { ok = false } // no values
+            default: ok = false // unknown rule
+        } // argument of the rule has been checked
+        if !ok || err != nil { // something is off
+            panic(fmt.Errorf(erule, field.Name, rule))
+        } // rule is good, store it into the map
+        rules.declared[name] = arg // argument as is
+    } // all the rules have been parsed
+    cached, _ := ruleCache.LoadOrStore(tag, rules)
+    return cached.(*fieldRules) // ready to check
+}
+
+// Parse the argument of the min or max rule into the numeric bound. It
+// is either a number, or a duration, such as 100ms, for the fields of
+// the time.Duration type; which is taken as a number of nanoseconds,
+// the same way the durations are measured by the checkRules function.
+func parseBound(arg string) (float64, error) {
+    bound, err := strconv.ParseFloat(arg, 64)
+    if err == nil { return bound, nil } // number
+    duration, derr := time.ParseDuration(arg)
+    if derr != nil { return 0, err } // neither
+    return float64(duration), nil // nanoseconds
+}
+
+// Check the value that has been bound into the struct field against
+// the rules declared by the validate tag of that field. Returns an
+// error describing the first rule that has been violated, if any. The
+// bounds apply to numbers by value; and to strings, slices and maps
+// by length. Pattern and enum apply to the textual representation.
+func checkRules(field reflect.StructField, value reflect.Value) error {
+    rules := compileRules(field) // cached rules
+    if rules == nil { return nil } // nothing
+    for value.Kind() == reflect.Ptr { // pointee
+        if value.IsNil() { return nil } // absent
+        value = value.Elem() // check the pointee
+    } // got to the actual value of the field
+    var measure float64 // number or the length
+    var text string = fmt.Sprint(value.Interface())
+    switch value.Kind() { // measure the value
+        case reflect.Int, reflect.Int8, reflect.Int16,
+            reflect.Int32, reflect.Int64: // signed
+            measure = float64(value.Int())
+        case reflect.Uint, reflect.Uint8, reflect.Uint16,
+            reflect.Uint32, reflect.Uint64: // unsigned
+            measure = float64(value.Uint())
+        case reflect.Float32, reflect.Float64: // real
+            measure = value.Float() // as it is
+        case reflect.String: // length in characters
+            measure = float64(utf8.RuneCountInString(text))
+        case reflect.Slice, reflect.Array, reflect.Map:
+            measure = float64(value.Len()) // items
+    } // the value has been measured, if applicable
+    if arg, ok := rules.declared["min"]; ok { // lower bound
+        if measure < rules.min { return fmt.Errorf("must be at least %v", arg) }
+    } // lower bound is satisfied, if declared
+    if arg, ok := rules.declared["max"]; ok { // upper bound
+        if measure > rules.max { return fmt.Errorf("must be at most %v", arg) }
+    } // upper bound is satisfied, if declared
+    if rules.pattern != nil { // regular expression
+        if !rules.pattern.MatchString(text) { // mismatch
+            return fmt.Errorf("must match %v", rules.declared["pattern"])
+        } // the value matches the pattern
+    } // pattern is satisfied, if declared
+    if rules.enum != nil { // one of the values
+        for _, o := range rules.enum { if o == text { return nil } }
+        return fmt.Errorf("must be one of %v", strings.Join(rules.enum, ", "))
+    } // enumeration is satisfied, if declared
+    return nil // all the rules are satisfied
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2015, Alexander Cherniuk <ts33kr@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+
+package boot
+
+import "time"
+import "testing"
+import "reflect"
+
+func TestParseRules(t *testing.T) {
+    var cases = []struct {
+        tag string // the validate tag of the field
+        want map[string] string // nil if it must panic
+    } {
+        { "", map[string] string {} },
+        { "min=1", map[string] string { "min": "1" } },
+        { "min=0, max=0.99", map[string] string { "min": "0", "max": "0.99" } },
+        { "pattern=^[a-z]+$", map[string] string { "pattern": "^[a-z]+$" } },
+        { "enum=gc|shutdown|log", map[string] string { "enum": "gc|shutdown|log" } },
+        { "pattern=^[a-z]{1,3}$", map[string] string { "pattern": "^[a-z]{1,3}$" } },
+        { "min=1,pattern=^(a|b){1,2}$,max=2", map[string] string { "min": "1", "pattern": "^(a|b){1,2}$", "max": "2" } },
+        { "min=1ms,max=1h", map[string] string { "min": "1ms", "max": "1h" } },
+        { "pattern=^[a-z]{1,3}$,unknown=1", nil }, { "min=1xs", nil }, { "min=1,max", nil },
+        { "min=one", nil }, { "pattern=[", nil }, { "enum=", nil },
+        { "unknown=1", nil }, { "min", nil },
+    } // tags and the rules they declare
+    for _, c := range cases { // parse every tag
+        field := reflect.StructField { Name: "Field" }
+        field.Tag = reflect.StructTag(`validate:"` + c.tag + `"`)
+        got, panicked := func() (rules map[string] string, panicked bool) {
+            defer func() { panicked = recover() != nil }()
+            return parseRules(field), false // may panic
+        }() // parsed the tag, or it has paniced
+        if panicked != (c.want == nil) { // unexpected
+            t.Errorf("tag %q paniced: %v", c.tag, panicked)
+            continue // nothing else to check here
+        } // the tag is parsed or rejected as expected
+        if len(got) > 0 && !reflect.DeepEqual(got, c.want) || len(got) != len(c.want) {
+            t.Errorf("tag %q parsed into %v, want %v", c.tag, got, c.want)
+        } // the rules are as expected
+    }
+}
+
+func TestCheckRules(t *testing.T) {
+    type input struct {
+        Code string `validate:"pattern=^[a-z]{1,3}$"`
+        Size int `validate:"min=1,max=10"`
+        Mode string `validate:"enum=gc|log"`
+        Tags []string `validate:"max=2"`
+        Note *string `validate:"min=2"`
+        Wait time.Duration `validate:"min=1ms"`
+    } // fields with the rules of every kind
+    var cases = []struct {
+        field string // name of the field to check
+        value interface {} // value bound into it
+        message string // empty if the value is good
+    } {
+        { "Code", "ab", "" }, { "Code", "abcd", "must match ^[a-z]{1,3}$" },
+        { "Size", 1, "" }, { "Size", 0, "must be at least 1" },
+        { "Size", 11, "must be at most 10" }, { "Mode", "log", "" },
+        { "Mode", "off", "must be one of gc, log" },
+        { "Tags", []string { "a", "b" }, "" },
+        { "Tags", []string { "a", "b", "c" }, "must be at most 2" },
+        { "Note", (*string)(nil), "" },
+        { "Wait", time.Millisecond, "" },
+        { "Wait", time.Nanosecond, "must be at least 1ms" },
+    } // values and the violations they make
+    kind := reflect.TypeOf(input {}) // fields
+    for _, c := range cases { // check every one
+        field, _ := kind.FieldByName(c.field)
+        value := reflect.New(field.Type).Elem() // zero
+        value.Set(reflect.ValueOf(c.value)) // bound
+        var message string // empty if no violation
+        if err := checkRules(field, value); err != nil { message = err.Error() }
+        if message != c.message { t.Errorf("%v of %v is %q, want %q", c.field, c.value, message, c.message) }
+    }
+}